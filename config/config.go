@@ -1,11 +1,15 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
+
+	"github.com/aaronriekenberg/pi-web/logging"
 )
 
 type TLSInfo struct {
@@ -26,7 +30,8 @@ func (httpServerTimeouts *HTTPServerTimeouts) ApplyToHTTPServer(httpServer *http
 	httpServer.ReadTimeout = time.Duration(httpServerTimeouts.ReadTimeoutMilliseconds) * time.Millisecond
 	httpServer.WriteTimeout = time.Duration(httpServerTimeouts.WriteTimeoutMilliseconds) * time.Millisecond
 
-	log.Printf("set httpServer.ReadTimeout = %v httpServer.WriteTimeout = %v", httpServer.ReadTimeout, httpServer.WriteTimeout)
+	logging.Info("set http server timeouts",
+		"readTimeout", httpServer.ReadTimeout, "writeTimeout", httpServer.WriteTimeout)
 }
 
 type HTTP3ServerInfo struct {
@@ -59,6 +64,25 @@ type PprofInfo struct {
 	Enabled bool `json:"enabled"`
 }
 
+// MetricsInfo serves metrics in the Prometheus text format on /metrics if Enabled.
+// If AllowedNetworks, which are IP addresses or CIDR blocks, is not empty only
+// clients in them may fetch metrics.  /metrics is also subject to AuthInfo.
+type MetricsInfo struct {
+	Enabled         bool     `json:"enabled"`
+	AllowedNetworks []string `json:"allowedNetworks,omitempty"`
+}
+
+// SystemInfo serves the host's load average, memory, CPU usage, temperatures,
+// filesystems, and network interfaces on /system.html and /api/system.  They are read
+// from ProcRoot (default /proc) and SysRoot (default /sys), which may be set to
+// directories of fixture files for testing.  Mounts are the mount points whose
+// filesystem usage is reported.
+type SystemInfo struct {
+	ProcRoot string   `json:"procRoot,omitempty"`
+	SysRoot  string   `json:"sysRoot,omitempty"`
+	Mounts   []string `json:"mounts,omitempty"`
+}
+
 type StaticFileInfo struct {
 	HTTPPath             string `json:"httpPath"`
 	FilePath             string `json:"filePath"`
@@ -73,50 +97,395 @@ type StaticDirectoryInfo struct {
 	IncludeInMainPage bool   `json:"includeInMainPage"`
 }
 
+const (
+	CommandParameterTypeEnum     = "enum"
+	CommandParameterTypeInteger  = "integer"
+	CommandParameterTypeString   = "string"
+	CommandParameterTypeHostname = "hostname"
+)
+
+// CommandParameterInfo describes a named parameter whose value is taken from the
+// request query string and substituted for {{name}} in CommandInfo.Args.
+// Values allows for type enum, Min and Max for type integer, and Pattern for type string.
+// If Default is nil the parameter is required.  Integer and string values starting
+// with - are rejected so they are not taken as options, unless AllowLeadingDash.
+type CommandParameterInfo struct {
+	Name             string   `json:"name"`
+	Description      string   `json:"description"`
+	Type             string   `json:"type"`
+	Values           []string `json:"values,omitempty"`
+	Min              *int64   `json:"min,omitempty"`
+	Max              *int64   `json:"max,omitempty"`
+	Pattern          string   `json:"pattern,omitempty"`
+	Default          *string  `json:"default,omitempty"`
+	AllowLeadingDash bool     `json:"allowLeadingDash,omitempty"`
+}
+
+// CommandScheduleInfo runs a command in the background every IntervalMilliseconds
+// or on a 5 field Cron expression, keeping the last HistorySize results.
+// If PersistHistory is true the results are also saved to a file in
+// CommandConfiguration.HistoryDirectory and reloaded at startup.
+type CommandScheduleInfo struct {
+	IntervalMilliseconds int    `json:"intervalMilliseconds,omitempty"`
+	Cron                 string `json:"cron,omitempty"`
+	HistorySize          int    `json:"historySize"`
+	PersistHistory       bool   `json:"persistHistory,omitempty"`
+}
+
+// CommandEnvironmentInfo replaces the environment a command inherits from pi-web.
+// Only the pi-web environment variables named in Inherit are passed to the command,
+// then Variables are added, overriding inherited values.
+type CommandEnvironmentInfo struct {
+	Inherit   []string          `json:"inherit,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// CommandCredentialInfo runs a command as UID and GID with supplementary Groups.
+// pi-web must be running as root to use a different UID or GID.
+type CommandCredentialInfo struct {
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups,omitempty"`
+}
+
+// CommandResourceLimitsInfo sets resource limits (rlimits) for a command.
+// A limit of 0 is not set.
+//
+// Processes is RLIMIT_NPROC, which limits the processes and threads of the
+// command's uid rather than of the command, so it requires a Credential with
+// a uid that runs nothing but the command.
+type CommandResourceLimitsInfo struct {
+	CPUSeconds        uint64 `json:"cpuSeconds,omitempty"`
+	AddressSpaceBytes uint64 `json:"addressSpaceBytes,omitempty"`
+	OpenFiles         uint64 `json:"openFiles,omitempty"`
+	Processes         uint64 `json:"processes,omitempty"`
+}
+
+// CommandInfo describes a command.  If WorkingDirectory, Environment, Credential,
+// or ResourceLimits are not set the command runs in pi-web's working directory,
+// with pi-web's environment, user, and limits.
 type CommandInfo struct {
-	ID          string   `json:"id"`
-	Description string   `json:"description"`
-	Command     string   `json:"command"`
-	Args        []string `json:"args"`
+	ID                         string                     `json:"id"`
+	Description                string                     `json:"description"`
+	Command                    string                     `json:"command"`
+	Args                       []string                   `json:"args"`
+	Parameters                 []CommandParameterInfo     `json:"parameters,omitempty"`
+	CacheTTLMilliseconds       int                        `json:"cacheTTLMilliseconds,omitempty"`
+	RequestTimeoutMilliseconds int                        `json:"requestTimeoutMilliseconds,omitempty"`
+	Schedule                   *CommandScheduleInfo       `json:"schedule,omitempty"`
+	WorkingDirectory           string                     `json:"workingDirectory,omitempty"`
+	Environment                *CommandEnvironmentInfo    `json:"environment,omitempty"`
+	Credential                 *CommandCredentialInfo     `json:"credential,omitempty"`
+	ResourceLimits             *CommandResourceLimitsInfo `json:"resourceLimits,omitempty"`
+	MaxOutputBytes             int64                      `json:"maxOutputBytes,omitempty"`
 }
 
+// DefaultMinScheduleInterval is the shortest time allowed between scheduled runs of
+// a command if CommandConfiguration.MinScheduleIntervalMilliseconds is not set.  It is
+// the shortest cron interval, so by default only shorter intervalMilliseconds are rejected.
+const DefaultMinScheduleInterval = time.Minute
+
+// CommandConfiguration configures running commands.  Streamed runs are limited to
+// StreamTimeoutMilliseconds, or 5 minutes if it is zero, instead of
+// RequestTimeoutMilliseconds.  Schedules running a command more often than
+// MinScheduleIntervalMilliseconds are rejected.
 type CommandConfiguration struct {
 	MaxConcurrentCommands               int64         `json:"maxConcurrentCommands"`
 	RequestTimeoutMilliseconds          int           `json:"requestTimeoutMilliseconds"`
 	SemaphoreAcquireTimeoutMilliseconds int           `json:"semaphoreAcquireTimeoutMilliseconds"`
+	StreamTimeoutMilliseconds           int           `json:"streamTimeoutMilliseconds"`
+	MinScheduleIntervalMilliseconds     int           `json:"minScheduleIntervalMilliseconds,omitempty"`
+	HistoryDirectory                    string        `json:"historyDirectory,omitempty"`
+	MaxOutputBytes                      int64         `json:"maxOutputBytes,omitempty"`
 	Commands                            []CommandInfo `json:"commands"`
 }
 
+// MinScheduleInterval returns MinScheduleIntervalMilliseconds if set, otherwise
+// DefaultMinScheduleInterval.
+func (commandConfiguration *CommandConfiguration) MinScheduleInterval() time.Duration {
+	if commandConfiguration.MinScheduleIntervalMilliseconds > 0 {
+		return time.Duration(commandConfiguration.MinScheduleIntervalMilliseconds) * time.Millisecond
+	}
+	return DefaultMinScheduleInterval
+}
+
+const (
+	ProxyModeJSON    = "json"
+	ProxyModeReverse = "reverse"
+)
+
+// ProxyTLSInfo configures TLS to a proxy upstream.  CAFile is a PEM bundle of
+// CA certificates to trust instead of the system roots.  CertFile and KeyFile
+// are a client certificate.
+type ProxyTLSInfo struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// ProxyAssertionsInfo are expectations checked against each json mode proxy response.
+// If StatusCodes is empty any status is accepted.  JSONPath is a path such as
+// $.status or items[0].healthy into the JSON response body; the value there must
+// equal JSONValue, or if JSONValue is not set just exist.
+type ProxyAssertionsInfo struct {
+	StatusCodes            []int       `json:"statusCodes,omitempty"`
+	BodyRegex              string      `json:"bodyRegex,omitempty"`
+	JSONPath               string      `json:"jsonPath,omitempty"`
+	JSONValue              interface{} `json:"jsonValue,omitempty"`
+	MaxLatencyMilliseconds int         `json:"maxLatencyMilliseconds,omitempty"`
+}
+
+// ProxyRetryInfo retries json mode requests with idempotent methods that fail with
+// an error or a 502, 503, or 504 status, up to MaxAttempts attempts in total.  The
+// wait before the second attempt is InitialBackoffMilliseconds, doubling after each
+// attempt up to MaxBackoffMilliseconds.  All attempts share the proxy timeout.
+type ProxyRetryInfo struct {
+	MaxAttempts                int `json:"maxAttempts"`
+	InitialBackoffMilliseconds int `json:"initialBackoffMilliseconds"`
+	MaxBackoffMilliseconds     int `json:"maxBackoffMilliseconds,omitempty"`
+}
+
+// ProxyCircuitBreakerInfo opens a json mode proxy's circuit after FailureThreshold
+// consecutive failed requests, where a failure is an error or a 5xx status.  While
+// open requests fail immediately.  After OpenMilliseconds one probe request is let
+// through, which closes the circuit if it succeeds and reopens it if not.  While the
+// circuit is open the upstream is also checked in the background every
+// ProbeIntervalMilliseconds, default OpenMilliseconds, so the circuit closes without
+// waiting for a request.
+type ProxyCircuitBreakerInfo struct {
+	FailureThreshold          int `json:"failureThreshold"`
+	OpenMilliseconds          int `json:"openMilliseconds"`
+	ProbeIntervalMilliseconds int `json:"probeIntervalMilliseconds,omitempty"`
+}
+
+// ProxyInfo describes a proxy.  In json mode (the default) pi-web makes a Method
+// (default GET) request with Body to URL and shows the response on /proxies/<id>.html.
+// In reverse mode requests under PathPrefix (default /proxies/<id>/) are forwarded
+// to URL.  AllowedMethods are allowed in addition to GET and HEAD.
+//
+// In both modes Headers are added to upstream requests, and the Authorization
+// header is set to the contents of AuthorizationFile if set.  Upstream requests
+// use HTTP/3 if HTTP3 is true, or connect to UnixSocket instead of the URL host if set.
+//
+// In json mode JSON, XML, and HTML responses are formatted for display.  If Extract
+// is set it is a path such as .status.version, and only that part of a JSON body is shown.
+// Retry and CircuitBreaker control json mode behavior when the upstream fails.
 type ProxyInfo struct {
-	ID          string `json:"id"`
-	Description string `json:"description"`
-	URL         string `json:"url"`
+	ID                  string                   `json:"id"`
+	Description         string                   `json:"description"`
+	URL                 string                   `json:"url"`
+	TimeoutMilliseconds int                      `json:"timeoutMilliseconds,omitempty"`
+	MaxBodyBytes        int64                    `json:"maxBodyBytes,omitempty"`
+	Mode                string                   `json:"mode,omitempty"`
+	PathPrefix          string                   `json:"pathPrefix,omitempty"`
+	AllowedMethods      []string                 `json:"allowedMethods,omitempty"`
+	Method              string                   `json:"method,omitempty"`
+	Headers             map[string]string        `json:"headers,omitempty"`
+	AuthorizationFile   string                   `json:"authorizationFile,omitempty"`
+	Body                string                   `json:"body,omitempty"`
+	TLS                 *ProxyTLSInfo            `json:"tls,omitempty"`
+	HTTP3               bool                     `json:"http3,omitempty"`
+	UnixSocket          string                   `json:"unixSocket,omitempty"`
+	Assertions          *ProxyAssertionsInfo     `json:"assertions,omitempty"`
+	Extract             string                   `json:"extract,omitempty"`
+	Retry               *ProxyRetryInfo          `json:"retry,omitempty"`
+	CircuitBreaker      *ProxyCircuitBreakerInfo `json:"circuitBreaker,omitempty"`
+}
+
+// RequestMethod returns Method if set, otherwise GET.
+func (proxyInfo *ProxyInfo) RequestMethod() string {
+	if len(proxyInfo.Method) > 0 {
+		return proxyInfo.Method
+	}
+	return "GET"
+}
+
+func (proxyInfo *ProxyInfo) IsReverse() bool {
+	return proxyInfo.Mode == ProxyModeReverse
+}
+
+// ReversePathPrefix returns PathPrefix if set, otherwise /proxies/<id>/
+func (proxyInfo *ProxyInfo) ReversePathPrefix() string {
+	if len(proxyInfo.PathPrefix) > 0 {
+		return proxyInfo.PathPrefix
+	}
+	return "/proxies/" + proxyInfo.ID + "/"
+}
+
+const (
+	AuthPolicyPublic        = "public"
+	AuthPolicyAuthenticated = "authenticated"
+)
+
+// AuthUserInfo is a user for HTTP Basic authentication.  PasswordHash is a bcrypt
+// hash such as printed by "pi-web hash-password".
+type AuthUserInfo struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"`
+}
+
+// AuthTokenInfo is a static bearer token for scripts.  The token is the contents of
+// TokenFile, and requests using it are made as user Name.
+type AuthTokenInfo struct {
+	Name      string `json:"name"`
+	TokenFile string `json:"tokenFile"`
+}
+
+// AuthTrustedHeaderInfo takes the user name from Header, as set by a reverse proxy
+// that has already authenticated the user.  The header is only trusted on requests
+// from TrustedProxies, which are IP addresses or CIDR blocks.
+type AuthTrustedHeaderInfo struct {
+	Header         string   `json:"header"`
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
+// AuthRouteInfo sets Policy for requests to Path.  A Path ending in * matches every
+// path starting with the rest of it, otherwise it matches only itself.
+type AuthRouteInfo struct {
+	Path   string `json:"path"`
+	Policy string `json:"policy"`
+}
+
+// AuthRoleInfo grants access to commands and proxies with IDs matching CommandIDs
+// and ProxyIDs, which are glob patterns such as net_* or *, and to
+// other HTTP paths matching Paths, which match like AuthRouteInfo.Path.
+type AuthRoleInfo struct {
+	Name       string   `json:"name"`
+	CommandIDs []string `json:"commandIDs,omitempty"`
+	ProxyIDs   []string `json:"proxyIDs,omitempty"`
+	Paths      []string `json:"paths,omitempty"`
+}
+
+// AuthInfo configures authentication.  Each request's policy is that of the most
+// specific matching route, or DefaultPolicy (default authenticated) if none match.
+// Requests to public routes are still authenticated if they carry credentials.
+//
+// If Roles are set, requests to routes that are not public are also authorized:
+// the user must have a role in UserRoles, keyed by user or token name, granting
+// access to the command, proxy, or path requested.  Every authenticated user may
+// request /api/health, which lists only the proxies the user may access.
+type AuthInfo struct {
+	Realm         string                 `json:"realm,omitempty"`
+	Users         []AuthUserInfo         `json:"users,omitempty"`
+	Tokens        []AuthTokenInfo        `json:"tokens,omitempty"`
+	TrustedHeader *AuthTrustedHeaderInfo `json:"trustedHeader,omitempty"`
+	DefaultPolicy string                 `json:"defaultPolicy,omitempty"`
+	Routes        []AuthRouteInfo        `json:"routes,omitempty"`
+	Roles         []AuthRoleInfo         `json:"roles,omitempty"`
+	UserRoles     map[string][]string    `json:"userRoles,omitempty"`
+}
+
+// RateLimitClassInfo limits each client's requests to paths matching Paths, which
+// match like AuthRouteInfo.Path.  Requests are limited to RequestsPerSecond on
+// average with bursts of up to Burst (default 1), and to MaxConcurrentRequests
+// at once.  Either limit is off if zero.
+type RateLimitClassInfo struct {
+	Name                  string   `json:"name"`
+	Paths                 []string `json:"paths"`
+	RequestsPerSecond     float64  `json:"requestsPerSecond,omitempty"`
+	Burst                 int      `json:"burst,omitempty"`
+	MaxConcurrentRequests int      `json:"maxConcurrentRequests,omitempty"`
+}
+
+// RateLimitInfo configures per client rate limits.  Each request is limited by the
+// first class with a path matching it.  Clients are identified by IP address, taken
+// from X-Forwarded-For on requests from TrustedProxies, which are IP addresses or
+// CIDR blocks.
+type RateLimitInfo struct {
+	TrustedProxies []string             `json:"trustedProxies,omitempty"`
+	Classes        []RateLimitClassInfo `json:"classes"`
+}
+
+// LogFileInfo writes the application and access logs to files in Directory, named
+// ApplicationLogFile (default application.log) and AccessLogFile (default access.log).
+// A file is rotated before it would grow over MaxSizeBytes, if set, and at the first
+// write of each day if RotateDaily.  MaxBackups rotated files of each log are kept,
+// or all of them if zero, and they are gzipped if Compress.  SIGUSR1 reopens the files
+// for use with an external tool such as logrotate instead, except on Windows.
+type LogFileInfo struct {
+	Directory          string `json:"directory"`
+	ApplicationLogFile string `json:"applicationLogFile,omitempty"`
+	AccessLogFile      string `json:"accessLogFile,omitempty"`
+	MaxSizeBytes       int64  `json:"maxSizeBytes,omitempty"`
+	RotateDaily        bool   `json:"rotateDaily,omitempty"`
+	MaxBackups         int    `json:"maxBackups,omitempty"`
+	Compress           bool   `json:"compress,omitempty"`
+}
+
+// ApplicationLogPath returns the path of the application log file.
+func (logFileInfo *LogFileInfo) ApplicationLogPath() string {
+	if len(logFileInfo.ApplicationLogFile) == 0 {
+		return filepath.Join(logFileInfo.Directory, "application.log")
+	}
+	return filepath.Join(logFileInfo.Directory, logFileInfo.ApplicationLogFile)
+}
+
+// AccessLogPath returns the path of the access log file.
+func (logFileInfo *LogFileInfo) AccessLogPath() string {
+	if len(logFileInfo.AccessLogFile) == 0 {
+		return filepath.Join(logFileInfo.Directory, "access.log")
+	}
+	return filepath.Join(logFileInfo.Directory, logFileInfo.AccessLogFile)
+}
+
+// LogInfo configures logging.  Format is logfmt (the default) or json.  Level is the
+// minimum level of application log entries: debug, info (the default), warn, or error.
+// If logRequests is true an access log entry is written for each request.  Logs are
+// written to stderr and stdout unless FileInfo is set.  The last BufferEntries entries
+// (default 1000) are kept in memory for the /logs page.
+type LogInfo struct {
+	Format        string       `json:"format,omitempty"`
+	Level         string       `json:"level,omitempty"`
+	FileInfo      *LogFileInfo `json:"fileInfo,omitempty"`
+	BufferEntries int          `json:"bufferEntries,omitempty"`
+}
+
+type ConfigurationReloadInfo struct {
+	WatchConfigFile           bool `json:"watchConfigFile"`
+	WatchIntervalMilliseconds int  `json:"watchIntervalMilliseconds"`
 }
 
 type Configuration struct {
-	LogRequests          bool                  `json:"logRequests"`
-	ServerInfoList       []ServerInfo          `json:"serverInfoList"`
-	TemplatePageInfo     TemplatePageInfo      `json:"templatePageInfo"`
-	MainPageInfo         MainPageInfo          `json:"mainPageInfo"`
-	PprofInfo            PprofInfo             `json:"pprofInfo"`
-	StaticFiles          []StaticFileInfo      `json:"staticFiles"`
-	StaticDirectories    []StaticDirectoryInfo `json:"staticDirectories"`
-	CommandConfiguration CommandConfiguration  `json:"commandConfiguration"`
-	Proxies              []ProxyInfo           `json:"proxies"`
+	LogRequests                 bool                    `json:"logRequests"`
+	LogInfo                     LogInfo                 `json:"logInfo"`
+	ShutdownTimeoutMilliseconds int                     `json:"shutdownTimeoutMilliseconds"`
+	ConfigurationReloadInfo     ConfigurationReloadInfo `json:"configurationReloadInfo"`
+	ServerInfoList              []ServerInfo            `json:"serverInfoList"`
+	TemplatePageInfo            TemplatePageInfo        `json:"templatePageInfo"`
+	MainPageInfo                MainPageInfo            `json:"mainPageInfo"`
+	PprofInfo                   PprofInfo               `json:"pprofInfo"`
+	MetricsInfo                 MetricsInfo             `json:"metricsInfo"`
+	StaticFiles                 []StaticFileInfo        `json:"staticFiles"`
+	StaticDirectories           []StaticDirectoryInfo   `json:"staticDirectories"`
+	CommandConfiguration        CommandConfiguration    `json:"commandConfiguration"`
+	Proxies                     []ProxyInfo             `json:"proxies"`
+	AuthInfo                    *AuthInfo               `json:"authInfo,omitempty"`
+	RateLimitInfo               *RateLimitInfo          `json:"rateLimitInfo,omitempty"`
+	SystemInfo                  *SystemInfo             `json:"systemInfo,omitempty"`
 }
 
-func ReadConfiguration(configFile string) *Configuration {
-	log.Printf("reading json file %v", configFile)
+func ReadConfiguration(configFile string) (*Configuration, error) {
+	logging.Info("reading json file", "file", configFile)
 
 	source, err := os.ReadFile(configFile)
 	if err != nil {
-		log.Fatalf("error reading %v: %v", configFile, err)
+		return nil, fmt.Errorf("error reading %v: %w", configFile, err)
 	}
 
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.DisallowUnknownFields()
+	// Numbers in JSONValue are json.Number, so they compare exactly with response bodies.
+	decoder.UseNumber()
+
 	var config Configuration
-	if err = json.Unmarshal(source, &config); err != nil {
-		log.Fatalf("error parsing %v: %v", configFile, err)
+	if err = decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %w", configFile, err)
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("error validating %v: %w", configFile, err)
 	}
 
-	return &config
+	return &config, nil
 }
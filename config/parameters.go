@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	parameterNameRegexp        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	parameterPlaceholderRegexp = regexp.MustCompile(`\{\{([A-Za-z_][A-Za-z0-9_]*)\}\}`)
+	hostnameRegexp             = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+)
+
+const maxHostnameLength = 253
+
+func (parameterInfo *CommandParameterInfo) compilePattern() (*regexp.Regexp, error) {
+	// Anchor the pattern so it must match the entire value.
+	return regexp.Compile("^(?:" + parameterInfo.Pattern + ")$")
+}
+
+// checkLeadingDash returns an error if value starts with - and the parameter does not
+// allow it, so a value can not be taken by the command as an option.
+func (parameterInfo *CommandParameterInfo) checkLeadingDash(value string) error {
+	if strings.HasPrefix(value, "-") && !parameterInfo.AllowLeadingDash {
+		return fmt.Errorf("parameter %v must not start with -", parameterInfo.Name)
+	}
+	return nil
+}
+
+// CheckValue returns the value to substitute into args, or an error if value is not valid for the parameter.
+func (parameterInfo *CommandParameterInfo) CheckValue(value string) (string, error) {
+	switch parameterInfo.Type {
+	case CommandParameterTypeEnum:
+		for _, allowedValue := range parameterInfo.Values {
+			if value == allowedValue {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("parameter %v must be one of %q", parameterInfo.Name, parameterInfo.Values)
+
+	case CommandParameterTypeInteger:
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("parameter %v must be an integer", parameterInfo.Name)
+		}
+		if (parameterInfo.Min != nil) && (intValue < *parameterInfo.Min) {
+			return "", fmt.Errorf("parameter %v must be >= %v", parameterInfo.Name, *parameterInfo.Min)
+		}
+		if (parameterInfo.Max != nil) && (intValue > *parameterInfo.Max) {
+			return "", fmt.Errorf("parameter %v must be <= %v", parameterInfo.Name, *parameterInfo.Max)
+		}
+		formattedValue := strconv.FormatInt(intValue, 10)
+		if err := parameterInfo.checkLeadingDash(formattedValue); err != nil {
+			return "", err
+		}
+		return formattedValue, nil
+
+	case CommandParameterTypeString:
+		pattern, err := parameterInfo.compilePattern()
+		if err != nil {
+			return "", fmt.Errorf("parameter %v has invalid pattern: %w", parameterInfo.Name, err)
+		}
+		if !pattern.MatchString(value) {
+			return "", fmt.Errorf("parameter %v must match %v", parameterInfo.Name, parameterInfo.Pattern)
+		}
+		if err := parameterInfo.checkLeadingDash(value); err != nil {
+			return "", err
+		}
+		return value, nil
+
+	case CommandParameterTypeHostname:
+		if net.ParseIP(value) != nil {
+			return value, nil
+		}
+		if (len(value) > maxHostnameLength) || !hostnameRegexp.MatchString(value) {
+			return "", fmt.Errorf("parameter %v must be a hostname or IP address", parameterInfo.Name)
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("parameter %v has unknown type %q", parameterInfo.Name, parameterInfo.Type)
+}
+
+// ExpandArgs returns Args with each {{name}} placeholder replaced by the value of the
+// named parameter from values, or the parameter's default if values does not contain it.
+// Values are only substituted into individual args, never passed through a shell.
+func (commandInfo *CommandInfo) ExpandArgs(values url.Values) ([]string, error) {
+	if len(commandInfo.Parameters) == 0 {
+		return commandInfo.Args, nil
+	}
+
+	parameterValues := make(map[string]string, len(commandInfo.Parameters))
+	for i := range commandInfo.Parameters {
+		parameterInfo := &commandInfo.Parameters[i]
+
+		var value string
+		if rawValues, ok := values[parameterInfo.Name]; ok && (len(rawValues) > 0) {
+			if len(rawValues) > 1 {
+				return nil, fmt.Errorf("parameter %v specified more than once", parameterInfo.Name)
+			}
+			value = rawValues[0]
+		} else if parameterInfo.Default != nil {
+			value = *parameterInfo.Default
+		} else {
+			return nil, fmt.Errorf("parameter %v is required", parameterInfo.Name)
+		}
+
+		checkedValue, err := parameterInfo.CheckValue(value)
+		if err != nil {
+			return nil, err
+		}
+		parameterValues[parameterInfo.Name] = checkedValue
+	}
+
+	expandedArgs := make([]string, 0, len(commandInfo.Args))
+	for _, arg := range commandInfo.Args {
+		expandedArgs = append(expandedArgs, parameterPlaceholderRegexp.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			name := strings.TrimSuffix(strings.TrimPrefix(placeholder, "{{"), "}}")
+			return parameterValues[name]
+		}))
+	}
+	return expandedArgs, nil
+}
+
+func (validator *validator) validateCommandParameters(path string, commandInfo *CommandInfo) {
+	names := make(map[string]bool, len(commandInfo.Parameters))
+
+	for i := range commandInfo.Parameters {
+		parameterInfo := &commandInfo.Parameters[i]
+		parameterPath := fmt.Sprintf("%v.parameters[%v]", path, i)
+
+		if !parameterNameRegexp.MatchString(parameterInfo.Name) {
+			validator.addError(parameterPath+".name", "%q must match %v", parameterInfo.Name, parameterNameRegexp)
+		} else if names[parameterInfo.Name] {
+			validator.addError(parameterPath+".name", "duplicate parameter name %q", parameterInfo.Name)
+		}
+		names[parameterInfo.Name] = true
+
+		switch parameterInfo.Type {
+		case CommandParameterTypeEnum:
+			if len(parameterInfo.Values) == 0 {
+				validator.addError(parameterPath+".values", "must not be empty for type %v", parameterInfo.Type)
+			}
+
+		case CommandParameterTypeInteger:
+			if (parameterInfo.Min != nil) && (parameterInfo.Max != nil) && (*parameterInfo.Min > *parameterInfo.Max) {
+				validator.addError(parameterPath+".min", "must not be greater than max")
+			}
+
+		case CommandParameterTypeString:
+			if len(parameterInfo.Pattern) == 0 {
+				validator.addError(parameterPath+".pattern", "must not be empty for type %v", parameterInfo.Type)
+			} else if _, err := parameterInfo.compilePattern(); err != nil {
+				validator.addError(parameterPath+".pattern", "%v", err)
+			}
+
+		case CommandParameterTypeHostname:
+
+		default:
+			validator.addError(parameterPath+".type", "unknown type %q", parameterInfo.Type)
+			continue
+		}
+
+		if parameterInfo.Default != nil {
+			if _, err := parameterInfo.CheckValue(*parameterInfo.Default); err != nil {
+				validator.addError(parameterPath+".default", "%v", err)
+			}
+		}
+	}
+
+	for i, arg := range commandInfo.Args {
+		for _, match := range parameterPlaceholderRegexp.FindAllStringSubmatch(arg, -1) {
+			if !names[match[1]] {
+				validator.addError(fmt.Sprintf("%v.args[%v]", path, i), "undeclared parameter %q", match[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func int64Pointer(i int64) *int64 {
+	return &i
+}
+
+func TestCheckValue(t *testing.T) {
+	tests := []struct {
+		name          string
+		parameterInfo CommandParameterInfo
+		value         string
+		want          string
+		wantError     string
+	}{
+		{
+			name:          "integer",
+			parameterInfo: CommandParameterInfo{Name: "count", Type: CommandParameterTypeInteger},
+			value:         "+05",
+			want:          "5",
+		},
+		{
+			name:          "integer out of range",
+			parameterInfo: CommandParameterInfo{Name: "count", Type: CommandParameterTypeInteger, Max: int64Pointer(10)},
+			value:         "11",
+			wantError:     "parameter count must be <= 10",
+		},
+		{
+			name:          "negative integer",
+			parameterInfo: CommandParameterInfo{Name: "offset", Type: CommandParameterTypeInteger},
+			value:         "-5",
+			wantError:     "parameter offset must not start with -",
+		},
+		{
+			name:          "negative integer allowed",
+			parameterInfo: CommandParameterInfo{Name: "offset", Type: CommandParameterTypeInteger, AllowLeadingDash: true},
+			value:         "-5",
+			want:          "-5",
+		},
+		{
+			name:          "string",
+			parameterInfo: CommandParameterInfo{Name: "file", Type: CommandParameterTypeString, Pattern: ".*"},
+			value:         "notes.txt",
+			want:          "notes.txt",
+		},
+		{
+			name:          "string option",
+			parameterInfo: CommandParameterInfo{Name: "file", Type: CommandParameterTypeString, Pattern: ".*"},
+			value:         "--output=/x",
+			wantError:     "parameter file must not start with -",
+		},
+		{
+			name:          "string short option",
+			parameterInfo: CommandParameterInfo{Name: "file", Type: CommandParameterTypeString, Pattern: "[-a-z]+"},
+			value:         "-rf",
+			wantError:     "parameter file must not start with -",
+		},
+		{
+			name:          "string option allowed",
+			parameterInfo: CommandParameterInfo{Name: "flag", Type: CommandParameterTypeString, Pattern: "-[a-z]", AllowLeadingDash: true},
+			value:         "-v",
+			want:          "-v",
+		},
+		{
+			name:          "hostname option",
+			parameterInfo: CommandParameterInfo{Name: "host", Type: CommandParameterTypeHostname},
+			value:         "-oProxyCommand",
+			wantError:     "parameter host must be a hostname or IP address",
+		},
+		{
+			name:          "enum",
+			parameterInfo: CommandParameterInfo{Name: "iface", Type: CommandParameterTypeEnum, Values: []string{"eth0", "wlan0"}},
+			value:         "lo",
+			wantError:     `parameter iface must be one of ["eth0" "wlan0"]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.parameterInfo.CheckValue(test.value)
+			if len(test.wantError) > 0 {
+				if (err == nil) || (err.Error() != test.wantError) {
+					t.Errorf("CheckValue(%q) error = %v, want %q", test.value, err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckValue(%q) error = %v", test.value, err)
+			}
+			if got != test.want {
+				t.Errorf("CheckValue(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	defaultCount := "3"
+	commandInfo := &CommandInfo{
+		ID:      "ping",
+		Command: "ping",
+		Args:    []string{"-c", "{{count}}", "{{host}}"},
+		Parameters: []CommandParameterInfo{
+			{Name: "host", Type: CommandParameterTypeHostname},
+			{Name: "count", Type: CommandParameterTypeInteger, Min: int64Pointer(1), Default: &defaultCount},
+		},
+	}
+
+	args, err := commandInfo.ExpandArgs(url.Values{"host": {"example.com"}})
+	if err != nil {
+		t.Fatalf("ExpandArgs error = %v", err)
+	}
+	if want := []string{"-c", "3", "example.com"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("ExpandArgs = %q, want %q", args, want)
+	}
+
+	if _, err := commandInfo.ExpandArgs(url.Values{}); (err == nil) || (err.Error() != "parameter host is required") {
+		t.Errorf("ExpandArgs without host error = %v, want parameter host is required", err)
+	}
+	if _, err := commandInfo.ExpandArgs(url.Values{"host": {"example.com"}, "count": {"-1"}}); (err == nil) || (err.Error() != "parameter count must be >= 1") {
+		t.Errorf("ExpandArgs with count -1 error = %v, want parameter count must be >= 1", err)
+	}
+}
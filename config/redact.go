@@ -0,0 +1,76 @@
+package config
+
+import "net/url"
+
+// RedactedValue replaces secrets in a Redacted configuration.
+const RedactedValue = "REDACTED"
+
+func redactMapValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(m))
+	for key := range m {
+		redacted[key] = RedactedValue
+	}
+	return redacted
+}
+
+// redactURLPassword returns rawURL with the password in its user info replaced.
+func redactURLPassword(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if (err != nil) || (parsedURL.User == nil) {
+		return rawURL
+	}
+	if _, ok := parsedURL.User.Password(); !ok {
+		return rawURL
+	}
+	parsedURL.User = url.UserPassword(parsedURL.User.Username(), RedactedValue)
+	return parsedURL.String()
+}
+
+// Redacted returns a copy of proxyInfo to show or log, with the values of headers,
+// the request body, and the password in URL replaced by RedactedValue.
+func (proxyInfo *ProxyInfo) Redacted() *ProxyInfo {
+	redacted := *proxyInfo
+	redacted.URL = redactURLPassword(proxyInfo.URL)
+	redacted.Headers = redactMapValues(proxyInfo.Headers)
+	// Bodies such as token requests or GraphQL queries can hold credentials.
+	if len(proxyInfo.Body) > 0 {
+		redacted.Body = RedactedValue
+	}
+	return &redacted
+}
+
+// Redacted returns a copy of configuration to show or log, with user password
+// hashes, the values of command environment variables, and the secrets of proxies
+// replaced by RedactedValue.
+func (configuration *Configuration) Redacted() *Configuration {
+	redacted := *configuration
+
+	redacted.CommandConfiguration.Commands = append([]CommandInfo(nil), configuration.CommandConfiguration.Commands...)
+	for i := range redacted.CommandConfiguration.Commands {
+		commandInfo := &redacted.CommandConfiguration.Commands[i]
+		if environmentInfo := commandInfo.Environment; environmentInfo != nil {
+			redactedEnvironmentInfo := *environmentInfo
+			redactedEnvironmentInfo.Variables = redactMapValues(environmentInfo.Variables)
+			commandInfo.Environment = &redactedEnvironmentInfo
+		}
+	}
+
+	redacted.Proxies = append([]ProxyInfo(nil), configuration.Proxies...)
+	for i := range redacted.Proxies {
+		redacted.Proxies[i] = *redacted.Proxies[i].Redacted()
+	}
+
+	if authInfo := configuration.AuthInfo; authInfo != nil {
+		redactedAuthInfo := *authInfo
+		redactedAuthInfo.Users = append([]AuthUserInfo(nil), authInfo.Users...)
+		for i := range redactedAuthInfo.Users {
+			redactedAuthInfo.Users[i].PasswordHash = RedactedValue
+		}
+		redacted.AuthInfo = &redactedAuthInfo
+	}
+
+	return &redacted
+}
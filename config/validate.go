@@ -0,0 +1,740 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/cron"
+	"github.com/aaronriekenberg/pi-web/jsonpath"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ValidationError is a single problem found in a configuration.
+// Path is the JSON path of the offending value, e.g. commandConfiguration.commands[3].id
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (validationError ValidationError) String() string {
+	return validationError.Path + ": " + validationError.Message
+}
+
+// ValidationErrors is every problem found in a configuration.
+type ValidationErrors []ValidationError
+
+func (validationErrors ValidationErrors) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%v configuration error(s):", len(validationErrors))
+	for _, validationError := range validationErrors {
+		builder.WriteString("\n  ")
+		builder.WriteString(validationError.String())
+	}
+	return builder.String()
+}
+
+var (
+	validIDRegexp        = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	httpMethodRegexp     = regexp.MustCompile(`^[A-Z]+$`)
+	httpHeaderNameRegexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+)
+
+type validator struct {
+	validationErrors ValidationErrors
+	httpPaths        map[string]string
+	ids              map[string]map[string]string
+}
+
+func newValidator() *validator {
+	return &validator{
+		httpPaths: make(map[string]string),
+		ids:       make(map[string]map[string]string),
+	}
+}
+
+func (validator *validator) addError(path string, format string, args ...interface{}) {
+	validator.validationErrors = append(validator.validationErrors, ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (validator *validator) checkNotEmpty(path string, value string) {
+	if len(strings.TrimSpace(value)) == 0 {
+		validator.addError(path, "must not be empty")
+	}
+}
+
+func (validator *validator) checkNotNegative(path string, value int64) {
+	if value < 0 {
+		validator.addError(path, "must not be negative, got %v", value)
+	}
+}
+
+func (validator *validator) checkPositive(path string, value int64) {
+	if value <= 0 {
+		validator.addError(path, "must be positive, got %v", value)
+	}
+}
+
+func (validator *validator) checkFileExists(path string, fileName string) {
+	if len(fileName) == 0 {
+		validator.addError(path, "must not be empty")
+		return
+	}
+	if _, err := os.Stat(fileName); err != nil {
+		validator.addError(path, "%v", err)
+	}
+}
+
+// checkID checks id is a valid, unique URL path segment within kind.
+func (validator *validator) checkID(kind string, path string, id string) {
+	if !validIDRegexp.MatchString(id) {
+		validator.addError(path, "%q must match %v", id, validIDRegexp)
+		return
+	}
+
+	kindIDs := validator.ids[kind]
+	if kindIDs == nil {
+		kindIDs = make(map[string]string)
+		validator.ids[kind] = kindIDs
+	}
+	if previousPath, ok := kindIDs[id]; ok {
+		validator.addError(path, "duplicate id %q also used by %v", id, previousPath)
+		return
+	}
+	kindIDs[id] = path
+}
+
+func (validator *validator) checkHTTPPath(path string, httpPath string) {
+	if !strings.HasPrefix(httpPath, "/") {
+		validator.addError(path, "%q must start with /", httpPath)
+		return
+	}
+	if previousPath, ok := validator.httpPaths[httpPath]; ok {
+		validator.addError(path, "httpPath %q also used by %v", httpPath, previousPath)
+		return
+	}
+	validator.httpPaths[httpPath] = path
+}
+
+// checkIPNets checks each of list is an IP address or CIDR block.
+func (validator *validator) checkIPNets(path string, list []string) {
+	for i, s := range list {
+		if _, err := utils.ParseIPNet(s); err != nil {
+			validator.addError(fmt.Sprintf("%v[%v]", path, i), "%v", err)
+		}
+	}
+}
+
+// checkPathPatterns checks each of patterns is a path, optionally ending in *.
+func (validator *validator) checkPathPatterns(path string, patterns []string) {
+	for i, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "/") {
+			validator.addError(fmt.Sprintf("%v[%v]", path, i), "%q must start with /", pattern)
+		}
+	}
+}
+
+func (validator *validator) checkLogFileName(path string, fileName string) {
+	if strings.ContainsRune(fileName, filepath.Separator) {
+		validator.addError(path, "%q must be a file name without a directory", fileName)
+	}
+}
+
+func (validator *validator) validateLogInfo(logInfo *LogInfo) {
+	if len(logInfo.Format) > 0 {
+		if err := logging.CheckFormat(logInfo.Format); err != nil {
+			validator.addError("logInfo.format", "%v", err)
+		}
+	}
+	if len(logInfo.Level) > 0 {
+		if _, err := logging.ParseLevel(logInfo.Level); err != nil {
+			validator.addError("logInfo.level", "%v", err)
+		}
+	}
+	validator.checkNotNegative("logInfo.bufferEntries", int64(logInfo.BufferEntries))
+
+	if fileInfo := logInfo.FileInfo; fileInfo != nil {
+		if directoryInfo, err := os.Stat(fileInfo.Directory); err != nil {
+			validator.addError("logInfo.fileInfo.directory", "%v", err)
+		} else if !directoryInfo.IsDir() {
+			validator.addError("logInfo.fileInfo.directory", "%q is not a directory", fileInfo.Directory)
+		}
+		validator.checkLogFileName("logInfo.fileInfo.applicationLogFile", fileInfo.ApplicationLogFile)
+		validator.checkLogFileName("logInfo.fileInfo.accessLogFile", fileInfo.AccessLogFile)
+		if fileInfo.ApplicationLogPath() == fileInfo.AccessLogPath() {
+			validator.addError("logInfo.fileInfo.accessLogFile", "must be different from applicationLogFile")
+		}
+		validator.checkNotNegative("logInfo.fileInfo.maxSizeBytes", fileInfo.MaxSizeBytes)
+		validator.checkNotNegative("logInfo.fileInfo.maxBackups", int64(fileInfo.MaxBackups))
+	}
+}
+
+func (validator *validator) validateTLSInfo(path string, tlsInfo *TLSInfo) {
+	validator.checkFileExists(path+".certFile", tlsInfo.CertFile)
+	validator.checkFileExists(path+".keyFile", tlsInfo.KeyFile)
+}
+
+func (validator *validator) validateHTTPServerTimeouts(path string, httpServerTimeouts *HTTPServerTimeouts) {
+	if httpServerTimeouts == nil {
+		return
+	}
+	validator.checkNotNegative(path+".readTimeoutMilliseconds", int64(httpServerTimeouts.ReadTimeoutMilliseconds))
+	validator.checkNotNegative(path+".writeTimeoutMilliseconds", int64(httpServerTimeouts.WriteTimeoutMilliseconds))
+}
+
+func (validator *validator) validateServerInfoList(serverInfoList []ServerInfo) {
+	if len(serverInfoList) == 0 {
+		validator.addError("serverInfoList", "must not be empty")
+	}
+
+	for i, serverInfo := range serverInfoList {
+		path := fmt.Sprintf("serverInfoList[%v]", i)
+
+		switch {
+		case serverInfo.HTTP3ServerInfo == nil && serverInfo.HTTPServerInfo == nil:
+			validator.addError(path, "one of http3ServerInfo or httpServerInfo is required")
+
+		case serverInfo.HTTP3ServerInfo != nil && serverInfo.HTTPServerInfo != nil:
+			validator.addError(path, "only one of http3ServerInfo or httpServerInfo is allowed")
+
+		case serverInfo.HTTP3ServerInfo != nil:
+			http3ServerInfo := serverInfo.HTTP3ServerInfo
+			path += ".http3ServerInfo"
+			validator.validateTLSInfo(path+".tlsInfo", &http3ServerInfo.TLSInfo)
+			if port := http3ServerInfo.OverrideAltSvcPortValue; port != nil && (*port <= 0 || *port > 65535) {
+				validator.addError(path+".overrideAltSvcPortValue", "invalid port %v", *port)
+			}
+			validator.validateHTTPServerTimeouts(path+".httpServerTimeouts", http3ServerInfo.HTTPServerTimeouts)
+			validator.checkNotEmpty(path+".listenAddress", http3ServerInfo.ListenAddress)
+
+		case serverInfo.HTTPServerInfo != nil:
+			httpServerInfo := serverInfo.HTTPServerInfo
+			path += ".httpServerInfo"
+			if httpServerInfo.TLSInfo != nil {
+				validator.validateTLSInfo(path+".tlsInfo", httpServerInfo.TLSInfo)
+			}
+			validator.validateHTTPServerTimeouts(path+".httpServerTimeouts", httpServerInfo.HTTPServerTimeouts)
+			validator.checkNotEmpty(path+".listenAddress", httpServerInfo.ListenAddress)
+		}
+	}
+}
+
+// reserveBuiltInHTTPPaths reserves the paths served for configuration other than
+// staticFiles, staticDirectories and reverse proxy prefixes: the fixed pages, the
+// login path, and the pages of each command and json proxy.
+func (validator *validator) reserveBuiltInHTTPPaths(configuration *Configuration) {
+	reserve := func(owner string, httpPaths ...string) {
+		for _, httpPath := range httpPaths {
+			validator.httpPaths[httpPath] = owner
+		}
+	}
+
+	const builtInRoute = "built-in route"
+	reserve(builtInRoute,
+		"/", "/configuration", "/environment", "/logs", "/api/logs", "/request_info", "/api/health")
+	if configuration.RateLimitInfo != nil {
+		reserve(builtInRoute, "/rate_limits")
+	}
+	if configuration.MetricsInfo.Enabled {
+		reserve(builtInRoute, "/metrics")
+	}
+	if configuration.PprofInfo.Enabled {
+		reserve(builtInRoute,
+			"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/profile", "/debug/pprof/symbol", "/debug/pprof/trace")
+	}
+	if configuration.SystemInfo != nil {
+		reserve(builtInRoute, "/system.html", "/api/system")
+	}
+	// The login path is served by authentication, ahead of any other route.
+	if (configuration.AuthInfo != nil) && (len(configuration.AuthInfo.Users) > 0) {
+		reserve(builtInRoute, "/login")
+	}
+
+	for i, commandInfo := range configuration.CommandConfiguration.Commands {
+		owner := fmt.Sprintf("commandConfiguration.commands[%v]", i)
+		apiPath := "/api/commands/" + commandInfo.ID
+		reserve(owner, "/commands/"+commandInfo.ID+".html", apiPath, apiPath+"/stream")
+		if commandInfo.Schedule != nil {
+			reserve(owner, apiPath+"/history")
+		}
+	}
+
+	for i, proxyInfo := range configuration.Proxies {
+		if proxyInfo.IsReverse() {
+			continue
+		}
+		reserve(fmt.Sprintf("proxies[%v]", i), "/proxies/"+proxyInfo.ID+".html", "/api/proxies/"+proxyInfo.ID)
+	}
+}
+
+func (validator *validator) validateStaticFiles(staticFiles []StaticFileInfo) {
+	for i, staticFileInfo := range staticFiles {
+		path := fmt.Sprintf("staticFiles[%v]", i)
+		validator.checkHTTPPath(path+".httpPath", staticFileInfo.HTTPPath)
+		if staticFileInfo.CacheContentInMemory {
+			validator.checkFileExists(path+".filePath", staticFileInfo.FilePath)
+		} else {
+			validator.checkNotEmpty(path+".filePath", staticFileInfo.FilePath)
+		}
+	}
+}
+
+func (validator *validator) validateStaticDirectories(staticDirectories []StaticDirectoryInfo) {
+	for i, staticDirectoryInfo := range staticDirectories {
+		path := fmt.Sprintf("staticDirectories[%v]", i)
+		validator.checkHTTPPath(path+".httpPath", staticDirectoryInfo.HTTPPath)
+		validator.checkNotEmpty(path+".directoryPath", staticDirectoryInfo.DirectoryPath)
+	}
+}
+
+func (validator *validator) validateCommandConfiguration(commandConfiguration *CommandConfiguration) {
+	const path = "commandConfiguration"
+
+	validator.checkPositive(path+".maxConcurrentCommands", commandConfiguration.MaxConcurrentCommands)
+	validator.checkPositive(path+".requestTimeoutMilliseconds", int64(commandConfiguration.RequestTimeoutMilliseconds))
+	validator.checkNotNegative(path+".semaphoreAcquireTimeoutMilliseconds", int64(commandConfiguration.SemaphoreAcquireTimeoutMilliseconds))
+	validator.checkNotNegative(path+".streamTimeoutMilliseconds", int64(commandConfiguration.StreamTimeoutMilliseconds))
+	if (commandConfiguration.StreamTimeoutMilliseconds > 0) &&
+		(commandConfiguration.StreamTimeoutMilliseconds < commandConfiguration.RequestTimeoutMilliseconds) {
+		validator.addError(path+".streamTimeoutMilliseconds", "must not be less than requestTimeoutMilliseconds %v",
+			commandConfiguration.RequestTimeoutMilliseconds)
+	}
+	validator.checkNotNegative(path+".minScheduleIntervalMilliseconds", int64(commandConfiguration.MinScheduleIntervalMilliseconds))
+	validator.checkNotNegative(path+".maxOutputBytes", commandConfiguration.MaxOutputBytes)
+
+	for i := range commandConfiguration.Commands {
+		commandInfo := &commandConfiguration.Commands[i]
+		commandPath := fmt.Sprintf("%v.commands[%v]", path, i)
+		validator.checkID("command", commandPath+".id", commandInfo.ID)
+		validator.checkNotEmpty(commandPath+".command", commandInfo.Command)
+		validator.checkNotNegative(commandPath+".cacheTTLMilliseconds", int64(commandInfo.CacheTTLMilliseconds))
+		validator.checkNotNegative(commandPath+".requestTimeoutMilliseconds", int64(commandInfo.RequestTimeoutMilliseconds))
+		validator.checkNotNegative(commandPath+".maxOutputBytes", commandInfo.MaxOutputBytes)
+		validator.validateCommandParameters(commandPath, commandInfo)
+		if commandInfo.Schedule != nil {
+			validator.validateCommandSchedule(commandPath, commandInfo, commandConfiguration)
+		}
+		validator.validateCommandExecution(commandPath, commandInfo)
+	}
+}
+
+func (validator *validator) validateCommandExecution(commandPath string, commandInfo *CommandInfo) {
+	if len(commandInfo.WorkingDirectory) > 0 {
+		if fileInfo, err := os.Stat(commandInfo.WorkingDirectory); err != nil {
+			validator.addError(commandPath+".workingDirectory", "%v", err)
+		} else if !fileInfo.IsDir() {
+			validator.addError(commandPath+".workingDirectory", "%q is not a directory", commandInfo.WorkingDirectory)
+		}
+	}
+
+	if environmentInfo := commandInfo.Environment; environmentInfo != nil {
+		checkName := func(path string, name string) {
+			if (len(name) == 0) || strings.ContainsAny(name, "=\x00") {
+				validator.addError(path, "invalid environment variable name %q", name)
+			}
+		}
+		for i, name := range environmentInfo.Inherit {
+			checkName(fmt.Sprintf("%v.environment.inherit[%v]", commandPath, i), name)
+		}
+		for name, value := range environmentInfo.Variables {
+			checkName(commandPath+".environment.variables", name)
+			if strings.ContainsRune(value, 0) {
+				validator.addError(commandPath+".environment.variables."+name, "must not contain NUL")
+			}
+		}
+	}
+
+	if (commandInfo.Credential != nil) && (runtime.GOOS == "windows") {
+		validator.addError(commandPath+".credential", "not supported on %v", runtime.GOOS)
+	}
+	if (commandInfo.ResourceLimits != nil) && (runtime.GOOS != "linux") {
+		validator.addError(commandPath+".resourceLimits", "not supported on %v", runtime.GOOS)
+	}
+	if (commandInfo.ResourceLimits != nil) && (commandInfo.ResourceLimits.Processes != 0) &&
+		(commandInfo.Credential == nil) {
+		validator.addError(commandPath+".resourceLimits.processes", "requires credential, since the limit counts every process and thread of the uid")
+	}
+}
+
+func (validator *validator) validateCommandSchedule(
+	commandPath string, commandInfo *CommandInfo, commandConfiguration *CommandConfiguration) {
+
+	scheduleInfo := commandInfo.Schedule
+	path := commandPath + ".schedule"
+	historyDirectory := commandConfiguration.HistoryDirectory
+	minScheduleInterval := commandConfiguration.MinScheduleInterval()
+
+	switch {
+	case (scheduleInfo.IntervalMilliseconds != 0) && (len(scheduleInfo.Cron) > 0):
+		validator.addError(path, "only one of intervalMilliseconds or cron is allowed")
+	case len(scheduleInfo.Cron) > 0:
+		if cronSchedule, err := cron.Parse(scheduleInfo.Cron); err != nil {
+			validator.addError(path+".cron", "%v", err)
+		} else if minInterval := cronSchedule.MinInterval(time.Now()); (minInterval > 0) && (minInterval < minScheduleInterval) {
+			validator.addError(path+".cron", "%q runs every %v, less than commandConfiguration.minScheduleIntervalMilliseconds %v",
+				scheduleInfo.Cron, minInterval, minScheduleInterval)
+		}
+	default:
+		interval := time.Duration(scheduleInfo.IntervalMilliseconds) * time.Millisecond
+		if interval <= 0 {
+			validator.checkPositive(path+".intervalMilliseconds", int64(scheduleInfo.IntervalMilliseconds))
+		} else if interval < minScheduleInterval {
+			validator.addError(path+".intervalMilliseconds", "%v is less than commandConfiguration.minScheduleIntervalMilliseconds %v",
+				interval, minScheduleInterval)
+		}
+	}
+
+	validator.checkPositive(path+".historySize", int64(scheduleInfo.HistorySize))
+
+	if scheduleInfo.PersistHistory {
+		if len(historyDirectory) == 0 {
+			validator.addError(path+".persistHistory", "requires commandConfiguration.historyDirectory")
+		} else if fileInfo, err := os.Stat(historyDirectory); err != nil {
+			validator.addError("commandConfiguration.historyDirectory", "%v", err)
+		} else if !fileInfo.IsDir() {
+			validator.addError("commandConfiguration.historyDirectory", "%q is not a directory", historyDirectory)
+		}
+	}
+
+	// Scheduled runs have no request to take parameter values from.
+	for i := range commandInfo.Parameters {
+		if commandInfo.Parameters[i].Default == nil {
+			validator.addError(fmt.Sprintf("%v.parameters[%v].default", commandPath, i),
+				"required for scheduled commands")
+		}
+	}
+}
+
+func (validator *validator) validateProxyUpstream(path string, proxyInfo *ProxyInfo) {
+	for name := range proxyInfo.Headers {
+		if !httpHeaderNameRegexp.MatchString(name) {
+			validator.addError(path+".headers", "invalid header name %q", name)
+		}
+	}
+
+	if len(proxyInfo.AuthorizationFile) > 0 {
+		validator.checkFileExists(path+".authorizationFile", proxyInfo.AuthorizationFile)
+	}
+
+	if tlsInfo := proxyInfo.TLS; tlsInfo != nil {
+		if len(tlsInfo.CAFile) > 0 {
+			validator.checkFileExists(path+".tls.caFile", tlsInfo.CAFile)
+		}
+		if (len(tlsInfo.CertFile) > 0) || (len(tlsInfo.KeyFile) > 0) {
+			validator.checkFileExists(path+".tls.certFile", tlsInfo.CertFile)
+			validator.checkFileExists(path+".tls.keyFile", tlsInfo.KeyFile)
+		}
+	}
+
+	if proxyInfo.HTTP3 {
+		if len(proxyInfo.UnixSocket) > 0 {
+			validator.addError(path+".http3", "not allowed with unixSocket")
+		}
+		if !strings.HasPrefix(proxyInfo.URL, "https:") {
+			validator.addError(path+".http3", "requires an https url")
+		}
+	}
+}
+
+func (validator *validator) validateProxyAssertions(path string, assertionsInfo *ProxyAssertionsInfo) {
+	for i, statusCode := range assertionsInfo.StatusCodes {
+		if (statusCode < 100) || (statusCode > 599) {
+			validator.addError(fmt.Sprintf("%v.statusCodes[%v]", path, i), "invalid status code %v", statusCode)
+		}
+	}
+
+	if len(assertionsInfo.BodyRegex) > 0 {
+		if _, err := regexp.Compile(assertionsInfo.BodyRegex); err != nil {
+			validator.addError(path+".bodyRegex", "%v", err)
+		}
+	}
+
+	if len(assertionsInfo.JSONPath) > 0 {
+		if _, err := jsonpath.Parse(assertionsInfo.JSONPath); err != nil {
+			validator.addError(path+".jsonPath", "%v", err)
+		}
+	} else if assertionsInfo.JSONValue != nil {
+		validator.addError(path+".jsonValue", "requires jsonPath")
+	}
+
+	validator.checkNotNegative(path+".maxLatencyMilliseconds", int64(assertionsInfo.MaxLatencyMilliseconds))
+}
+
+func (validator *validator) validateProxyRetry(path string, retryInfo *ProxyRetryInfo) {
+	validator.checkPositive(path+".maxAttempts", int64(retryInfo.MaxAttempts))
+	validator.checkNotNegative(path+".initialBackoffMilliseconds", int64(retryInfo.InitialBackoffMilliseconds))
+	validator.checkNotNegative(path+".maxBackoffMilliseconds", int64(retryInfo.MaxBackoffMilliseconds))
+}
+
+func (validator *validator) validateProxyCircuitBreaker(path string, circuitBreakerInfo *ProxyCircuitBreakerInfo) {
+	validator.checkPositive(path+".failureThreshold", int64(circuitBreakerInfo.FailureThreshold))
+	validator.checkPositive(path+".openMilliseconds", int64(circuitBreakerInfo.OpenMilliseconds))
+	validator.checkNotNegative(path+".probeIntervalMilliseconds", int64(circuitBreakerInfo.ProbeIntervalMilliseconds))
+}
+
+func (validator *validator) validateProxies(proxies []ProxyInfo) {
+	for i, proxyInfo := range proxies {
+		path := fmt.Sprintf("proxies[%v]", i)
+		validator.checkID("proxy", path+".id", proxyInfo.ID)
+		validator.checkNotNegative(path+".timeoutMilliseconds", int64(proxyInfo.TimeoutMilliseconds))
+		validator.checkNotNegative(path+".maxBodyBytes", proxyInfo.MaxBodyBytes)
+
+		proxyURL, err := url.Parse(proxyInfo.URL)
+		if err != nil {
+			validator.addError(path+".url", "%v", err)
+		} else if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+			validator.addError(path+".url", "%q must be an http or https url", proxyInfo.URL)
+		}
+
+		validator.validateProxyUpstream(path, &proxyInfo)
+
+		switch proxyInfo.Mode {
+		case "", ProxyModeJSON:
+			if (len(proxyInfo.Method) > 0) && !httpMethodRegexp.MatchString(proxyInfo.Method) {
+				validator.addError(path+".method", "invalid method %q", proxyInfo.Method)
+			}
+			if len(proxyInfo.PathPrefix) > 0 {
+				validator.addError(path+".pathPrefix", "only allowed for mode %v", ProxyModeReverse)
+			}
+			if proxyInfo.Assertions != nil {
+				validator.validateProxyAssertions(path+".assertions", proxyInfo.Assertions)
+			}
+			if len(proxyInfo.Extract) > 0 {
+				if _, err := jsonpath.Parse(proxyInfo.Extract); err != nil {
+					validator.addError(path+".extract", "%v", err)
+				}
+			}
+			if len(proxyInfo.AllowedMethods) > 0 {
+				validator.addError(path+".allowedMethods", "only allowed for mode %v", ProxyModeReverse)
+			}
+			if proxyInfo.Retry != nil {
+				validator.validateProxyRetry(path+".retry", proxyInfo.Retry)
+			}
+			if proxyInfo.CircuitBreaker != nil {
+				validator.validateProxyCircuitBreaker(path+".circuitBreaker", proxyInfo.CircuitBreaker)
+			}
+
+		case ProxyModeReverse:
+			pathPrefix := proxyInfo.ReversePathPrefix()
+			if !strings.HasSuffix(pathPrefix, "/") {
+				validator.addError(path+".pathPrefix", "%q must end with /", pathPrefix)
+			}
+			validator.checkHTTPPath(path+".pathPrefix", pathPrefix)
+			if len(proxyInfo.Method) > 0 {
+				validator.addError(path+".method", "only allowed for mode %v", ProxyModeJSON)
+			}
+			if len(proxyInfo.Body) > 0 {
+				validator.addError(path+".body", "only allowed for mode %v", ProxyModeJSON)
+			}
+			if proxyInfo.Assertions != nil {
+				validator.addError(path+".assertions", "only allowed for mode %v", ProxyModeJSON)
+			}
+			if len(proxyInfo.Extract) > 0 {
+				validator.addError(path+".extract", "only allowed for mode %v", ProxyModeJSON)
+			}
+			if proxyInfo.Retry != nil {
+				validator.addError(path+".retry", "only allowed for mode %v", ProxyModeJSON)
+			}
+			if proxyInfo.CircuitBreaker != nil {
+				validator.addError(path+".circuitBreaker", "only allowed for mode %v", ProxyModeJSON)
+			}
+			for j, method := range proxyInfo.AllowedMethods {
+				if !httpMethodRegexp.MatchString(method) {
+					validator.addError(fmt.Sprintf("%v.allowedMethods[%v]", path, j), "invalid method %q", method)
+				}
+			}
+
+		default:
+			validator.addError(path+".mode", "unknown mode %q", proxyInfo.Mode)
+		}
+	}
+}
+
+func (validator *validator) checkAuthPolicy(path string, policy string) {
+	switch policy {
+	case AuthPolicyPublic, AuthPolicyAuthenticated:
+	default:
+		validator.addError(path, "unknown policy %q", policy)
+	}
+}
+
+func (validator *validator) validateAuthInfo(authInfo *AuthInfo) {
+	const path = "authInfo"
+
+	if (len(authInfo.Users) == 0) && (len(authInfo.Tokens) == 0) && (authInfo.TrustedHeader == nil) {
+		validator.addError(path, "one of users, tokens, or trustedHeader is required")
+	}
+
+	usernames := make(map[string]bool)
+	for i, userInfo := range authInfo.Users {
+		userPath := fmt.Sprintf("%v.users[%v]", path, i)
+		if (len(userInfo.Username) == 0) || strings.Contains(userInfo.Username, ":") {
+			validator.addError(userPath+".username", "invalid username %q", userInfo.Username)
+		} else if usernames[userInfo.Username] {
+			validator.addError(userPath+".username", "duplicate username %q", userInfo.Username)
+		}
+		usernames[userInfo.Username] = true
+		if _, err := bcrypt.Cost([]byte(userInfo.PasswordHash)); err != nil {
+			validator.addError(userPath+".passwordHash", "%v", err)
+		}
+	}
+
+	for i, tokenInfo := range authInfo.Tokens {
+		tokenPath := fmt.Sprintf("%v.tokens[%v]", path, i)
+		validator.checkNotEmpty(tokenPath+".name", tokenInfo.Name)
+		validator.checkFileExists(tokenPath+".tokenFile", tokenInfo.TokenFile)
+	}
+
+	if trustedHeaderInfo := authInfo.TrustedHeader; trustedHeaderInfo != nil {
+		if !httpHeaderNameRegexp.MatchString(trustedHeaderInfo.Header) {
+			validator.addError(path+".trustedHeader.header", "invalid header name %q", trustedHeaderInfo.Header)
+		}
+		if len(trustedHeaderInfo.TrustedProxies) == 0 {
+			validator.addError(path+".trustedHeader.trustedProxies", "must not be empty")
+		}
+		validator.checkIPNets(path+".trustedHeader.trustedProxies", trustedHeaderInfo.TrustedProxies)
+	}
+
+	if len(authInfo.DefaultPolicy) > 0 {
+		validator.checkAuthPolicy(path+".defaultPolicy", authInfo.DefaultPolicy)
+	}
+
+	for i, routeInfo := range authInfo.Routes {
+		routePath := fmt.Sprintf("%v.routes[%v]", path, i)
+		if !strings.HasPrefix(routeInfo.Path, "/") {
+			validator.addError(routePath+".path", "%q must start with /", routeInfo.Path)
+		}
+		validator.checkAuthPolicy(routePath+".policy", routeInfo.Policy)
+	}
+
+	roleNames := make(map[string]bool)
+	for i, roleInfo := range authInfo.Roles {
+		rolePath := fmt.Sprintf("%v.roles[%v]", path, i)
+		if len(roleInfo.Name) == 0 {
+			validator.addError(rolePath+".name", "must not be empty")
+		} else if roleNames[roleInfo.Name] {
+			validator.addError(rolePath+".name", "duplicate role %q", roleInfo.Name)
+		}
+		roleNames[roleInfo.Name] = true
+
+		checkIDPatterns := func(patternsPath string, patterns []string) {
+			for j, pattern := range patterns {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					validator.addError(fmt.Sprintf("%v[%v]", patternsPath, j), "invalid pattern %q: %v", pattern, err)
+				}
+			}
+		}
+		checkIDPatterns(rolePath+".commandIDs", roleInfo.CommandIDs)
+		checkIDPatterns(rolePath+".proxyIDs", roleInfo.ProxyIDs)
+		validator.checkPathPatterns(rolePath+".paths", roleInfo.Paths)
+	}
+
+	if (len(authInfo.UserRoles) > 0) && (len(authInfo.Roles) == 0) {
+		validator.addError(path+".userRoles", "requires roles")
+	}
+	for name, userRoles := range authInfo.UserRoles {
+		for _, roleName := range userRoles {
+			if !roleNames[roleName] {
+				validator.addError(path+".userRoles."+name, "unknown role %q", roleName)
+			}
+		}
+	}
+}
+
+func (validator *validator) validateRateLimitInfo(rateLimitInfo *RateLimitInfo) {
+	const path = "rateLimitInfo"
+
+	validator.checkIPNets(path+".trustedProxies", rateLimitInfo.TrustedProxies)
+
+	if len(rateLimitInfo.Classes) == 0 {
+		validator.addError(path+".classes", "must not be empty")
+	}
+
+	classNames := make(map[string]bool)
+	for i, classInfo := range rateLimitInfo.Classes {
+		classPath := fmt.Sprintf("%v.classes[%v]", path, i)
+		if len(classInfo.Name) == 0 {
+			validator.addError(classPath+".name", "must not be empty")
+		} else if classNames[classInfo.Name] {
+			validator.addError(classPath+".name", "duplicate class %q", classInfo.Name)
+		}
+		classNames[classInfo.Name] = true
+
+		if len(classInfo.Paths) == 0 {
+			validator.addError(classPath+".paths", "must not be empty")
+		}
+		validator.checkPathPatterns(classPath+".paths", classInfo.Paths)
+
+		if classInfo.RequestsPerSecond < 0 {
+			validator.addError(classPath+".requestsPerSecond", "must not be negative, got %v", classInfo.RequestsPerSecond)
+		}
+		validator.checkNotNegative(classPath+".burst", int64(classInfo.Burst))
+		validator.checkNotNegative(classPath+".maxConcurrentRequests", int64(classInfo.MaxConcurrentRequests))
+		if (classInfo.RequestsPerSecond == 0) && (classInfo.MaxConcurrentRequests == 0) {
+			validator.addError(classPath, "one of requestsPerSecond or maxConcurrentRequests is required")
+		}
+	}
+}
+
+func (validator *validator) validateSystemInfo(systemInfo *SystemInfo) {
+	const path = "systemInfo"
+
+	if len(systemInfo.ProcRoot) > 0 {
+		validator.checkFileExists(path+".procRoot", systemInfo.ProcRoot)
+	}
+	if len(systemInfo.SysRoot) > 0 {
+		validator.checkFileExists(path+".sysRoot", systemInfo.SysRoot)
+	}
+	for i, mount := range systemInfo.Mounts {
+		validator.checkFileExists(fmt.Sprintf("%v.mounts[%v]", path, i), mount)
+	}
+}
+
+// Validate checks configuration for problems that would otherwise surface as
+// panics or fatal errors when creating handlers and servers.
+// All problems found are returned as ValidationErrors.
+func (configuration *Configuration) Validate() error {
+	validator := newValidator()
+
+	validator.checkPositive("shutdownTimeoutMilliseconds", int64(configuration.ShutdownTimeoutMilliseconds))
+
+	if configuration.ConfigurationReloadInfo.WatchConfigFile {
+		validator.checkPositive("configurationReloadInfo.watchIntervalMilliseconds",
+			int64(configuration.ConfigurationReloadInfo.WatchIntervalMilliseconds))
+	}
+
+	validator.reserveBuiltInHTTPPaths(configuration)
+
+	validator.validateLogInfo(&configuration.LogInfo)
+	validator.validateServerInfoList(configuration.ServerInfoList)
+	validator.checkIPNets("metricsInfo.allowedNetworks", configuration.MetricsInfo.AllowedNetworks)
+	validator.validateStaticFiles(configuration.StaticFiles)
+	validator.validateStaticDirectories(configuration.StaticDirectories)
+	validator.validateCommandConfiguration(&configuration.CommandConfiguration)
+	validator.validateProxies(configuration.Proxies)
+	if configuration.AuthInfo != nil {
+		validator.validateAuthInfo(configuration.AuthInfo)
+	}
+	if configuration.RateLimitInfo != nil {
+		validator.validateRateLimitInfo(configuration.RateLimitInfo)
+	}
+	if configuration.SystemInfo != nil {
+		validator.validateSystemInfo(configuration.SystemInfo)
+	}
+
+	if len(validator.validationErrors) > 0 {
+		return validator.validationErrors
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func validConfiguration() *Configuration {
+	return &Configuration{
+		ShutdownTimeoutMilliseconds: 1000,
+		ServerInfoList: []ServerInfo{
+			{
+				HTTPServerInfo: &HTTPServerInfo{
+					ListenAddress: "127.0.0.1:8080",
+				},
+			},
+		},
+		CommandConfiguration: CommandConfiguration{
+			MaxConcurrentCommands:      1,
+			RequestTimeoutMilliseconds: 1000,
+			Commands: []CommandInfo{
+				{
+					ID:      "uptime",
+					Command: "uptime",
+				},
+			},
+		},
+		Proxies: []ProxyInfo{
+			{
+				ID:  "local",
+				URL: "http://127.0.0.1:8081/",
+			},
+		},
+	}
+}
+
+func TestValidateValidConfiguration(t *testing.T) {
+	if err := validConfiguration().Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidateDefaultMinScheduleInterval(t *testing.T) {
+	for _, scheduleInfo := range []CommandScheduleInfo{
+		{Cron: "* * * * *", HistorySize: 10},
+		{Cron: "*/2 * * * *", HistorySize: 10},
+		{IntervalMilliseconds: 60000, HistorySize: 10},
+	} {
+		configuration := validConfiguration()
+		scheduleInfo := scheduleInfo
+		configuration.CommandConfiguration.Commands[0].Schedule = &scheduleInfo
+		if err := configuration.Validate(); err != nil {
+			t.Errorf("Validate() with schedule %+v = %v, want nil", scheduleInfo, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		modify      func(configuration *Configuration)
+		wantPath    string
+		wantMessage string
+	}{
+		{
+			name: "shutdown timeout not positive",
+			modify: func(configuration *Configuration) {
+				configuration.ShutdownTimeoutMilliseconds = 0
+			},
+			wantPath:    "shutdownTimeoutMilliseconds",
+			wantMessage: "must be positive, got 0",
+		},
+		{
+			name: "no servers",
+			modify: func(configuration *Configuration) {
+				configuration.ServerInfoList = nil
+			},
+			wantPath:    "serverInfoList",
+			wantMessage: "must not be empty",
+		},
+		{
+			name: "server without listen address",
+			modify: func(configuration *Configuration) {
+				configuration.ServerInfoList[0].HTTPServerInfo.ListenAddress = " "
+			},
+			wantPath:    "serverInfoList[0].httpServerInfo.listenAddress",
+			wantMessage: "must not be empty",
+		},
+		{
+			name: "server with both types",
+			modify: func(configuration *Configuration) {
+				configuration.ServerInfoList[0].HTTP3ServerInfo = &HTTP3ServerInfo{}
+			},
+			wantPath:    "serverInfoList[0]",
+			wantMessage: "only one of http3ServerInfo or httpServerInfo is allowed",
+		},
+		{
+			name: "negative buffer entries",
+			modify: func(configuration *Configuration) {
+				configuration.LogInfo.BufferEntries = -1
+			},
+			wantPath:    "logInfo.bufferEntries",
+			wantMessage: "must not be negative, got -1",
+		},
+		{
+			name: "invalid command ID",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.Commands[0].ID = "up time"
+			},
+			wantPath:    "commandConfiguration.commands[0].id",
+			wantMessage: `"up time" must match ^[A-Za-z0-9_.-]+$`,
+		},
+		{
+			name: "duplicate command ID",
+			modify: func(configuration *Configuration) {
+				commands := &configuration.CommandConfiguration.Commands
+				*commands = append(*commands, (*commands)[0])
+			},
+			wantPath:    "commandConfiguration.commands[1].id",
+			wantMessage: `duplicate id "uptime" also used by commandConfiguration.commands[0].id`,
+		},
+		{
+			name: "zero max concurrent commands",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.MaxConcurrentCommands = 0
+			},
+			wantPath:    "commandConfiguration.maxConcurrentCommands",
+			wantMessage: "must be positive, got 0",
+		},
+		{
+			name: "stream timeout less than request timeout",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.StreamTimeoutMilliseconds = 500
+			},
+			wantPath:    "commandConfiguration.streamTimeoutMilliseconds",
+			wantMessage: "must not be less than requestTimeoutMilliseconds 1000",
+		},
+		{
+			name: "processes limit without credential",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.Commands[0].ResourceLimits = &CommandResourceLimitsInfo{
+					Processes: 10,
+				}
+			},
+			wantPath:    "commandConfiguration.commands[0].resourceLimits.processes",
+			wantMessage: "requires credential, since the limit counts every process and thread of the uid",
+		},
+		{
+			name: "schedule with interval and cron",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.Commands[0].Schedule = &CommandScheduleInfo{
+					IntervalMilliseconds: 60000,
+					Cron:                 "*/5 * * * *",
+					HistorySize:          10,
+				}
+			},
+			wantPath:    "commandConfiguration.commands[0].schedule",
+			wantMessage: "only one of intervalMilliseconds or cron is allowed",
+		},
+		{
+			name: "cron schedule more often than minimum interval",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.MinScheduleIntervalMilliseconds = 300000
+				configuration.CommandConfiguration.Commands[0].Schedule = &CommandScheduleInfo{
+					Cron:        "* * * * *",
+					HistorySize: 10,
+				}
+			},
+			wantPath:    "commandConfiguration.commands[0].schedule.cron",
+			wantMessage: `"* * * * *" runs every 1m0s, less than commandConfiguration.minScheduleIntervalMilliseconds 5m0s`,
+		},
+		{
+			name: "cron schedule more often than configured minimum interval",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.MinScheduleIntervalMilliseconds = 3600000
+				configuration.CommandConfiguration.Commands[0].Schedule = &CommandScheduleInfo{
+					Cron:        "0,30 * * * *",
+					HistorySize: 10,
+				}
+			},
+			wantPath:    "commandConfiguration.commands[0].schedule.cron",
+			wantMessage: `"0,30 * * * *" runs every 30m0s, less than commandConfiguration.minScheduleIntervalMilliseconds 1h0m0s`,
+		},
+		{
+			name: "interval schedule more often than minimum interval",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.Commands[0].Schedule = &CommandScheduleInfo{
+					IntervalMilliseconds: 1000,
+					HistorySize:          10,
+				}
+			},
+			wantPath:    "commandConfiguration.commands[0].schedule.intervalMilliseconds",
+			wantMessage: "1s is less than commandConfiguration.minScheduleIntervalMilliseconds 1m0s",
+		},
+		{
+			name: "proxy url scheme",
+			modify: func(configuration *Configuration) {
+				configuration.Proxies[0].URL = "ftp://127.0.0.1/"
+			},
+			wantPath:    "proxies[0].url",
+			wantMessage: `"ftp://127.0.0.1/" must be an http or https url`,
+		},
+		{
+			name: "unknown proxy mode",
+			modify: func(configuration *Configuration) {
+				configuration.Proxies[0].Mode = "tunnel"
+			},
+			wantPath:    "proxies[0].mode",
+			wantMessage: `unknown mode "tunnel"`,
+		},
+		{
+			name: "reverse proxy path prefix without trailing slash",
+			modify: func(configuration *Configuration) {
+				configuration.Proxies[0].Mode = ProxyModeReverse
+				configuration.Proxies[0].PathPrefix = "/grafana"
+			},
+			wantPath:    "proxies[0].pathPrefix",
+			wantMessage: `"/grafana" must end with /`,
+		},
+		{
+			name: "static file path without slash",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "favicon.ico", FilePath: "favicon.ico"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `"favicon.ico" must start with /`,
+		},
+		{
+			name: "duplicate static paths",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/favicon.ico", FilePath: "favicon.ico"},
+				}
+				configuration.StaticDirectories = []StaticDirectoryInfo{
+					{HTTPPath: "/favicon.ico", DirectoryPath: "static"},
+				}
+			},
+			wantPath:    "staticDirectories[0].httpPath",
+			wantMessage: `httpPath "/favicon.ico" also used by staticFiles[0].httpPath`,
+		},
+		{
+			name: "static file replacing logs",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/api/logs", FilePath: "logs.json"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/api/logs" also used by built-in route`,
+		},
+		{
+			name: "static file replacing health",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/api/health", FilePath: "health.json"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/api/health" also used by built-in route`,
+		},
+		{
+			name: "static directory replacing metrics",
+			modify: func(configuration *Configuration) {
+				configuration.MetricsInfo.Enabled = true
+				configuration.StaticDirectories = []StaticDirectoryInfo{
+					{HTTPPath: "/metrics", DirectoryPath: "metrics"},
+				}
+			},
+			wantPath:    "staticDirectories[0].httpPath",
+			wantMessage: `httpPath "/metrics" also used by built-in route`,
+		},
+		{
+			name: "static file replacing system page",
+			modify: func(configuration *Configuration) {
+				configuration.SystemInfo = &SystemInfo{}
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/system.html", FilePath: "system.html"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/system.html" also used by built-in route`,
+		},
+		{
+			name: "static file replacing configuration",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/configuration", FilePath: "configuration.json"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/configuration" also used by built-in route`,
+		},
+		{
+			name: "reverse proxy replacing main page",
+			modify: func(configuration *Configuration) {
+				configuration.Proxies[0].Mode = ProxyModeReverse
+				configuration.Proxies[0].PathPrefix = "/"
+			},
+			wantPath:    "proxies[0].pathPrefix",
+			wantMessage: `httpPath "/" also used by built-in route`,
+		},
+		{
+			name: "static file replacing command page",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/commands/uptime.html", FilePath: "uptime.html"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/commands/uptime.html" also used by commandConfiguration.commands[0]`,
+		},
+		{
+			name: "static file replacing command api",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/api/commands/uptime", FilePath: "uptime.json"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/api/commands/uptime" also used by commandConfiguration.commands[0]`,
+		},
+		{
+			name: "static file replacing command stream",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/api/commands/uptime/stream", FilePath: "stream.txt"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/api/commands/uptime/stream" also used by commandConfiguration.commands[0]`,
+		},
+		{
+			name: "static file replacing command history",
+			modify: func(configuration *Configuration) {
+				configuration.CommandConfiguration.Commands[0].Schedule = &CommandScheduleInfo{
+					IntervalMilliseconds: 60 * 60 * 1000,
+					HistorySize:          1,
+				}
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/api/commands/uptime/history", FilePath: "history.json"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/api/commands/uptime/history" also used by commandConfiguration.commands[0]`,
+		},
+		{
+			name: "static directory replacing proxy page",
+			modify: func(configuration *Configuration) {
+				configuration.StaticDirectories = []StaticDirectoryInfo{
+					{HTTPPath: "/proxies/local.html", DirectoryPath: "static"},
+				}
+			},
+			wantPath:    "staticDirectories[0].httpPath",
+			wantMessage: `httpPath "/proxies/local.html" also used by proxies[0]`,
+		},
+		{
+			name: "static file replacing proxy api",
+			modify: func(configuration *Configuration) {
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/api/proxies/local", FilePath: "local.json"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/api/proxies/local" also used by proxies[0]`,
+		},
+		{
+			name: "static file replacing login",
+			modify: func(configuration *Configuration) {
+				configuration.AuthInfo = &AuthInfo{
+					Users: []AuthUserInfo{
+						{Username: "admin", PasswordHash: "$2a$10$hash"},
+					},
+				}
+				configuration.StaticFiles = []StaticFileInfo{
+					{HTTPPath: "/login", FilePath: "login.html"},
+				}
+			},
+			wantPath:    "staticFiles[0].httpPath",
+			wantMessage: `httpPath "/login" also used by built-in route`,
+		},
+		{
+			name: "auth without users, tokens, or trusted header",
+			modify: func(configuration *Configuration) {
+				configuration.AuthInfo = &AuthInfo{}
+			},
+			wantPath:    "authInfo",
+			wantMessage: "one of users, tokens, or trustedHeader is required",
+		},
+		{
+			name: "rate limit without classes",
+			modify: func(configuration *Configuration) {
+				configuration.RateLimitInfo = &RateLimitInfo{}
+			},
+			wantPath:    "rateLimitInfo.classes",
+			wantMessage: "must not be empty",
+		},
+		{
+			name: "rate limit trusted proxy",
+			modify: func(configuration *Configuration) {
+				configuration.RateLimitInfo = &RateLimitInfo{
+					TrustedProxies: []string{"10.0.0.0/33"},
+					Classes: []RateLimitClassInfo{
+						{Name: "api", Paths: []string{"/api/"}, RequestsPerSecond: 1},
+					},
+				}
+			},
+			wantPath:    "rateLimitInfo.trustedProxies[0]",
+			wantMessage: `"10.0.0.0/33" is not an IP address or CIDR block`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := validConfiguration()
+			test.modify(configuration)
+
+			err := configuration.Validate()
+
+			var validationErrors ValidationErrors
+			if !errors.As(err, &validationErrors) {
+				t.Fatalf("Validate() = %v, want ValidationErrors", err)
+			}
+			want := ValidationError{Path: test.wantPath, Message: test.wantMessage}
+			for _, validationError := range validationErrors {
+				if validationError == want {
+					return
+				}
+			}
+			t.Errorf("Validate() = %v, want error %v", err, want)
+		})
+	}
+}
+
+func TestValidateMinScheduleInterval(t *testing.T) {
+	configuration := validConfiguration()
+	configuration.CommandConfiguration.MinScheduleIntervalMilliseconds = 60000
+	configuration.CommandConfiguration.Commands[0].Schedule = &CommandScheduleInfo{
+		Cron:        "* * * * *",
+		HistorySize: 10,
+	}
+
+	if err := configuration.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidationErrorsError(t *testing.T) {
+	validationErrors := ValidationErrors{
+		{Path: "serverInfoList", Message: "must not be empty"},
+		{Path: "shutdownTimeoutMilliseconds", Message: "must be positive, got 0"},
+	}
+
+	want := "2 configuration error(s):\n" +
+		"  serverInfoList: must not be empty\n" +
+		"  shutdownTimeoutMilliseconds: must be positive, got 0"
+	if got := validationErrors.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed standard 5 field cron expression:
+// minute hour day-of-month month day-of-week
+//
+// Each field may be *, a number, a range a-b, a list a,b,c, and any of these
+// with a step such as */5 or 1-30/2.  Day of week 0 and 7 are both Sunday.
+// As in cron, if both day of month and day of week are restricted a time
+// matching either one matches.
+type Schedule struct {
+	minutes               uint64
+	hours                 uint64
+	daysOfMonth           uint64
+	months                uint64
+	daysOfWeek            uint64
+	daysOfMonthRestricted bool
+	daysOfWeekRestricted  bool
+}
+
+type fieldBounds struct {
+	name string
+	min  int
+	max  int
+}
+
+var (
+	minuteBounds     = fieldBounds{name: "minute", min: 0, max: 59}
+	hourBounds       = fieldBounds{name: "hour", min: 0, max: 23}
+	dayOfMonthBounds = fieldBounds{name: "day of month", min: 1, max: 31}
+	monthBounds      = fieldBounds{name: "month", min: 1, max: 12}
+	dayOfWeekBounds  = fieldBounds{name: "day of week", min: 0, max: 7}
+)
+
+// maxSearchDuration limits how far ahead Next looks for a matching time,
+// so expressions like "0 0 30 2 *" that never match do not loop forever.
+const maxSearchDuration = 5 * 366 * 24 * time.Hour
+
+func parseNumber(s string, bounds fieldBounds) (int, error) {
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v %q", bounds.name, s)
+	}
+	if (value < bounds.min) || (value > bounds.max) {
+		return 0, fmt.Errorf("%v %v out of range %v-%v", bounds.name, value, bounds.min, bounds.max)
+	}
+	return value, nil
+}
+
+// parseField returns a bitmask of the values matched by field and whether the field is restricted (not *).
+func parseField(field string, bounds fieldBounds) (uint64, bool, error) {
+	var bits uint64
+	restricted := true
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart := part
+		step := 1
+
+		if slashIndex := strings.Index(part, "/"); slashIndex >= 0 {
+			rangePart = part[:slashIndex]
+			var err error
+			step, err = strconv.Atoi(part[slashIndex+1:])
+			if (err != nil) || (step <= 0) {
+				return 0, false, fmt.Errorf("invalid %v step %q", bounds.name, part)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = bounds.min, bounds.max
+			if step == 1 {
+				restricted = false
+			}
+
+		case strings.Contains(rangePart, "-"):
+			dashIndex := strings.Index(rangePart, "-")
+			var err error
+			if low, err = parseNumber(rangePart[:dashIndex], bounds); err != nil {
+				return 0, false, err
+			}
+			if high, err = parseNumber(rangePart[dashIndex+1:], bounds); err != nil {
+				return 0, false, err
+			}
+			if low > high {
+				return 0, false, fmt.Errorf("invalid %v range %q", bounds.name, rangePart)
+			}
+
+		default:
+			var err error
+			if low, err = parseNumber(rangePart, bounds); err != nil {
+				return 0, false, err
+			}
+			high = low
+			if step != 1 {
+				high = bounds.max
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, restricted, nil
+}
+
+// Parse parses a 5 field cron expression.
+func Parse(expression string) (*Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %v", expression, len(fields))
+	}
+
+	schedule := &Schedule{}
+	var err error
+
+	if schedule.minutes, _, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if schedule.hours, _, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if schedule.daysOfMonth, schedule.daysOfMonthRestricted, err = parseField(fields[2], dayOfMonthBounds); err != nil {
+		return nil, err
+	}
+	if schedule.months, _, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if schedule.daysOfWeek, schedule.daysOfWeekRestricted, err = parseField(fields[4], dayOfWeekBounds); err != nil {
+		return nil, err
+	}
+
+	// 7 is also Sunday.
+	if schedule.daysOfWeek&(1<<7) != 0 {
+		schedule.daysOfWeek |= 1
+	}
+
+	return schedule, nil
+}
+
+func (schedule *Schedule) dayMatches(t time.Time) bool {
+	dayOfMonthMatches := schedule.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeekMatches := schedule.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if schedule.daysOfMonthRestricted && schedule.daysOfWeekRestricted {
+		return dayOfMonthMatches || dayOfWeekMatches
+	}
+	return dayOfMonthMatches && dayOfWeekMatches
+}
+
+// Next returns the first matching time after t, or the zero time if there is none within 5 years.
+func (schedule *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearchDuration)
+
+	for t.Before(limit) {
+		if schedule.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !schedule.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// minIntervalSearchDuration is how far ahead MinInterval looks at runs.
+const minIntervalSearchDuration = 366 * 24 * time.Hour
+
+// MinInterval returns the shortest time between consecutive runs in the year after t,
+// or 0 if there are fewer than two runs in that time.
+func (schedule *Schedule) MinInterval(t time.Time) time.Duration {
+	limit := t.Add(minIntervalSearchDuration)
+
+	var minInterval time.Duration
+	previous := schedule.Next(t)
+	for !previous.IsZero() && previous.Before(limit) {
+		next := schedule.Next(previous)
+		if next.IsZero() {
+			break
+		}
+		if interval := next.Sub(previous); (minInterval == 0) || (interval < minInterval) {
+			minInterval = interval
+			// Runs can not be closer than a minute.
+			if minInterval <= time.Minute {
+				break
+			}
+		}
+		previous = next
+	}
+	return minInterval
+}
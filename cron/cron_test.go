@@ -0,0 +1,116 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantError  string
+	}{
+		{"* * * *", `cron expression "* * * *" must have 5 fields, got 4`},
+		{"60 * * * *", "minute 60 out of range 0-59"},
+		{"* 24 * * *", "hour 24 out of range 0-23"},
+		{"* * 0 * *", "day of month 0 out of range 1-31"},
+		{"* * * 13 *", "month 13 out of range 1-12"},
+		{"* * * * 8", "day of week 8 out of range 0-7"},
+		{"a * * * *", `invalid minute "a"`},
+		{"30-10 * * * *", `invalid minute range "30-10"`},
+		{"*/0 * * * *", `invalid minute step "*/0"`},
+		{"*/x * * * *", `invalid minute step "*/x"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Parse(test.expression)
+			if err == nil {
+				t.Fatalf("Parse(%q) error = nil, want %q", test.expression, test.wantError)
+			}
+			if err.Error() != test.wantError {
+				t.Errorf("Parse(%q) error = %q, want %q", test.expression, err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2022-03-01 is a Tuesday.
+	tests := []struct {
+		name       string
+		expression string
+		after      time.Time
+		want       time.Time
+	}{
+		{"every minute", "* * * * *", date(2022, 3, 1, 10, 30), date(2022, 3, 1, 10, 31)},
+		{"seconds truncated", "* * * * *", date(2022, 3, 1, 10, 30).Add(59 * time.Second), date(2022, 3, 1, 10, 31)},
+		{"minute step", "*/15 * * * *", date(2022, 3, 1, 10, 31), date(2022, 3, 1, 10, 45)},
+		{"minute step wraps hour", "*/15 * * * *", date(2022, 3, 1, 10, 45), date(2022, 3, 1, 11, 0)},
+		{"range", "10-12 * * * *", date(2022, 3, 1, 10, 11), date(2022, 3, 1, 10, 12)},
+		{"range wraps hour", "10-12 * * * *", date(2022, 3, 1, 10, 12), date(2022, 3, 1, 11, 10)},
+		{"range with step", "0 9-17/4 * * *", date(2022, 3, 1, 13, 0), date(2022, 3, 1, 17, 0)},
+		{"number with step", "5/20 * * * *", date(2022, 3, 1, 10, 25), date(2022, 3, 1, 10, 45)},
+		{"list", "0 6,18 * * *", date(2022, 3, 1, 6, 0), date(2022, 3, 1, 18, 0)},
+		{"day of month", "0 0 15 * *", date(2022, 3, 1, 0, 0), date(2022, 3, 15, 0, 0)},
+		{"day of month skips short months", "0 0 31 * *", date(2022, 3, 31, 0, 0), date(2022, 5, 31, 0, 0)},
+		{"month", "0 0 1 6 *", date(2022, 3, 1, 0, 0), date(2022, 6, 1, 0, 0)},
+		{"day of week", "0 0 * * 5", date(2022, 3, 1, 0, 0), date(2022, 3, 4, 0, 0)},
+		{"day of week 7 is Sunday", "0 0 * * 7", date(2022, 3, 1, 0, 0), date(2022, 3, 6, 0, 0)},
+		{"day of week range", "0 0 * * 1-5", date(2022, 3, 4, 0, 0), date(2022, 3, 7, 0, 0)},
+		// Day of month and day of week both restricted match either: the 10th, a Thursday,
+		// comes after Friday the 4th.
+		{"day of month or day of week", "0 0 10 * 5", date(2022, 3, 1, 0, 0), date(2022, 3, 4, 0, 0)},
+		{"day of month or day of week, day of month first", "0 0 2 * 5", date(2022, 3, 1, 0, 0), date(2022, 3, 2, 0, 0)},
+		// Day of week with unrestricted day of month must match day of week.
+		{"day of week with day of month *", "0 0 * * 3", date(2022, 3, 1, 0, 0), date(2022, 3, 2, 0, 0)},
+		// A day of month step restricts it, so the OR rule applies.
+		{"day of month step or day of week", "0 0 */10 * 5", date(2022, 3, 1, 0, 0), date(2022, 3, 4, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2022, 3, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+		{"never", "0 0 30 2 *", date(2022, 3, 1, 0, 0), time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := Parse(test.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", test.expression, err)
+			}
+			if got := schedule.Next(test.after); !got.Equal(test.want) {
+				t.Errorf("Parse(%q).Next(%v) = %v, want %v", test.expression, test.after, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       time.Duration
+	}{
+		{"* * * * *", time.Minute},
+		{"*/5 * * * *", 5 * time.Minute},
+		{"0,50 * * * *", 10 * time.Minute},
+		{"0 * * * *", time.Hour},
+		{"30 2 * * *", 24 * time.Hour},
+		{"0 0 * * 1", 7 * 24 * time.Hour},
+		{"0 0 1,31 * *", 24 * time.Hour},
+		{"0 0 30 2 *", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			schedule, err := Parse(test.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", test.expression, err)
+			}
+			if got := schedule.MinInterval(date(2022, 3, 1, 0, 0)); got != test.want {
+				t.Errorf("Parse(%q).MinInterval() = %v, want %v", test.expression, got, test.want)
+			}
+		})
+	}
+}
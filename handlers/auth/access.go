@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+const (
+	resourceKindCommand = "command"
+	resourceKindProxy   = "proxy"
+	resourceKindPath    = "path"
+	// resourceKindAnyUser is a path any authenticated user may access, because its
+	// handler limits the response to what the user may access.
+	resourceKindAnyUser = "anyUser"
+)
+
+// anyUserPaths are the paths of resourceKindAnyUser.
+var anyUserPaths = []string{
+	"/api/health",
+}
+
+// resource is what a request path refers to for authorization.
+type resource struct {
+	kind string
+	id   string
+}
+
+func (resource resource) String() string {
+	return resource.kind + " " + resource.id
+}
+
+type prefixResource struct {
+	prefix   string
+	resource resource
+}
+
+// resourceMap maps the paths registered by the command and proxy handlers to
+// their commands and proxies.
+type resourceMap struct {
+	paths    map[string]resource
+	prefixes []prefixResource
+}
+
+func newResourceMap(configuration *config.Configuration) *resourceMap {
+	resourceMap := &resourceMap{
+		paths: make(map[string]resource),
+	}
+
+	for _, commandInfo := range configuration.CommandConfiguration.Commands {
+		commandResource := resource{
+			kind: resourceKindCommand,
+			id:   commandInfo.ID,
+		}
+		apiPath := "/api/commands/" + commandInfo.ID
+		resourceMap.paths["/commands/"+commandInfo.ID+".html"] = commandResource
+		resourceMap.paths[apiPath] = commandResource
+		resourceMap.paths[apiPath+"/stream"] = commandResource
+		resourceMap.paths[apiPath+"/history"] = commandResource
+	}
+
+	for _, proxyInfo := range configuration.Proxies {
+		proxyResource := resource{
+			kind: resourceKindProxy,
+			id:   proxyInfo.ID,
+		}
+		if proxyInfo.IsReverse() {
+			resourceMap.prefixes = append(resourceMap.prefixes, prefixResource{
+				prefix:   proxyInfo.ReversePathPrefix(),
+				resource: proxyResource,
+			})
+			continue
+		}
+		resourceMap.paths["/proxies/"+proxyInfo.ID+".html"] = proxyResource
+		resourceMap.paths["/api/proxies/"+proxyInfo.ID] = proxyResource
+	}
+
+	for _, path := range anyUserPaths {
+		resourceMap.paths[path] = resource{
+			kind: resourceKindAnyUser,
+			id:   path,
+		}
+	}
+
+	sort.SliceStable(resourceMap.prefixes, func(i, j int) bool {
+		return len(resourceMap.prefixes[i].prefix) > len(resourceMap.prefixes[j].prefix)
+	})
+
+	return resourceMap
+}
+
+func (resourceMap *resourceMap) lookup(path string) resource {
+	if resource, ok := resourceMap.paths[path]; ok {
+		return resource
+	}
+	for _, prefixResource := range resourceMap.prefixes {
+		if strings.HasPrefix(path, prefixResource.prefix) {
+			return prefixResource.resource
+		}
+	}
+	return resource{
+		kind: resourceKindPath,
+		id:   path,
+	}
+}
+
+type role struct {
+	commandIDs []string
+	proxyIDs   []string
+	paths      []utils.PathPattern
+}
+
+func newRole(roleInfo *config.AuthRoleInfo) *role {
+	role := &role{
+		commandIDs: roleInfo.CommandIDs,
+		proxyIDs:   roleInfo.ProxyIDs,
+	}
+	for _, path := range roleInfo.Paths {
+		role.paths = append(role.paths, utils.NewPathPattern(path))
+	}
+	return role
+}
+
+func matchesIDPattern(patterns []string, id string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, id); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (role *role) allows(resource resource) bool {
+	switch resource.kind {
+	case resourceKindCommand:
+		return matchesIDPattern(role.commandIDs, resource.id)
+
+	case resourceKindProxy:
+		return matchesIDPattern(role.proxyIDs, resource.id)
+	}
+
+	for _, pathPattern := range role.paths {
+		if pathPattern.Matches(resource.id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Access is what the user making a request may access.  A nil Access, as
+// returned when authentication is not configured, may access everything.
+type Access struct {
+	user          *User
+	authenticator *Authenticator
+}
+
+func contextWithAccess(ctx context.Context, access *Access) context.Context {
+	return context.WithValue(ctx, contextKey{}, access)
+}
+
+// AccessFromContext returns the Access of a request.
+func AccessFromContext(ctx context.Context) *Access {
+	access, _ := ctx.Value(contextKey{}).(*Access)
+	return access
+}
+
+// UserFromContext returns the authenticated user of a request, if any.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user := AccessFromContext(ctx).User()
+	return user, (user != nil)
+}
+
+// User returns the authenticated user, or nil if the request has no credentials.
+func (access *Access) User() *User {
+	if access == nil {
+		return nil
+	}
+	return access.user
+}
+
+// PathAllowed reports whether the user may make requests to path.  Without roles
+// configured this is true even for paths that would ask for credentials first.
+func (access *Access) PathAllowed(path string) bool {
+	if access == nil {
+		return true
+	}
+	allowed, _ := access.authenticator.authorize(access.user, path)
+	return allowed
+}
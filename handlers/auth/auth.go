@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MethodBasic         = "basic"
+	MethodBearer        = "bearer"
+	MethodTrustedHeader = "trustedHeader"
+)
+
+const authorizationHeaderKey = "Authorization"
+
+// LoginPath asks for HTTP Basic credentials if the request has none, then redirects to the main page.
+const LoginPath = "/login"
+
+// User is the authenticated user making a request, and how they were authenticated.
+type User struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+}
+
+type contextKey struct{}
+
+type route struct {
+	utils.PathPattern
+	policy string
+}
+
+type bearerToken struct {
+	name  string
+	token []byte
+}
+
+// Authenticator authenticates and authorizes requests as configured by config.AuthInfo.
+type Authenticator struct {
+	realm          string
+	passwordHashes map[string][]byte
+	tokens         []bearerToken
+	trustedHeader  string
+	trustedProxies []*net.IPNet
+	defaultPolicy  string
+	routes         []route
+	resourceMap    *resourceMap
+	userRoles      map[string][]*role
+	checkRoles     bool
+
+	// bcrypt is deliberately slow, so the SHA-256 of each user's last verified
+	// password is kept to avoid rehashing on every request.
+	verifiedPasswordsMutex sync.Mutex
+	verifiedPasswords      map[string][sha256.Size]byte
+}
+
+// NewAuthenticator returns an Authenticator for configuration.AuthInfo, which must not be nil.
+func NewAuthenticator(configuration *config.Configuration) (*Authenticator, error) {
+	authInfo := configuration.AuthInfo
+
+	authenticator := &Authenticator{
+		realm:             authInfo.Realm,
+		passwordHashes:    make(map[string][]byte, len(authInfo.Users)),
+		defaultPolicy:     authInfo.DefaultPolicy,
+		resourceMap:       newResourceMap(configuration),
+		userRoles:         make(map[string][]*role, len(authInfo.UserRoles)),
+		checkRoles:        len(authInfo.Roles) > 0,
+		verifiedPasswords: make(map[string][sha256.Size]byte),
+	}
+	if len(authenticator.realm) == 0 {
+		authenticator.realm = "pi-web"
+	}
+	if len(authenticator.defaultPolicy) == 0 {
+		authenticator.defaultPolicy = config.AuthPolicyAuthenticated
+	}
+
+	for _, userInfo := range authInfo.Users {
+		authenticator.passwordHashes[userInfo.Username] = []byte(userInfo.PasswordHash)
+	}
+
+	for _, tokenInfo := range authInfo.Tokens {
+		token, err := os.ReadFile(tokenInfo.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading auth token %v file: %w", tokenInfo.Name, err)
+		}
+		token = []byte(strings.TrimSpace(string(token)))
+		if len(token) == 0 {
+			return nil, fmt.Errorf("auth token %v file %v is empty", tokenInfo.Name, tokenInfo.TokenFile)
+		}
+		authenticator.tokens = append(authenticator.tokens, bearerToken{
+			name:  tokenInfo.Name,
+			token: token,
+		})
+	}
+
+	if trustedHeaderInfo := authInfo.TrustedHeader; trustedHeaderInfo != nil {
+		authenticator.trustedHeader = trustedHeaderInfo.Header
+		trustedProxies, err := utils.ParseIPNets(trustedHeaderInfo.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.trustedProxies = trustedProxies
+	}
+
+	for _, routeInfo := range authInfo.Routes {
+		authenticator.routes = append(authenticator.routes, route{
+			PathPattern: utils.NewPathPattern(routeInfo.Path),
+			policy:      routeInfo.Policy,
+		})
+	}
+
+	// Most specific first: longer paths, then exact matches before prefixes.
+	sort.SliceStable(authenticator.routes, func(i, j int) bool {
+		if len(authenticator.routes[i].Path) != len(authenticator.routes[j].Path) {
+			return len(authenticator.routes[i].Path) > len(authenticator.routes[j].Path)
+		}
+		return !authenticator.routes[i].Prefix && authenticator.routes[j].Prefix
+	})
+
+	roles := make(map[string]*role, len(authInfo.Roles))
+	for i := range authInfo.Roles {
+		roles[authInfo.Roles[i].Name] = newRole(&authInfo.Roles[i])
+	}
+	for name, roleNames := range authInfo.UserRoles {
+		for _, roleName := range roleNames {
+			role, ok := roles[roleName]
+			if !ok {
+				return nil, fmt.Errorf("unknown role %q for user %q", roleName, name)
+			}
+			authenticator.userRoles[name] = append(authenticator.userRoles[name], role)
+		}
+	}
+
+	return authenticator, nil
+}
+
+// policy returns the policy of the most specific route matching path.
+func (authenticator *Authenticator) policy(path string) string {
+	for i := range authenticator.routes {
+		if authenticator.routes[i].Matches(path) {
+			return authenticator.routes[i].policy
+		}
+	}
+	return authenticator.defaultPolicy
+}
+
+// authorize reports whether user, which is nil for requests without credentials,
+// may make requests to path, and the resource path refers to.
+func (authenticator *Authenticator) authorize(user *User, path string) (bool, resource) {
+	resource := authenticator.resourceMap.lookup(path)
+
+	switch {
+	case authenticator.policy(path) == config.AuthPolicyPublic:
+		return true, resource
+	case !authenticator.checkRoles:
+		return true, resource
+	case user == nil:
+		return false, resource
+	case resource.kind == resourceKindAnyUser:
+		return true, resource
+	}
+
+	for _, role := range authenticator.userRoles[user.Name] {
+		if role.allows(resource) {
+			return true, resource
+		}
+	}
+	return false, resource
+}
+
+func (authenticator *Authenticator) checkPassword(username string, password string) bool {
+	passwordHash, ok := authenticator.passwordHashes[username]
+	if !ok {
+		return false
+	}
+
+	passwordSHA256 := sha256.Sum256([]byte(password))
+
+	authenticator.verifiedPasswordsMutex.Lock()
+	verifiedSHA256, ok := authenticator.verifiedPasswords[username]
+	authenticator.verifiedPasswordsMutex.Unlock()
+
+	if ok && (subtle.ConstantTimeCompare(verifiedSHA256[:], passwordSHA256[:]) == 1) {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) != nil {
+		return false
+	}
+
+	authenticator.verifiedPasswordsMutex.Lock()
+	authenticator.verifiedPasswords[username] = passwordSHA256
+	authenticator.verifiedPasswordsMutex.Unlock()
+
+	return true
+}
+
+// checkToken returns the name of token, checking every configured token so the
+// time taken does not depend on which one matches.
+func (authenticator *Authenticator) checkToken(token string) (name string, ok bool) {
+	for _, bearerToken := range authenticator.tokens {
+		if subtle.ConstantTimeCompare(bearerToken.token, []byte(token)) == 1 {
+			name = bearerToken.name
+			ok = true
+		}
+	}
+	return
+}
+
+func (authenticator *Authenticator) fromTrustedProxy(r *http.Request) bool {
+	ip := utils.RemoteIP(r.RemoteAddr)
+	return (ip != nil) && utils.IPNetsContain(authenticator.trustedProxies, ip)
+}
+
+// authenticate returns the user r is authenticated as, or nil if r has no credentials.
+// An error is returned if r has credentials that are not valid.
+func (authenticator *Authenticator) authenticate(r *http.Request) (*User, error) {
+	if len(authenticator.trustedHeader) > 0 {
+		name := r.Header.Get(authenticator.trustedHeader)
+		// Remove the header so nothing downstream mistakes an untrusted value for a user.
+		r.Header.Del(authenticator.trustedHeader)
+		if (len(name) > 0) && authenticator.fromTrustedProxy(r) {
+			return &User{
+				Name:   name,
+				Method: MethodTrustedHeader,
+			}, nil
+		}
+	}
+
+	authorization := r.Header.Get(authorizationHeaderKey)
+	if len(authorization) == 0 {
+		return nil, nil
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		if !authenticator.checkPassword(username, password) {
+			return nil, fmt.Errorf("invalid password for user %q", username)
+		}
+		return &User{
+			Name:   username,
+			Method: MethodBasic,
+		}, nil
+	}
+
+	const bearerPrefix = "Bearer "
+	if (len(authorization) > len(bearerPrefix)) && strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		name, ok := authenticator.checkToken(strings.TrimSpace(authorization[len(bearerPrefix):]))
+		if !ok {
+			return nil, fmt.Errorf("invalid bearer token")
+		}
+		return &User{
+			Name:   name,
+			Method: MethodBearer,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported authorization scheme")
+}
+
+func (authenticator *Authenticator) unauthorized(w http.ResponseWriter) {
+	if len(authenticator.passwordHashes) > 0 {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", authenticator.realm))
+	}
+	if len(authenticator.tokens) > 0 {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", authenticator.realm))
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Handler returns a handler that authenticates and authorizes each request before
+// passing it to next with its Access in the context.  Requests with invalid credentials,
+// or with none to a route requiring authentication, get a 401 response.  Requests the
+// user's roles do not allow get a 403 response.
+func (authenticator *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticator.authenticate(r)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("authentication failed",
+				"remoteAddr", r.RemoteAddr, "path", r.URL.Path, "error", err)
+			authenticator.unauthorized(w)
+			return
+		}
+
+		if (r.URL.Path == LoginPath) && (len(authenticator.passwordHashes) > 0) {
+			if user == nil {
+				authenticator.unauthorized(w)
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+
+		if (user == nil) && (authenticator.policy(r.URL.Path) != config.AuthPolicyPublic) {
+			authenticator.unauthorized(w)
+			return
+		}
+
+		if allowed, resource := authenticator.authorize(user, r.URL.Path); !allowed {
+			logging.FromContext(r.Context()).Warn("access denied",
+				"user", user.Name, "remoteAddr", r.RemoteAddr, "resource", resource)
+			http.Error(w,
+				fmt.Sprintf("%v: user %v does not have a role allowing access to %v",
+					http.StatusText(http.StatusForbidden), user.Name, resource),
+				http.StatusForbidden)
+			return
+		}
+
+		// Credentials meant for pi-web are not passed on, for example to reverse proxy upstreams.
+		if (user != nil) && (user.Method != MethodTrustedHeader) {
+			r.Header.Del(authorizationHeaderKey)
+		}
+
+		access := &Access{
+			user:          user,
+			authenticator: authenticator,
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithAccess(r.Context(), access)))
+	})
+}
@@ -0,0 +1,173 @@
+package command
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+)
+
+type cachedCommandResponse struct {
+	response *commandAPIResponse
+	time     time.Time
+}
+
+// commandResponseCache holds the most recent successful response for each command and arguments.
+type commandResponseCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedCommandResponse
+}
+
+func newCommandResponseCache() *commandResponseCache {
+	return &commandResponseCache{
+		entries: make(map[string]cachedCommandResponse),
+	}
+}
+
+// get returns the cached response for key and its age if it is younger than ttl.
+func (cache *commandResponseCache) get(key string, ttl time.Duration) (*commandAPIResponse, time.Duration, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	age := time.Since(entry.time)
+	if age >= ttl {
+		return nil, 0, false
+	}
+	return entry.response, age, true
+}
+
+// put stores response for key and removes entries that have been cached longer than ttl.
+func (cache *commandResponseCache) put(key string, response *commandAPIResponse, ttl time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := time.Now()
+	for entryKey, entry := range cache.entries {
+		if now.Sub(entry.time) >= ttl {
+			delete(cache.entries, entryKey)
+		}
+	}
+
+	cache.entries[key] = cachedCommandResponse{
+		response: response,
+		time:     now,
+	}
+}
+
+// commandKey identifies runs of the same command with the same expanded args.
+func commandKey(commandInfo *config.CommandInfo) string {
+	return commandInfo.ID + "\x00" + strings.Join(commandInfo.Args, "\x00")
+}
+
+// commandFlight is a run shared by concurrent requests.  The run is cancelled
+// once every request waiting for it has gone away.
+type commandFlight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// joinFlight joins the flight for key, starting one if needed.  A new flight's context
+// keeps the request ID of ctx but is not cancelled with it.
+func (commandHandler *commandHandler) joinFlight(ctx context.Context, key string, timeout time.Duration) *commandFlight {
+	commandHandler.flightsMutex.Lock()
+	defer commandHandler.flightsMutex.Unlock()
+
+	flight, ok := commandHandler.flights[key]
+	if !ok {
+		flightCtx, cancel := context.WithTimeout(logging.DetachedContext(ctx), timeout)
+		flight = &commandFlight{
+			ctx:    flightCtx,
+			cancel: cancel,
+		}
+		commandHandler.flights[key] = flight
+	}
+	flight.waiters++
+	return flight
+}
+
+// leaveFlight returns true if this was the last waiter, in which case the run is cancelled.
+// The cancelled run is also forgotten by singleflightGroup, so a request arriving before
+// it returns starts a new run instead of sharing the cancelled one's response.
+func (commandHandler *commandHandler) leaveFlight(key string, flight *commandFlight) bool {
+	commandHandler.flightsMutex.Lock()
+	defer commandHandler.flightsMutex.Unlock()
+
+	flight.waiters--
+	if flight.waiters > 0 {
+		return false
+	}
+
+	flight.cancel()
+	if commandHandler.flights[key] == flight {
+		delete(commandHandler.flights, key)
+		commandHandler.singleflightGroup.Forget(key)
+	}
+	return true
+}
+
+func (commandHandler *commandHandler) finishFlight(key string, flight *commandFlight) {
+	commandHandler.flightsMutex.Lock()
+	defer commandHandler.flightsMutex.Unlock()
+
+	if commandHandler.flights[key] == flight {
+		delete(commandHandler.flights, key)
+	}
+}
+
+// runCommandCoalesced runs the command, sharing a single execution between concurrent
+// requests for the same command and args.  If commandInfo.CacheTTLMilliseconds is set,
+// a successful response is reused until it is older than the TTL.
+// The returned age is non-zero only for a cached response.
+// If ctx is done before the command completes ok is false.
+func (commandHandler *commandHandler) runCommandCoalesced(
+	ctx context.Context, commandInfo *config.CommandInfo) (response commandAPIResponse, age time.Duration, ok bool) {
+
+	key := commandKey(commandInfo)
+	cacheTTL := time.Duration(commandInfo.CacheTTLMilliseconds) * time.Millisecond
+
+	if cacheTTL > 0 {
+		if cachedResponse, cachedAge, found := commandHandler.responseCache.get(key, cacheTTL); found {
+			response = *cachedResponse
+			response.Cached = true
+			age = cachedAge
+			ok = true
+			return
+		}
+	}
+
+	flight := commandHandler.joinFlight(ctx, key, commandHandler.commandTimeout(commandInfo))
+
+	resultChannel := commandHandler.singleflightGroup.DoChan(key, func() (interface{}, error) {
+		defer commandHandler.finishFlight(key, flight)
+
+		response := commandHandler.runCommand(flight.ctx, commandInfo)
+		if (cacheTTL > 0) && (response.statusCode == http.StatusOK) {
+			commandHandler.responseCache.put(key, response, cacheTTL)
+		}
+		return response, nil
+	})
+
+	select {
+	case result := <-resultChannel:
+		commandHandler.leaveFlight(key, flight)
+		response = *(result.Val.(*commandAPIResponse))
+		response.Shared = result.Shared
+		ok = true
+
+	case <-ctx.Done():
+		if commandHandler.leaveFlight(key, flight) {
+			recordClientDisconnect(ctx, commandInfo)
+		}
+	}
+	return
+}
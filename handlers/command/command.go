@@ -4,45 +4,220 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
 	"github.com/aaronriekenberg/pi-web/templates"
 	"github.com/aaronriekenberg/pi-web/utils"
 )
 
+// runningCommandsTracker tracks commands started by runCommand so shutdown can wait
+// for them to finish, and cancel them if they take too long.
+type runningCommandsTracker struct {
+	waitGroup     sync.WaitGroup
+	cancelContext context.Context
+	cancel        context.CancelFunc
+}
+
+func newRunningCommandsTracker() *runningCommandsTracker {
+	cancelContext, cancel := context.WithCancel(context.Background())
+	return &runningCommandsTracker{
+		cancelContext: cancelContext,
+		cancel:        cancel,
+	}
+}
+
+// start returns a context derived from ctx that is also cancelled when shutdown gives up waiting.
+// The returned function must be called when the command completes.
+func (tracker *runningCommandsTracker) start(ctx context.Context) (context.Context, func()) {
+	tracker.waitGroup.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-tracker.cancelContext.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancel()
+		tracker.waitGroup.Done()
+	}
+}
+
+func (tracker *runningCommandsTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		tracker.waitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		logging.Warn("cancelling running commands", "error", ctx.Err())
+		tracker.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+var runningCommands = newRunningCommandsTracker()
+
+// WaitForRunningCommands waits for running commands to complete.
+// If ctx is done first the commands are cancelled and waited for.
+func WaitForRunningCommands(ctx context.Context) error {
+	return runningCommands.wait(ctx)
+}
+
+// commandSlotsTracker counts running commands across configurations, so commands
+// started by handlers for a configuration that has since been reloaded still count
+// against the maxConcurrentCommands of the current one.
+type commandSlotsTracker struct {
+	mutex    sync.Mutex
+	inUse    int64
+	released chan struct{}
+}
+
+// acquire waits until fewer than capacity slots are in use and takes one, or returns
+// ctx.Err() if ctx is done first.
+func (tracker *commandSlotsTracker) acquire(ctx context.Context, capacity int64) error {
+	for {
+		tracker.mutex.Lock()
+		if tracker.inUse < capacity {
+			tracker.inUse++
+			tracker.mutex.Unlock()
+			return nil
+		}
+		released := tracker.released
+		tracker.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (tracker *commandSlotsTracker) release() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.inUse--
+	close(tracker.released)
+	tracker.released = make(chan struct{})
+}
+
+var commandSlots = &commandSlotsTracker{
+	released: make(chan struct{}),
+}
+
+// defaultStreamTimeout limits streamed command runs if streamTimeoutMilliseconds is
+// not set.  Streams are for commands that run longer than a request should wait.
+const defaultStreamTimeout = 5 * time.Minute
+
 type commandHandler struct {
-	commandSemaphore        *semaphore.Weighted
+	maxConcurrentCommands   int64
 	requestTimeout          time.Duration
 	semaphoreAcquireTimeout time.Duration
+	streamTimeout           time.Duration
+	maxOutputBytes          int64
+	singleflightGroup       singleflight.Group
+	responseCache           *commandResponseCache
+	flightsMutex            sync.Mutex
+	flights                 map[string]*commandFlight
 }
 
-func CreateCommandHandler(configuration *config.Configuration, serveMux *http.ServeMux) {
-	commandConfiguration := &configuration.CommandConfiguration
+func newCommandHandler(commandConfiguration *config.CommandConfiguration) *commandHandler {
 	commandHandler := &commandHandler{
-		commandSemaphore:        semaphore.NewWeighted(commandConfiguration.MaxConcurrentCommands),
+		maxConcurrentCommands:   commandConfiguration.MaxConcurrentCommands,
 		requestTimeout:          time.Duration(commandConfiguration.RequestTimeoutMilliseconds) * time.Millisecond,
 		semaphoreAcquireTimeout: time.Duration(commandConfiguration.SemaphoreAcquireTimeoutMilliseconds) * time.Millisecond,
+		streamTimeout:           time.Duration(commandConfiguration.StreamTimeoutMilliseconds) * time.Millisecond,
+		maxOutputBytes:          commandConfiguration.MaxOutputBytes,
+		responseCache:           newCommandResponseCache(),
+		flights:                 make(map[string]*commandFlight),
+	}
+
+	if commandHandler.streamTimeout == 0 {
+		commandHandler.streamTimeout = defaultStreamTimeout
+	}
+
+	return commandHandler
+}
+
+// commandTimeout returns commandInfo.RequestTimeoutMilliseconds if set, otherwise
+// the commandConfiguration requestTimeoutMilliseconds.
+func (commandHandler *commandHandler) commandTimeout(commandInfo *config.CommandInfo) time.Duration {
+	if commandInfo.RequestTimeoutMilliseconds > 0 {
+		return time.Duration(commandInfo.RequestTimeoutMilliseconds) * time.Millisecond
+	}
+	return commandHandler.requestTimeout
+}
+
+// commandMaxOutputBytes returns commandInfo.MaxOutputBytes if set, otherwise
+// the commandConfiguration maxOutputBytes.  0 means output is not limited.
+func (commandHandler *commandHandler) commandMaxOutputBytes(commandInfo *config.CommandInfo) int64 {
+	if commandInfo.MaxOutputBytes > 0 {
+		return commandInfo.MaxOutputBytes
+	}
+	return commandHandler.maxOutputBytes
+}
+
+// CreateCommandHandler registers the command handlers on serveMux and returns a Scheduler
+// for the scheduled commands.  The Scheduler is not started.
+func CreateCommandHandler(configuration *config.Configuration, serveMux *http.ServeMux) (*Scheduler, error) {
+	commandConfiguration := &configuration.CommandConfiguration
+	commandHandler := newCommandHandler(commandConfiguration)
+
+	scheduler, err := commandHandler.newScheduler(commandConfiguration)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, commandInfo := range commandConfiguration.Commands {
 		apiPath := "/api/commands/" + commandInfo.ID
 		htmlPath := "/commands/" + commandInfo.ID + ".html"
+		htmlHandlerFunc, err := commandHandler.commandRunnerHTMLHandlerFunc(configuration, commandInfo)
+		if err != nil {
+			return nil, err
+		}
 		serveMux.Handle(
 			htmlPath,
-			commandHandler.commandRunnerHTMLHandlerFunc(configuration, commandInfo))
+			htmlHandlerFunc)
 		serveMux.Handle(
 			apiPath,
 			commandHandler.commandAPIHandlerFunc(commandInfo))
+		serveMux.Handle(
+			apiPath+"/stream",
+			commandHandler.commandStreamHandlerFunc(commandInfo))
+	}
+
+	for _, scheduledCommand := range scheduler.scheduledCommands {
+		serveMux.Handle(
+			"/api/commands/"+scheduledCommand.commandInfo.ID+"/history",
+			commandHistoryAPIHandlerFunc(*scheduledCommand.commandInfo, scheduledCommand.history))
 	}
+
+	return scheduler, nil
 }
 
 type commandHTMLData struct {
@@ -50,7 +225,7 @@ type commandHTMLData struct {
 }
 
 func (commandHandler *commandHandler) commandRunnerHTMLHandlerFunc(
-	configuration *config.Configuration, commandInfo config.CommandInfo) http.HandlerFunc {
+	configuration *config.Configuration, commandInfo config.CommandInfo) (http.HandlerFunc, error) {
 
 	cacheControlValue := configuration.TemplatePageInfo.CacheControlValue
 
@@ -60,7 +235,7 @@ func (commandHandler *commandHandler) commandRunnerHTMLHandlerFunc(
 
 	var builder strings.Builder
 	if err := templates.Templates.ExecuteTemplate(&builder, templates.CommandTemplateFile, commandHTMLData); err != nil {
-		log.Fatalf("Error executing command template ID %v: %v", commandInfo.ID, err)
+		return nil, fmt.Errorf("error executing command template ID %v: %w", commandInfo.ID, err)
 	}
 
 	htmlString := builder.String()
@@ -70,75 +245,225 @@ func (commandHandler *commandHandler) commandRunnerHTMLHandlerFunc(
 		w.Header().Add(utils.CacheControlHeaderKey, cacheControlValue)
 		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeTextHTML)
 		http.ServeContent(w, r, templates.CommandTemplateFile, lastModified, strings.NewReader(htmlString))
-	}
+	}, nil
 }
 
 func (commandHandler *commandHandler) acquireCommandSemaphore(ctx context.Context) (err error) {
 	ctx, cancel := context.WithTimeout(ctx, commandHandler.semaphoreAcquireTimeout)
 	defer cancel()
 
-	err = commandHandler.commandSemaphore.Acquire(ctx, 1)
+	acquireStartTime := time.Now()
+	err = commandSlots.acquire(ctx, commandHandler.maxConcurrentCommands)
+	commandSemaphoreWait.Observe(time.Since(acquireStartTime).Seconds())
 	if err != nil {
 		err = fmt.Errorf("commandHandler.acquireCommandSemaphore error calling Acquire: %w", err)
+		return
 	}
+	commandSemaphoreInUse.Add(1)
 	return
 }
 
 func (commandHandler *commandHandler) releaseCommandSemaphore() {
-	commandHandler.commandSemaphore.Release(1)
+	commandSemaphoreInUse.Add(-1)
+	commandSlots.release()
+}
+
+const (
+	commandErrorParameter        = "parameter"
+	commandErrorSemaphoreAcquire = "semaphoreAcquire"
+	commandErrorStart            = "start"
+	commandErrorExit             = "exit"
+	commandErrorTimeout          = "timeout"
+	commandErrorOutputLimit      = "outputLimit"
+)
+
+// commandErrorCategory returns the category of err from running a command.
+func commandErrorCategory(err error, timedOut bool, outputTruncated bool) string {
+	var exitError *exec.ExitError
+	switch {
+	case timedOut:
+		return commandErrorTimeout
+	case outputTruncated:
+		return commandErrorOutputLimit
+	case errors.As(err, &exitError):
+		return commandErrorExit
+	default:
+		return commandErrorStart
+	}
+}
+
+// commandAPIInfo is the part of a command's configuration returned by the API.
+// The environment and credential the command runs with are left out.
+type commandAPIInfo struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description,omitempty"`
+	Command     string   `json:"command,omitempty"`
+	Args        []string `json:"args,omitempty"`
+}
+
+func newCommandAPIInfo(commandInfo *config.CommandInfo) *commandAPIInfo {
+	return &commandAPIInfo{
+		ID:          commandInfo.ID,
+		Description: commandInfo.Description,
+		Command:     commandInfo.Command,
+		Args:        commandInfo.Args,
+	}
 }
 
 type commandAPIResponse struct {
-	CommandInfo     *config.CommandInfo `json:"commandInfo"`
-	Now             string              `json:"now"`
-	CommandDuration string              `json:"commandDuration"`
-	CommandOutput   string              `json:"commandOutput"`
+	CommandInfo     *commandAPIInfo `json:"commandInfo"`
+	Now             string          `json:"now"`
+	CommandDuration string          `json:"commandDuration"`
+	CommandOutput   string          `json:"commandOutput"`
+	Stdout          string          `json:"stdout"`
+	Stderr          string          `json:"stderr"`
+	ExitCode        int             `json:"exitCode"`
+	Signal          string          `json:"signal,omitempty"`
+	TimedOut        bool            `json:"timedOut"`
+	OutputTruncated bool            `json:"outputTruncated"`
+	OutputBytes     int64           `json:"outputBytes"`
+	ErrorCategory   string          `json:"errorCategory,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Shared          bool            `json:"shared"`
+	Cached          bool            `json:"cached"`
+	statusCode      int
+}
+
+// processSignal returns the name of the signal that terminated the process, or "" if there was none.
+func processSignal(processState *os.ProcessState) string {
+	if processState == nil {
+		return ""
+	}
+	if waitStatus, ok := processState.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+		return waitStatus.Signal().String()
+	}
+	return ""
+}
+
+// expandCommandInfo returns a copy of commandInfo with parameter values substituted into Args.
+func expandCommandInfo(commandInfo *config.CommandInfo, values url.Values) (*config.CommandInfo, error) {
+	args, err := commandInfo.ExpandArgs(values)
+	if err != nil {
+		return nil, err
+	}
+
+	expandedCommandInfo := *commandInfo
+	expandedCommandInfo.Args = args
+	return &expandedCommandInfo, nil
+}
+
+func newParameterErrorResponse(commandInfo *config.CommandInfo, err error) *commandAPIResponse {
+	return &commandAPIResponse{
+		CommandInfo:   newCommandAPIInfo(commandInfo),
+		Now:           utils.FormatTime(time.Now()),
+		CommandOutput: fmt.Sprintf("%v", err),
+		ExitCode:      -1,
+		ErrorCategory: commandErrorParameter,
+		Error:         err.Error(),
+		statusCode:    http.StatusBadRequest,
+	}
 }
 
 func (commandHandler *commandHandler) runCommand(ctx context.Context, commandInfo *config.CommandInfo) (response *commandAPIResponse) {
 	err := commandHandler.acquireCommandSemaphore(ctx)
 	if err != nil {
 		response = &commandAPIResponse{
-			CommandInfo:   commandInfo,
+			CommandInfo:   newCommandAPIInfo(commandInfo),
 			Now:           utils.FormatTime(time.Now()),
 			CommandOutput: fmt.Sprintf("%v", err),
+			ExitCode:      -1,
+			ErrorCategory: commandErrorSemaphoreAcquire,
+			Error:         err.Error(),
+			statusCode:    http.StatusServiceUnavailable,
 		}
+		recordCommandRun(ctx, commandInfo.ID, response.ErrorCategory, 0)
 		return
 	}
 	defer commandHandler.releaseCommandSemaphore()
 
+	ctx, commandDone := runningCommands.start(ctx)
+	defer commandDone()
+
+	// The command is killed once its output goes over the limit.
+	ctx, stopCommand := context.WithCancel(ctx)
+	defer stopCommand()
+
+	var stdoutBuffer, stderrBuffer, combinedBuffer bytes.Buffer
+	outputLimiter := newOutputLimiter(commandHandler.commandMaxOutputBytes(commandInfo), stopCommand)
+
 	commandStartTime := time.Now()
-	rawCommandOutput, err := exec.CommandContext(
-		ctx, commandInfo.Command, commandInfo.Args...).CombinedOutput()
+	var processState *os.ProcessState
+	cmd, err := newCommand(ctx, commandInfo)
+	if err == nil {
+		cmd.Stdout = outputLimiter.writer(io.MultiWriter(&stdoutBuffer, &combinedBuffer))
+		cmd.Stderr = outputLimiter.writer(io.MultiWriter(&stderrBuffer, &combinedBuffer))
+		err = cmd.Run()
+		processState = cmd.ProcessState
+	}
 	commandEndTime := time.Now()
 
-	var commandOutput string
-	if err != nil {
-		commandOutput = fmt.Sprintf("command error %v", err)
-	} else {
-		commandOutput = string(rawCommandOutput)
+	response = &commandAPIResponse{
+		CommandInfo: newCommandAPIInfo(commandInfo),
+		Now:         utils.FormatTime(commandEndTime),
+		CommandDuration: fmt.Sprintf("%.9f sec",
+			commandEndTime.Sub(commandStartTime).Seconds()),
+		Stdout:     stdoutBuffer.String(),
+		Stderr:     stderrBuffer.String(),
+		ExitCode:   processState.ExitCode(),
+		Signal:     processSignal(processState),
+		TimedOut:   errors.Is(ctx.Err(), context.DeadlineExceeded),
+		statusCode: http.StatusOK,
 	}
 
-	commandDuration := fmt.Sprintf("%.9f sec",
-		commandEndTime.Sub(commandStartTime).Seconds())
+	response.OutputTruncated, response.OutputBytes = outputLimiter.result()
 
-	response = &commandAPIResponse{
-		CommandInfo:     commandInfo,
-		Now:             utils.FormatTime(commandEndTime),
-		CommandDuration: commandDuration,
-		CommandOutput:   commandOutput,
+	commandOutput := combinedBuffer.String()
+
+	if response.OutputTruncated {
+		if (len(commandOutput) > 0) && !strings.HasSuffix(commandOutput, "\n") {
+			commandOutput += "\n"
+		}
+		commandOutput += fmt.Sprintf("output truncated to %v of %v bytes\n", combinedBuffer.Len(), response.OutputBytes)
+	}
+
+	if err != nil {
+		response.ErrorCategory = commandErrorCategory(err, response.TimedOut, response.OutputTruncated)
+		switch response.ErrorCategory {
+		case commandErrorTimeout:
+			response.statusCode = http.StatusGatewayTimeout
+		case commandErrorStart:
+			response.statusCode = http.StatusInternalServerError
+		}
+		response.Error = err.Error()
+
+		if (len(commandOutput) > 0) && !strings.HasSuffix(commandOutput, "\n") {
+			commandOutput += "\n"
+		}
+		commandOutput += fmt.Sprintf("command error %v", err)
 	}
+
+	response.CommandOutput = commandOutput
+	recordCommandRun(ctx, commandInfo.ID, response.ErrorCategory, commandEndTime.Sub(commandStartTime))
 	return
 }
 
 func (commandHandler *commandHandler) commandAPIHandlerFunc(commandInfo config.CommandInfo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(context.Background(), commandHandler.requestTimeout)
-		defer cancel()
-
-		commandAPIResponse := commandHandler.runCommand(ctx, &commandInfo)
+		var commandAPIResponse *commandAPIResponse
+		var age time.Duration
+		expandedCommandInfo, err := expandCommandInfo(&commandInfo, r.URL.Query())
+		if err != nil {
+			commandAPIResponse = newParameterErrorResponse(&commandInfo, err)
+		} else {
+			response, responseAge, ok := commandHandler.runCommandCoalesced(r.Context(), expandedCommandInfo)
+			if !ok {
+				// The client has gone away, there is no one to respond to.
+				return
+			}
+			commandAPIResponse = &response
+			age = responseAge
+		}
 
-		var jsonText []byte
 		jsonText, err := json.Marshal(commandAPIResponse)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -147,6 +472,37 @@ func (commandHandler *commandHandler) commandAPIHandlerFunc(commandInfo config.C
 
 		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeApplicationJSON)
 		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+		if commandAPIResponse.Cached {
+			w.Header().Add(utils.AgeHeaderKey, strconv.Itoa(int(age.Seconds())))
+		}
+		w.WriteHeader(commandAPIResponse.statusCode)
 		io.Copy(w, bytes.NewReader(jsonText))
 	}
 }
+
+// RunCommand runs the command with ID commandID and parameter values the same way
+// the command API does and returns the JSON API response.
+func RunCommand(configuration *config.Configuration, commandID string, values url.Values) ([]byte, error) {
+	commandConfiguration := &configuration.CommandConfiguration
+
+	for i := range commandConfiguration.Commands {
+		commandInfo := &commandConfiguration.Commands[i]
+		if commandInfo.ID != commandID {
+			continue
+		}
+
+		commandHandler := newCommandHandler(commandConfiguration)
+
+		ctx, cancel := context.WithTimeout(context.Background(), commandHandler.commandTimeout(commandInfo))
+		defer cancel()
+
+		expandedCommandInfo, err := expandCommandInfo(commandInfo, values)
+		if err != nil {
+			return json.Marshal(newParameterErrorResponse(commandInfo, err))
+		}
+
+		return json.Marshal(commandHandler.runCommand(ctx, expandedCommandInfo))
+	}
+
+	return nil, fmt.Errorf("command ID %q not found", commandID)
+}
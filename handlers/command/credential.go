@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/aaronriekenberg/pi-web/config"
+)
+
+// setCredential runs cmd as the user and groups of credentialInfo.
+func setCredential(cmd *exec.Cmd, credentialInfo *config.CommandCredentialInfo) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid:    credentialInfo.UID,
+			Gid:    credentialInfo.GID,
+			Groups: credentialInfo.Groups,
+		},
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package command
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/aaronriekenberg/pi-web/config"
+)
+
+// setCredential fails, Windows has no uid and gid to run as.  Configuration validation
+// rejects credentials on Windows.
+func setCredential(cmd *exec.Cmd, credentialInfo *config.CommandCredentialInfo) error {
+	return errors.New("command credential not supported on windows")
+}
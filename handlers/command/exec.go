@@ -0,0 +1,130 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/aaronriekenberg/pi-web/config"
+)
+
+// ResourceLimitsHelperSubcommand is the pi-web subcommand used to run commands with
+// resource limits.  Go cannot set rlimits between fork and exec, so pi-web runs itself
+// with this subcommand, which sets the limits and then execs the command.
+const ResourceLimitsHelperSubcommand = "exec-with-resource-limits"
+
+// resourceLimit is a limit set by the resource limits helper, 0 is not set.
+type resourceLimit struct {
+	name     string
+	resource int
+	limit    uint64
+}
+
+// resourceLimitsHelperExitCode is the exit code of the helper if it cannot exec the command.
+const resourceLimitsHelperExitCode = 127
+
+// commandEnvironment returns the environment for a command with environmentInfo set.
+func commandEnvironment(environmentInfo *config.CommandEnvironmentInfo) []string {
+	env := make([]string, 0, len(environmentInfo.Inherit)+len(environmentInfo.Variables))
+	for _, name := range environmentInfo.Inherit {
+		if _, overridden := environmentInfo.Variables[name]; overridden {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	for name, value := range environmentInfo.Variables {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// newCommand returns an exec.Cmd that runs commandInfo in its configured working directory,
+// with its configured environment, credential, and resource limits.
+func newCommand(ctx context.Context, commandInfo *config.CommandInfo) (*exec.Cmd, error) {
+	// Look up the command with pi-web's PATH, the command's environment may not have one.
+	commandPath, err := exec.LookPath(commandInfo.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	if resourceLimitsInfo := commandInfo.ResourceLimits; resourceLimitsInfo != nil {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("error finding pi-web executable for resource limits: %w", err)
+		}
+
+		helperArgs := []string{
+			ResourceLimitsHelperSubcommand,
+			strconv.FormatUint(resourceLimitsInfo.CPUSeconds, 10),
+			strconv.FormatUint(resourceLimitsInfo.AddressSpaceBytes, 10),
+			strconv.FormatUint(resourceLimitsInfo.OpenFiles, 10),
+			strconv.FormatUint(resourceLimitsInfo.Processes, 10),
+			commandPath,
+		}
+		helperArgs = append(helperArgs, commandInfo.Args...)
+
+		cmd = exec.CommandContext(ctx, executable, helperArgs...)
+	} else {
+		cmd = exec.CommandContext(ctx, commandPath, commandInfo.Args...)
+	}
+
+	cmd.Dir = commandInfo.WorkingDirectory
+
+	if commandInfo.Environment != nil {
+		cmd.Env = commandEnvironment(commandInfo.Environment)
+	}
+
+	if credentialInfo := commandInfo.Credential; credentialInfo != nil {
+		if err := setCredential(cmd, credentialInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	return cmd, nil
+}
+
+func resourceLimitsHelperFatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, ResourceLimitsHelperSubcommand+": "+format+"\n", args...)
+	os.Exit(resourceLimitsHelperExitCode)
+}
+
+// RunResourceLimitsHelper runs the ResourceLimitsHelperSubcommand with args
+// <cpu seconds> <address space bytes> <open files> <processes> <command path> [<arg> ...]
+// Limits of 0 are not set.  It does not return.
+func RunResourceLimitsHelper(args []string) {
+	if len(args) < 5 {
+		resourceLimitsHelperFatal("expected at least 5 args, got %v", len(args))
+	}
+
+	// Address space is set last so the limit applies to as little of the helper as possible.
+	limits := []resourceLimit{
+		{name: "cpu seconds", resource: rlimitCPU},
+		{name: "open files", resource: rlimitNofile},
+		// RLIMIT_NPROC counts every process and thread of the uid, not just the command's.
+		{name: "processes", resource: rlimitNproc},
+		{name: "address space bytes", resource: rlimitAS},
+	}
+	limitArgs := []string{args[0], args[2], args[3], args[1]}
+
+	for i := range limits {
+		limit, err := strconv.ParseUint(limitArgs[i], 10, 64)
+		if err != nil {
+			resourceLimitsHelperFatal("invalid %v %q", limits[i].name, limitArgs[i])
+		}
+		limits[i].limit = limit
+	}
+
+	if err := setResourceLimits(limits); err != nil {
+		resourceLimitsHelperFatal("%v", err)
+	}
+
+	commandPath := args[4]
+	err := syscall.Exec(commandPath, args[4:], os.Environ())
+	resourceLimitsHelperFatal("error executing %v: %v", commandPath, err)
+}
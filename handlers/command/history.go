@@ -0,0 +1,335 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/cron"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+// commandHistory is a ring buffer of the most recent scheduled run responses for a command.
+type commandHistory struct {
+	mutex       sync.Mutex
+	responses   []*commandAPIResponse
+	next        int
+	count       int
+	persistFile string
+}
+
+func newCommandHistory(size int) *commandHistory {
+	return &commandHistory{
+		responses: make([]*commandAPIResponse, size),
+	}
+}
+
+// snapshotLocked returns the responses oldest first.  Caller must hold mutex.
+func (history *commandHistory) snapshotLocked() []*commandAPIResponse {
+	size := len(history.responses)
+	snapshot := make([]*commandAPIResponse, 0, history.count)
+	for i := 0; i < history.count; i++ {
+		snapshot = append(snapshot, history.responses[(history.next-history.count+i+size)%size])
+	}
+	return snapshot
+}
+
+func (history *commandHistory) snapshot() []*commandAPIResponse {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	return history.snapshotLocked()
+}
+
+// addLocked adds a copy of response identifying the command by ID only.  The history API
+// response includes the rest of the command once, and persisted histories do not need it.
+func (history *commandHistory) addLocked(response *commandAPIResponse) {
+	historyResponse := *response
+	if response.CommandInfo != nil {
+		historyResponse.CommandInfo = &commandAPIInfo{
+			ID: response.CommandInfo.ID,
+		}
+	}
+
+	history.responses[history.next] = &historyResponse
+	history.next = (history.next + 1) % len(history.responses)
+	if history.count < len(history.responses) {
+		history.count++
+	}
+}
+
+// add adds response, replacing the oldest response if the history is full, and saves the
+// history to persistFile if set.
+func (history *commandHistory) add(response *commandAPIResponse) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	history.addLocked(response)
+
+	if len(history.persistFile) > 0 {
+		if err := history.saveLocked(); err != nil {
+			logging.Error("error saving command history", "file", history.persistFile, "error", err)
+		}
+	}
+}
+
+// configure resizes the history, keeping the newest responses, and sets persistFile.
+// If persistFile is newly set, responses previously saved to it are loaded.
+func (history *commandHistory) configure(size int, persistFile string) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	snapshot := history.snapshotLocked()
+	loadFromFile := (len(persistFile) > 0) && (persistFile != history.persistFile)
+
+	history.responses = make([]*commandAPIResponse, size)
+	history.next = 0
+	history.count = 0
+	history.persistFile = persistFile
+
+	if loadFromFile && (len(snapshot) == 0) {
+		if loaded, err := history.loadLocked(); err != nil {
+			logging.Error("error loading command history", "file", persistFile, "error", err)
+		} else {
+			snapshot = loaded
+		}
+	}
+
+	for _, response := range snapshot {
+		history.addLocked(response)
+	}
+}
+
+func (history *commandHistory) loadLocked() ([]*commandAPIResponse, error) {
+	jsonText, err := os.ReadFile(history.persistFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []*commandAPIResponse
+	if err := json.Unmarshal(jsonText, &responses); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+// saveLocked writes the history to a temporary file and renames it over persistFile.
+func (history *commandHistory) saveLocked() error {
+	jsonText, err := json.Marshal(history.snapshotLocked())
+	if err != nil {
+		return err
+	}
+
+	tempFile := history.persistFile + ".tmp"
+	if err := os.WriteFile(tempFile, jsonText, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, history.persistFile)
+}
+
+// commandHistories holds the history for each scheduled command ID.
+// Histories are kept across configuration reloads.
+type commandHistories struct {
+	mutex     sync.Mutex
+	histories map[string]*commandHistory
+}
+
+var histories = &commandHistories{
+	histories: make(map[string]*commandHistory),
+}
+
+// get returns the history kept for commandInfo, or a new history if there is none.
+// A new history is not kept, and neither is resized, until adopt is called.
+func (commandHistories *commandHistories) get(commandInfo *config.CommandInfo) *commandHistory {
+	commandHistories.mutex.Lock()
+	defer commandHistories.mutex.Unlock()
+
+	if history, ok := commandHistories.histories[commandInfo.ID]; ok {
+		return history
+	}
+	return newCommandHistory(commandInfo.Schedule.HistorySize)
+}
+
+// adopt keeps and configures the histories of scheduledCommands.
+func (commandHistories *commandHistories) adopt(
+	commandConfiguration *config.CommandConfiguration, scheduledCommands []scheduledCommand) {
+
+	commandHistories.mutex.Lock()
+	defer commandHistories.mutex.Unlock()
+
+	for _, scheduledCommand := range scheduledCommands {
+		commandInfo := scheduledCommand.commandInfo
+		scheduleInfo := commandInfo.Schedule
+
+		var persistFile string
+		if scheduleInfo.PersistHistory {
+			persistFile = filepath.Join(commandConfiguration.HistoryDirectory, commandInfo.ID+"-history.json")
+		}
+
+		commandHistories.histories[commandInfo.ID] = scheduledCommand.history
+		scheduledCommand.history.configure(scheduleInfo.HistorySize, persistFile)
+	}
+}
+
+type scheduledCommand struct {
+	commandInfo *config.CommandInfo
+	history     *commandHistory
+}
+
+// Scheduler runs scheduled commands in the background through the command handler's semaphore.
+type Scheduler struct {
+	commandHandler       *commandHandler
+	commandConfiguration *config.CommandConfiguration
+	scheduledCommands    []scheduledCommand
+	cancel               context.CancelFunc
+}
+
+// Adopt makes the command configuration of scheduler current, replacing state kept
+// across configurations such as histories.  It is called before Start, once the
+// handlers created with scheduler are to serve requests.
+func (scheduler *Scheduler) Adopt() {
+	commandSemaphoreCapacity.Set(float64(scheduler.commandHandler.maxConcurrentCommands))
+	histories.adopt(scheduler.commandConfiguration, scheduler.scheduledCommands)
+}
+
+// Start starts running the scheduled commands.
+func (scheduler *Scheduler) Start() {
+	if len(scheduler.scheduledCommands) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.cancel = cancel
+
+	for _, scheduledCommand := range scheduler.scheduledCommands {
+		go scheduler.runSchedule(ctx, scheduledCommand)
+	}
+}
+
+// Stop stops scheduling new runs.  Runs already started are allowed to complete.
+func (scheduler *Scheduler) Stop() {
+	if scheduler.cancel != nil {
+		scheduler.cancel()
+	}
+}
+
+// nextRunTime returns when the command should next run after now.
+func nextRunTime(scheduleInfo *config.CommandScheduleInfo, cronSchedule *cron.Schedule, now time.Time) time.Time {
+	if cronSchedule != nil {
+		return cronSchedule.Next(now)
+	}
+	return now.Add(time.Duration(scheduleInfo.IntervalMilliseconds) * time.Millisecond)
+}
+
+func (scheduler *Scheduler) runSchedule(ctx context.Context, scheduledCommand scheduledCommand) {
+	commandInfo := scheduledCommand.commandInfo
+	scheduleInfo := commandInfo.Schedule
+
+	var cronSchedule *cron.Schedule
+	if len(scheduleInfo.Cron) > 0 {
+		var err error
+		if cronSchedule, err = cron.Parse(scheduleInfo.Cron); err != nil {
+			logging.Error("invalid cron expression", "commandID", commandInfo.ID, "error", err)
+			return
+		}
+	}
+
+	// Interval schedules run immediately, cron schedules wait for the first matching time.
+	nextRun := time.Now()
+	if cronSchedule != nil {
+		nextRun = nextRunTime(scheduleInfo, cronSchedule, nextRun)
+	}
+
+	for {
+		if nextRun.IsZero() {
+			logging.Warn("no next run time, stopping schedule", "commandID", commandInfo.ID)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(nextRun))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		scheduler.runScheduledCommand(scheduledCommand)
+
+		nextRun = nextRunTime(scheduleInfo, cronSchedule, time.Now())
+	}
+}
+
+func (scheduler *Scheduler) runScheduledCommand(scheduledCommand scheduledCommand) {
+	commandHandler := scheduler.commandHandler
+	commandInfo := scheduledCommand.commandInfo
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandHandler.commandTimeout(commandInfo))
+	defer cancel()
+
+	scheduledCommand.history.add(commandHandler.runCommand(ctx, commandInfo))
+}
+
+func (commandHandler *commandHandler) newScheduler(commandConfiguration *config.CommandConfiguration) (*Scheduler, error) {
+	scheduler := &Scheduler{
+		commandHandler:       commandHandler,
+		commandConfiguration: commandConfiguration,
+	}
+
+	for i := range commandConfiguration.Commands {
+		commandInfo := &commandConfiguration.Commands[i]
+		if commandInfo.Schedule == nil {
+			continue
+		}
+
+		// Scheduled runs use parameter defaults.
+		expandedCommandInfo, err := expandCommandInfo(commandInfo, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		scheduler.scheduledCommands = append(scheduler.scheduledCommands, scheduledCommand{
+			commandInfo: expandedCommandInfo,
+			history:     histories.get(commandInfo),
+		})
+	}
+
+	return scheduler, nil
+}
+
+type commandHistoryAPIResponse struct {
+	CommandInfo *commandAPIInfo       `json:"commandInfo"`
+	Now         string                `json:"now"`
+	History     []*commandAPIResponse `json:"history"`
+}
+
+func commandHistoryAPIHandlerFunc(commandInfo config.CommandInfo, history *commandHistory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		commandHistoryAPIResponse := &commandHistoryAPIResponse{
+			CommandInfo: newCommandAPIInfo(&commandInfo),
+			Now:         utils.FormatTime(time.Now()),
+			History:     history.snapshot(),
+		}
+
+		jsonText, err := json.Marshal(commandHistoryAPIResponse)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeApplicationJSON)
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+		io.Copy(w, bytes.NewReader(jsonText))
+	}
+}
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"io"
+	"sync"
+)
+
+// outputLimiter counts the output of a command and keeps at most maxBytes of it.
+// It also serializes writes from the stdout and stderr copying goroutines.
+type outputLimiter struct {
+	mutex      sync.Mutex
+	maxBytes   int64
+	totalBytes int64
+	keptBytes  int64
+	exceeded   func()
+}
+
+// newOutputLimiter returns an outputLimiter that calls exceeded the first time
+// output goes over maxBytes.  If maxBytes is 0 all output is kept.
+func newOutputLimiter(maxBytes int64, exceeded func()) *outputLimiter {
+	return &outputLimiter{
+		maxBytes: maxBytes,
+		exceeded: exceeded,
+	}
+}
+
+// addLocked records n more bytes of output and returns how many of them to keep.
+// Caller must hold mutex.
+func (limiter *outputLimiter) addLocked(n int) int {
+	wasTruncated := limiter.truncatedLocked()
+	limiter.totalBytes += int64(n)
+
+	keep := int64(n)
+	if limiter.maxBytes > 0 {
+		if remaining := limiter.maxBytes - limiter.keptBytes; keep > remaining {
+			keep = remaining
+		}
+	}
+	limiter.keptBytes += keep
+
+	if !wasTruncated && limiter.truncatedLocked() && (limiter.exceeded != nil) {
+		limiter.exceeded()
+	}
+	return int(keep)
+}
+
+func (limiter *outputLimiter) add(n int) int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return limiter.addLocked(n)
+}
+
+func (limiter *outputLimiter) truncatedLocked() bool {
+	return limiter.totalBytes > limiter.keptBytes
+}
+
+// result returns whether output was truncated and the total number of bytes of output seen.
+func (limiter *outputLimiter) result() (truncated bool, totalBytes int64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return limiter.truncatedLocked(), limiter.totalBytes
+}
+
+// writer returns an io.Writer that writes the kept part of its output to writer.
+func (limiter *outputLimiter) writer(writer io.Writer) io.Writer {
+	return &limitedWriter{
+		limiter: limiter,
+		writer:  writer,
+	}
+}
+
+type limitedWriter struct {
+	limiter *outputLimiter
+	writer  io.Writer
+}
+
+// Write always reports all of p written so the command is not blocked on a full pipe
+// before it is killed.
+func (limitedWriter *limitedWriter) Write(p []byte) (int, error) {
+	limitedWriter.limiter.mutex.Lock()
+	defer limitedWriter.limiter.mutex.Unlock()
+
+	if keep := limitedWriter.limiter.addLocked(len(p)); keep > 0 {
+		if _, err := limitedWriter.writer.Write(p[:keep]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,69 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/metrics"
+)
+
+// commandOutcomeSuccess is the outcome of runs without an error category.
+const commandOutcomeSuccess = "success"
+
+var (
+	commandRuns = metrics.NewCounter(
+		"pi_web_command_runs_total",
+		"Command runs by command ID and outcome, which is success or the error category.",
+		"id", "outcome")
+
+	commandRunDuration = metrics.NewHistogram(
+		"pi_web_command_run_duration_seconds",
+		"Command run duration by command ID in seconds.",
+		metrics.DefaultBuckets,
+		"id")
+
+	commandClientDisconnects = metrics.NewCounter(
+		"pi_web_command_client_disconnects_total",
+		"Command runs cancelled because the client went away by command ID.",
+		"id")
+
+	commandSemaphoreWait = metrics.NewHistogram(
+		"pi_web_command_semaphore_wait_seconds",
+		"Time spent waiting to acquire the command semaphore in seconds.",
+		metrics.DefaultBuckets)
+
+	commandSemaphoreInUse = metrics.NewGauge(
+		"pi_web_command_semaphore_in_use",
+		"Number of commands holding the command semaphore.")
+
+	commandSemaphoreCapacity = metrics.NewGauge(
+		"pi_web_command_semaphore_capacity",
+		"Maximum number of concurrent commands.")
+)
+
+// recordCommandRun counts and logs a run of commandID.  errorCategory is empty if the
+// run succeeded.  Runs that never started, such as when the semaphore was not acquired,
+// have no duration.
+func recordCommandRun(ctx context.Context, commandID string, errorCategory string, duration time.Duration) {
+	outcome := errorCategory
+	if len(outcome) == 0 {
+		outcome = commandOutcomeSuccess
+	}
+	commandRuns.Inc(commandID, outcome)
+
+	logging.FromContext(ctx).Info("command run",
+		"commandID", commandID, "outcome", outcome, "duration", duration.Seconds())
+
+	if errorCategory != commandErrorSemaphoreAcquire {
+		commandRunDuration.Observe(duration.Seconds(), commandID)
+	}
+}
+
+// recordClientDisconnect counts and logs a command run cancelled because the client went away.
+func recordClientDisconnect(ctx context.Context, commandInfo *config.CommandInfo) {
+	commandClientDisconnects.Inc(commandInfo.ID)
+
+	logging.FromContext(ctx).Info("command cancelled by client disconnect", "commandID", commandInfo.ID)
+}
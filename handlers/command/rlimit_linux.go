@@ -0,0 +1,27 @@
+package command
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	rlimitCPU    = unix.RLIMIT_CPU
+	rlimitNofile = unix.RLIMIT_NOFILE
+	rlimitNproc  = unix.RLIMIT_NPROC
+	rlimitAS     = unix.RLIMIT_AS
+)
+
+// setResourceLimits sets the soft and hard limits of the current process, in order.
+func setResourceLimits(limits []resourceLimit) error {
+	for _, limit := range limits {
+		if limit.limit == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: limit.limit, Max: limit.limit}); err != nil {
+			return fmt.Errorf("error setting %v limit: %w", limit.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package command
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Resource limits are only set on Linux, configuration validation rejects them elsewhere.
+const (
+	rlimitCPU = iota
+	rlimitNofile
+	rlimitNproc
+	rlimitAS
+)
+
+func setResourceLimits(limits []resourceLimit) error {
+	for _, limit := range limits {
+		if limit.limit != 0 {
+			return fmt.Errorf("%v limit not supported on %v", limit.name, runtime.GOOS)
+		}
+	}
+	return nil
+}
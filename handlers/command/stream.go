@@ -0,0 +1,243 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+const (
+	streamEventStdout = "stdout"
+	streamEventStderr = "stderr"
+	streamEventExit   = "exit"
+
+	maxStreamLineBytes = 64 * 1024
+)
+
+type commandStreamEvent struct {
+	event string
+	data  string
+}
+
+type commandStreamExitEvent struct {
+	Now             string `json:"now"`
+	CommandDuration string `json:"commandDuration"`
+	ExitStatus      int    `json:"exitStatus"`
+	Signal          string `json:"signal,omitempty"`
+	TimedOut        bool   `json:"timedOut"`
+	OutputTruncated bool   `json:"outputTruncated"`
+	OutputBytes     int64  `json:"outputBytes"`
+	Error           string `json:"error,omitempty"`
+}
+
+func writeStreamEvent(w io.Writer, streamEvent commandStreamEvent) error {
+	// A carriage return would end the data field early.
+	data := strings.ReplaceAll(streamEvent.data, "\r", "")
+	_, err := fmt.Fprintf(w, "event: %v\ndata: %v\n\n", streamEvent.event, data)
+	return err
+}
+
+// splitLines returns a bufio.SplitFunc that splits lines like bufio.ScanLines, except
+// that a line longer than maxLineBytes is split into pieces of maxLineBytes instead of
+// ending the scan with bufio.ErrTooLong.  *partial is set when the token returned is
+// a piece of a line that continues in the next token.
+func splitLines(maxLineBytes int, partial *bool) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = bufio.ScanLines(data, atEOF)
+		*partial = false
+		if (advance == 0) && (err == nil) && (len(data) > maxLineBytes) {
+			*partial = true
+			return maxLineBytes, data[:maxLineBytes], nil
+		}
+		return
+	}
+}
+
+// scanLines sends each line read from reader to events until reader returns EOF or an error.
+// Lines longer than maxStreamLineBytes are sent in pieces.  Lines are counted by
+// outputLimiter, and lines over its limit are not sent.
+func scanLines(
+	reader io.Reader, event string, outputLimiter *outputLimiter,
+	events chan<- commandStreamEvent, waitGroup *sync.WaitGroup) {
+
+	defer waitGroup.Done()
+
+	var partial bool
+	scanner := bufio.NewScanner(reader)
+	// Room for the newline after a line of maxStreamLineBytes.
+	scanner.Buffer(make([]byte, 0, 4096), maxStreamLineBytes+1)
+	scanner.Split(splitLines(maxStreamLineBytes, &partial))
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineBytes := len(line)
+		if !partial {
+			// Count the newline removed by the scanner.
+			lineBytes++
+		}
+		keep := outputLimiter.add(lineBytes)
+		if keep == 0 {
+			continue
+		}
+		if keep <= len(line) {
+			line = line[:keep]
+		}
+		events <- commandStreamEvent{
+			event: event,
+			data:  line,
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		events <- commandStreamEvent{
+			event: event,
+			data:  fmt.Sprintf("error reading %v: %v", event, err),
+		}
+		// Drain the pipe so the command is not blocked writing to it.
+		io.Copy(io.Discard, reader)
+	}
+}
+
+// streamCommand starts the command and sends its output to events, one event per line,
+// ending with an exit event.  events is closed when the command has completed.
+// The command is killed once its output goes over maxOutputBytes, if set.
+func streamCommand(
+	ctx context.Context, commandInfo *config.CommandInfo, maxOutputBytes int64,
+	events chan<- commandStreamEvent) {
+
+	defer close(events)
+
+	ctx, stopCommand := context.WithCancel(ctx)
+	defer stopCommand()
+
+	outputLimiter := newOutputLimiter(maxOutputBytes, stopCommand)
+
+	commandStartTime := time.Now()
+
+	sendExitEvent := func(processState *os.ProcessState, err error) {
+		commandEndTime := time.Now()
+		exitEvent := &commandStreamExitEvent{
+			Now:             utils.FormatTime(commandEndTime),
+			CommandDuration: fmt.Sprintf("%.9f sec", commandEndTime.Sub(commandStartTime).Seconds()),
+			ExitStatus:      processState.ExitCode(),
+			Signal:          processSignal(processState),
+			TimedOut:        errors.Is(ctx.Err(), context.DeadlineExceeded),
+		}
+		exitEvent.OutputTruncated, exitEvent.OutputBytes = outputLimiter.result()
+		errorCategory := ""
+		if err != nil {
+			exitEvent.Error = err.Error()
+			errorCategory = commandErrorCategory(err, exitEvent.TimedOut, exitEvent.OutputTruncated)
+		}
+		recordCommandRun(ctx, commandInfo.ID, errorCategory, commandEndTime.Sub(commandStartTime))
+
+		jsonText, jsonErr := json.Marshal(exitEvent)
+		if jsonErr != nil {
+			logging.FromContext(ctx).Error("streamCommand error marshaling exit event", "error", jsonErr)
+			return
+		}
+
+		events <- commandStreamEvent{
+			event: streamEventExit,
+			data:  string(jsonText),
+		}
+	}
+
+	cmd, err := newCommand(ctx, commandInfo)
+	if err != nil {
+		sendExitEvent(nil, err)
+		return
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		sendExitEvent(nil, err)
+		return
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		sendExitEvent(nil, err)
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		sendExitEvent(nil, err)
+		return
+	}
+
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(2)
+	go scanLines(stdout, streamEventStdout, outputLimiter, events, &waitGroup)
+	go scanLines(stderr, streamEventStderr, outputLimiter, events, &waitGroup)
+
+	// All reads must complete before calling Wait.
+	waitGroup.Wait()
+
+	err = cmd.Wait()
+	sendExitEvent(cmd.ProcessState, err)
+}
+
+func (commandHandler *commandHandler) commandStreamHandlerFunc(commandInfo config.CommandInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		expandedCommandInfo, err := expandCommandInfo(&commandInfo, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), commandHandler.streamTimeout)
+		defer cancel()
+
+		if err := commandHandler.acquireCommandSemaphore(ctx); err != nil {
+			recordCommandRun(ctx, expandedCommandInfo.ID, commandErrorSemaphoreAcquire, 0)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer commandHandler.releaseCommandSemaphore()
+
+		ctx, commandDone := runningCommands.start(ctx)
+		defer commandDone()
+
+		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeTextEventStream)
+		w.Header().Add(utils.CacheControlHeaderKey, utils.NoCache)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		events := make(chan commandStreamEvent)
+		go streamCommand(ctx, expandedCommandInfo, commandHandler.commandMaxOutputBytes(expandedCommandInfo), events)
+
+		for streamEvent := range events {
+			if err := writeStreamEvent(w, streamEvent); err != nil {
+				// The client has gone away, cancel the command and discard remaining output.
+				cancel()
+				for range events {
+				}
+				recordClientDisconnect(r.Context(), expandedCommandInfo)
+				return
+			}
+			flusher.Flush()
+		}
+
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			recordClientDisconnect(r.Context(), expandedCommandInfo)
+		}
+	}
+}
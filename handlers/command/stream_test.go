@@ -0,0 +1,71 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// scanTestLines returns the events scanLines sends for input, and the output bytes counted.
+func scanTestLines(input string, maxOutputBytes int64) ([]string, int64) {
+	events := make(chan commandStreamEvent, 16)
+	outputLimiter := newOutputLimiter(maxOutputBytes, nil)
+
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(1)
+	scanLines(strings.NewReader(input), streamEventStdout, outputLimiter, events, &waitGroup)
+	close(events)
+
+	var lines []string
+	for event := range events {
+		lines = append(lines, event.data)
+	}
+	_, totalBytes := outputLimiter.result()
+	return lines, totalBytes
+}
+
+func TestScanLines(t *testing.T) {
+	input := "first\n\nlast"
+	lines, totalBytes := scanTestLines(input, 0)
+
+	if want := []string{"first", "", "last"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	// The missing final newline is counted, as with every line.
+	if want := int64(len(input) + 1); totalBytes != want {
+		t.Errorf("totalBytes = %v, want %v", totalBytes, want)
+	}
+}
+
+func TestScanLinesLongLine(t *testing.T) {
+	long := strings.Repeat("a", maxStreamLineBytes) + strings.Repeat("b", 10)
+	exact := strings.Repeat("c", maxStreamLineBytes)
+	input := long + "\n" + exact + "\nnext\n"
+
+	lines, totalBytes := scanTestLines(input, 0)
+
+	want := []string{strings.Repeat("a", maxStreamLineBytes), strings.Repeat("b", 10), exact, "next"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %v lines, want %v", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %v = %.20q... length %v, want %.20q... length %v", i, lines[i], len(lines[i]), want[i], len(want[i]))
+		}
+	}
+	if want := int64(len(input)); totalBytes != want {
+		t.Errorf("totalBytes = %v, want %v", totalBytes, want)
+	}
+}
+
+func TestScanLinesOutputLimit(t *testing.T) {
+	lines, totalBytes := scanTestLines("12345\n67890\nabc\n", 8)
+
+	if want := []string{"12345", "67"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if totalBytes != 16 {
+		t.Errorf("totalBytes = %v, want 16", totalBytes)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/pprof"
 	"sort"
@@ -13,6 +12,10 @@ import (
 
 	"github.com/aaronriekenberg/pi-web/config"
 	"github.com/aaronriekenberg/pi-web/environment"
+	"github.com/aaronriekenberg/pi-web/handlers/auth"
+	"github.com/aaronriekenberg/pi-web/handlers/ratelimit"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/metrics"
 	"github.com/aaronriekenberg/pi-web/templates"
 	"github.com/aaronriekenberg/pi-web/utils"
 )
@@ -40,16 +43,16 @@ type debugHTMLData struct {
 	PreText string
 }
 
-func configurationHandlerFunction(configuration *config.Configuration) http.HandlerFunc {
-	jsonBytes, err := json.Marshal(configuration)
+func configurationHandlerFunction(configuration *config.Configuration) (http.HandlerFunc, error) {
+	jsonBytes, err := json.Marshal(configuration.Redacted())
 	if err != nil {
-		log.Fatalf("error generating configuration json")
+		return nil, fmt.Errorf("error generating configuration json: %w", err)
 	}
 
 	var formattedJSONBuffer bytes.Buffer
 	err = json.Indent(&formattedJSONBuffer, jsonBytes, "", "  ")
 	if err != nil {
-		log.Fatalf("error indenting configuration json")
+		return nil, fmt.Errorf("error indenting configuration json: %w", err)
 	}
 	formattedJSONString := formattedJSONBuffer.String()
 
@@ -60,7 +63,7 @@ func configurationHandlerFunction(configuration *config.Configuration) http.Hand
 	}
 
 	if err := templates.Templates.ExecuteTemplate(&htmlBuilder, templates.DebugTemplateFile, debugHTMLData); err != nil {
-		log.Fatalf("error executing configuration page template %v", err)
+		return nil, fmt.Errorf("error executing configuration page template %w", err)
 	}
 
 	htmlString := htmlBuilder.String()
@@ -69,20 +72,20 @@ func configurationHandlerFunction(configuration *config.Configuration) http.Hand
 		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
 
 		io.Copy(w, strings.NewReader(htmlString))
-	}
+	}, nil
 }
 
-func environmentHandlerFunction() http.HandlerFunc {
+func environmentHandlerFunction() (http.HandlerFunc, error) {
 	environment := environment.GetEnvironment()
 	jsonBytes, err := json.Marshal(environment)
 	if err != nil {
-		log.Fatalf("error generating environment json: %v", err)
+		return nil, fmt.Errorf("error generating environment json: %w", err)
 	}
 
 	var formattedJSONBuffer bytes.Buffer
 	err = json.Indent(&formattedJSONBuffer, jsonBytes, "", "  ")
 	if err != nil {
-		log.Fatalf("error indenting environment json")
+		return nil, fmt.Errorf("error indenting environment json: %w", err)
 	}
 	formattedJSONString := formattedJSONBuffer.String()
 
@@ -93,7 +96,7 @@ func environmentHandlerFunction() http.HandlerFunc {
 	}
 
 	if err := templates.Templates.ExecuteTemplate(&htmlBuilder, templates.DebugTemplateFile, debugHTMLData); err != nil {
-		log.Fatalf("error executing environment page template %v", err)
+		return nil, fmt.Errorf("error executing environment page template %w", err)
 	}
 
 	htmlString := htmlBuilder.String()
@@ -102,7 +105,7 @@ func environmentHandlerFunction() http.HandlerFunc {
 		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
 
 		io.Copy(w, strings.NewReader(htmlString))
-	}
+	}, nil
 }
 
 func requestInfoHandlerFunc() http.HandlerFunc {
@@ -125,6 +128,12 @@ func requestInfoHandlerFunc() http.HandlerFunc {
 		buffer.WriteString(r.RemoteAddr)
 		buffer.WriteRune('\n')
 
+		buffer.WriteString("User: ")
+		if user, ok := auth.UserFromContext(r.Context()); ok {
+			fmt.Fprintf(&buffer, "%v (%v)", user.Name, user.Method)
+		}
+		buffer.WriteRune('\n')
+
 		buffer.WriteString("RequestURI: ")
 		buffer.WriteString(r.RequestURI)
 		buffer.WriteRune('\n')
@@ -155,7 +164,7 @@ func requestInfoHandlerFunc() http.HandlerFunc {
 		}
 
 		if err := templates.Templates.ExecuteTemplate(&htmlBuilder, templates.DebugTemplateFile, debugHTMLData); err != nil {
-			log.Printf("error executing request info page template %v", err)
+			logging.FromContext(r.Context()).Error("error executing request info page template", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -168,6 +177,60 @@ func requestInfoHandlerFunc() http.HandlerFunc {
 	}
 }
 
+func rateLimitsHandlerFunc(rateLimiter *ratelimit.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonBytes, err := json.MarshalIndent(rateLimiter.Stats(), "", "  ")
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error generating rate limits json", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var htmlBuilder strings.Builder
+		debugHTMLData := &debugHTMLData{
+			Title:   "Rate Limits",
+			PreText: string(jsonBytes),
+		}
+
+		if err := templates.Templates.ExecuteTemplate(&htmlBuilder, templates.DebugTemplateFile, debugHTMLData); err != nil {
+			logging.FromContext(r.Context()).Error("error executing rate limits page template", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		htmlString := htmlBuilder.String()
+
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+
+		io.Copy(w, strings.NewReader(htmlString))
+	}
+}
+
+func metricsHandlerFunc(metricsInfo config.MetricsInfo) (http.HandlerFunc, error) {
+	allowedNetworks, err := utils.ParseIPNets(metricsInfo.AllowedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metrics allowed networks: %w", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(allowedNetworks) > 0 {
+			remoteIP := utils.RemoteIP(r.RemoteAddr)
+			if (remoteIP == nil) || !utils.IPNetsContain(allowedNetworks, remoteIP) {
+				logging.FromContext(r.Context()).Warn("metrics request not from allowed network", "remoteAddr", r.RemoteAddr)
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Add(utils.ContentTypeHeaderKey, metrics.ContentType)
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+
+		if err := metrics.WriteText(w); err != nil {
+			logging.FromContext(r.Context()).Warn("error writing metrics", "error", err)
+		}
+	}, nil
+}
+
 func installPprofHandlers(pprofInfo config.PprofInfo, serveMux *http.ServeMux) {
 	if pprofInfo.Enabled {
 		serveMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
@@ -178,9 +241,40 @@ func installPprofHandlers(pprofInfo config.PprofInfo, serveMux *http.ServeMux) {
 	}
 }
 
-func CreateDebugHandler(configuration *config.Configuration, serveMux *http.ServeMux) {
-	serveMux.Handle("/configuration", configurationHandlerFunction(configuration))
-	serveMux.Handle("/environment", environmentHandlerFunction())
+// CreateDebugHandler registers the debug pages on serveMux.  rateLimiter is nil if
+// rate limiting is not configured.
+func CreateDebugHandler(configuration *config.Configuration, rateLimiter *ratelimit.Limiter, serveMux *http.ServeMux) error {
+	configurationHandler, err := configurationHandlerFunction(configuration)
+	if err != nil {
+		return err
+	}
+
+	environmentHandler, err := environmentHandlerFunction()
+	if err != nil {
+		return err
+	}
+
+	logsPageHandler, err := logsPageHandlerFunction()
+	if err != nil {
+		return err
+	}
+
+	serveMux.Handle("/configuration", configurationHandler)
+	serveMux.Handle("/environment", environmentHandler)
+	serveMux.Handle("/logs", logsPageHandler)
+	serveMux.Handle("/api/logs", logsAPIHandlerFunc())
 	serveMux.Handle("/request_info", requestInfoHandlerFunc())
+	if rateLimiter != nil {
+		serveMux.Handle("/rate_limits", rateLimitsHandlerFunc(rateLimiter))
+	}
+	if configuration.MetricsInfo.Enabled {
+		metricsHandler, err := metricsHandlerFunc(configuration.MetricsInfo)
+		if err != nil {
+			return err
+		}
+		serveMux.Handle("/metrics", metricsHandler)
+	}
 	installPprofHandlers(configuration.PprofInfo, serveMux)
+
+	return nil
 }
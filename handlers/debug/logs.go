@@ -0,0 +1,218 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/templates"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+const (
+	logsStreamEventEntry = "entry"
+
+	// maxLogsFollowDuration ends follow streams so they do not hold up shutdown.
+	// EventSource clients reconnect with the Last-Event-ID header to continue.
+	maxLogsFollowDuration = 5 * time.Minute
+
+	logsFollowKeepAliveInterval = 30 * time.Second
+
+	lastEventIDHeaderKey = "Last-Event-ID"
+)
+
+// logsFilter selects buffered log entries by minimum level, log, and a substring of
+// the entry text.  Access entries have level info.
+type logsFilter struct {
+	minLevel logging.Level
+	log      string
+	contains string
+}
+
+func parseLogsFilter(query url.Values) (*logsFilter, error) {
+	logsFilter := &logsFilter{
+		minLevel: logging.LevelDebug,
+		log:      query.Get("log"),
+		contains: query.Get("contains"),
+	}
+
+	if level := query.Get("level"); len(level) > 0 {
+		var err error
+		if logsFilter.minLevel, err = logging.ParseLevel(level); err != nil {
+			return nil, err
+		}
+	}
+
+	switch logsFilter.log {
+	case "", logging.LogApplication, logging.LogAccess:
+	default:
+		return nil, fmt.Errorf("unknown log %q, expected %v or %v", logsFilter.log, logging.LogApplication, logging.LogAccess)
+	}
+
+	return logsFilter, nil
+}
+
+func (logsFilter *logsFilter) matches(entry *logging.BufferedEntry) bool {
+	if entry.Level < logsFilter.minLevel {
+		return false
+	}
+	if (len(logsFilter.log) > 0) && (entry.Log != logsFilter.log) {
+		return false
+	}
+	return strings.Contains(entry.Text, logsFilter.contains)
+}
+
+func (logsFilter *logsFilter) filter(entries []logging.BufferedEntry) []logging.BufferedEntry {
+	filtered := make([]logging.BufferedEntry, 0, len(entries))
+	for i := range entries {
+		if logsFilter.matches(&entries[i]) {
+			filtered = append(filtered, entries[i])
+		}
+	}
+	return filtered
+}
+
+// parseAfterID returns the ID entries are returned after, from the Last-Event-ID header
+// sent by a reconnecting EventSource or the after query parameter.
+func parseAfterID(r *http.Request) (uint64, error) {
+	afterID := r.Header.Get(lastEventIDHeaderKey)
+	if len(afterID) == 0 {
+		afterID = r.URL.Query().Get("after")
+	}
+	if len(afterID) == 0 {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(afterID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid after ID %q", afterID)
+	}
+	return id, nil
+}
+
+func logsPageHandlerFunction() (http.HandlerFunc, error) {
+	var htmlBuilder strings.Builder
+	if err := templates.Templates.ExecuteTemplate(&htmlBuilder, templates.LogsTemplateFile, nil); err != nil {
+		return nil, fmt.Errorf("error executing logs page template %w", err)
+	}
+
+	htmlString := htmlBuilder.String()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+
+		io.Copy(w, strings.NewReader(htmlString))
+	}, nil
+}
+
+type logsAPIResponse struct {
+	Now     string                  `json:"now"`
+	Entries []logging.BufferedEntry `json:"entries"`
+}
+
+func writeLogsStreamEvent(w io.Writer, entry *logging.BufferedEntry) error {
+	jsonText, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", entry.ID, logsStreamEventEntry, jsonText)
+	return err
+}
+
+// followLogs writes matching entries after afterID as server sent events as they are
+// added, until the client goes away or maxLogsFollowDuration has passed.
+func followLogs(w http.ResponseWriter, r *http.Request, logsFilter *logsFilter, afterID uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeTextEventStream)
+	w.Header().Add(utils.CacheControlHeaderKey, utils.NoCache)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	followTimer := time.NewTimer(maxLogsFollowDuration)
+	defer followTimer.Stop()
+
+	keepAliveTicker := time.NewTicker(logsFollowKeepAliveInterval)
+	defer keepAliveTicker.Stop()
+
+	for {
+		entries, updated := logging.BufferedEntries(afterID)
+		if len(entries) > 0 {
+			afterID = entries[len(entries)-1].ID
+		}
+		for i := range entries {
+			if !logsFilter.matches(&entries[i]) {
+				continue
+			}
+			if err := writeLogsStreamEvent(w, &entries[i]); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-updated:
+		case <-keepAliveTicker.C:
+			// A comment line finds clients that have gone away while no entries are written.
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return
+			}
+		case <-followTimer.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// logsAPIHandlerFunc returns the buffered log entries matching the level, log, and
+// contains query parameters as JSON, or follows them as server sent events if follow
+// is true.
+func logsAPIHandlerFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		logsFilter, err := parseLogsFilter(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		afterID, err := parseAfterID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if query.Get("follow") == "true" {
+			followLogs(w, r, logsFilter, afterID)
+			return
+		}
+
+		entries, _ := logging.BufferedEntries(afterID)
+		logsAPIResponse := &logsAPIResponse{
+			Now:     utils.FormatTime(time.Now()),
+			Entries: logsFilter.filter(entries),
+		}
+
+		jsonText, err := json.Marshal(logsAPIResponse)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeApplicationJSON)
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+		io.Copy(w, bytes.NewReader(jsonText))
+	}
+}
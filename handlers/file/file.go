@@ -2,36 +2,52 @@ package file
 
 import (
 	"bytes"
-	"log"
+	"fmt"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/metrics"
 	"github.com/aaronriekenberg/pi-web/utils"
+
+	"github.com/felixge/httpsnoop"
 )
 
-func staticFileHandlerFunc(staticFileInfo config.StaticFileInfo) http.HandlerFunc {
+var staticBytesServed = metrics.NewCounter(
+	"pi_web_static_bytes_served_total",
+	"Bytes of static file and directory content served by configured http path.",
+	"path")
+
+// countBytesServed counts the response body bytes written by handler under httpPath.
+func countBytesServed(httpPath string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		staticBytesServed.Add(float64(httpsnoop.CaptureMetrics(handler, w, r).Written), httpPath)
+	})
+}
+
+func staticFileHandlerFunc(staticFileInfo config.StaticFileInfo) (http.HandlerFunc, error) {
 	if !staticFileInfo.CacheContentInMemory {
 		return func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add(utils.CacheControlHeaderKey, staticFileInfo.CacheControlValue)
 			http.ServeFile(w, r, staticFileInfo.FilePath)
-		}
+		}, nil
 	}
 
 	fileContents, err := os.ReadFile(staticFileInfo.FilePath)
 	if err != nil {
-		log.Fatalf("error reading CacheContentInMemory static file %v: %v", staticFileInfo.FilePath, err)
+		return nil, fmt.Errorf("error reading CacheContentInMemory static file %v: %w", staticFileInfo.FilePath, err)
 	}
 	lastModified := time.Now()
 
-	log.Printf("cached static file %q in memory bytes = %v", staticFileInfo.FilePath, len(fileContents))
+	logging.Info("cached static file in memory", "file", staticFileInfo.FilePath, "bytes", len(fileContents))
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add(utils.CacheControlHeaderKey, staticFileInfo.CacheControlValue)
 
 		http.ServeContent(w, r, staticFileInfo.FilePath, lastModified, bytes.NewReader(fileContents))
-	}
+	}, nil
 }
 
 func staticDirectoryHandler(staticDirectoryInfo config.StaticDirectoryInfo) http.HandlerFunc {
@@ -45,16 +61,22 @@ func staticDirectoryHandler(staticDirectoryInfo config.StaticDirectoryInfo) http
 	}
 }
 
-func CreateFileHandler(configuration *config.Configuration, serveMux *http.ServeMux) {
+func CreateFileHandler(configuration *config.Configuration, serveMux *http.ServeMux) error {
 	for _, staticFileInfo := range configuration.StaticFiles {
+		handlerFunc, err := staticFileHandlerFunc(staticFileInfo)
+		if err != nil {
+			return err
+		}
 		serveMux.Handle(
 			staticFileInfo.HTTPPath,
-			staticFileHandlerFunc(staticFileInfo))
+			countBytesServed(staticFileInfo.HTTPPath, handlerFunc))
 	}
 
 	for _, staticDirectoryInfo := range configuration.StaticDirectories {
 		serveMux.Handle(
 			staticDirectoryInfo.HTTPPath,
-			staticDirectoryHandler(staticDirectoryInfo))
+			countBytesServed(staticDirectoryInfo.HTTPPath, staticDirectoryHandler(staticDirectoryInfo)))
 	}
+
+	return nil
 }
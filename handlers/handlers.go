@@ -1,42 +1,243 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
-	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/handlers/auth"
 	"github.com/aaronriekenberg/pi-web/handlers/command"
 	"github.com/aaronriekenberg/pi-web/handlers/debug"
 	"github.com/aaronriekenberg/pi-web/handlers/file"
 	"github.com/aaronriekenberg/pi-web/handlers/mainpage"
 	"github.com/aaronriekenberg/pi-web/handlers/proxy"
+	"github.com/aaronriekenberg/pi-web/handlers/ratelimit"
+	"github.com/aaronriekenberg/pi-web/handlers/system"
+	"github.com/aaronriekenberg/pi-web/metrics"
+	"github.com/aaronriekenberg/pi-web/templates"
+	"github.com/aaronriekenberg/pi-web/utils"
 
-	gorillaHandlers "github.com/gorilla/handlers"
+	"github.com/felixge/httpsnoop"
 )
 
+var (
+	httpRequests = metrics.NewCounter(
+		"pi_web_http_requests_total",
+		"HTTP requests by route and status code.",
+		"route", "code")
+
+	httpRequestDuration = metrics.NewHistogram(
+		"pi_web_http_request_duration_seconds",
+		"HTTP request latency by route in seconds.",
+		metrics.DefaultBuckets,
+		"route")
+)
+
+// requestMetricsHandler counts requests and their latency by the serveMux pattern
+// they match, which keeps the number of routes in the metrics bounded.
+func requestMetricsHandler(serveMux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := serveMux.Handler(r)
+		if len(route) == 0 {
+			route = "none"
+		}
+
+		requestMetrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		httpRequests.Inc(route, strconv.Itoa(requestMetrics.Code))
+		httpRequestDuration.Observe(requestMetrics.Duration.Seconds(), route)
+	})
+}
+
 var allowedHTTPMethods = map[string]bool{
 	http.MethodGet:  true,
 	http.MethodHead: true,
 }
 
+// routeAllowsMethod returns true if the handler for r allows r.Method in addition to allowedHTTPMethods.
+func routeAllowsMethod(serveMux *http.ServeMux, r *http.Request) bool {
+	handler, _ := serveMux.Handler(r)
+	methodAllower, ok := handler.(utils.MethodAllower)
+	return ok && methodAllower.AllowsMethod(r.Method)
+}
+
+// activeRequests counts the requests being served by a Handlers, so resources the
+// requests use are released only after the Handlers is retired and they complete.
+type activeRequests struct {
+	mutex   sync.Mutex
+	count   int
+	retired bool
+	done    chan struct{}
+}
+
+// begin counts a request.  It returns false if the Handlers has been retired and
+// its requests have completed.
+func (activeRequests *activeRequests) begin() bool {
+	activeRequests.mutex.Lock()
+	defer activeRequests.mutex.Unlock()
+
+	if activeRequests.retired && (activeRequests.count == 0) {
+		return false
+	}
+	activeRequests.count++
+	return true
+}
+
+func (activeRequests *activeRequests) end() {
+	activeRequests.mutex.Lock()
+	defer activeRequests.mutex.Unlock()
+
+	activeRequests.count--
+	if activeRequests.retired && (activeRequests.count == 0) {
+		close(activeRequests.done)
+	}
+}
+
+// retire returns a channel that is closed once the counted requests have completed.
+func (activeRequests *activeRequests) retire() <-chan struct{} {
+	activeRequests.mutex.Lock()
+	defer activeRequests.mutex.Unlock()
+
+	activeRequests.retired = true
+	if activeRequests.count == 0 {
+		close(activeRequests.done)
+	}
+	return activeRequests.done
+}
+
+// Handlers serves requests for one configuration and owns the background tasks,
+// such as scheduled commands and circuit breaker probes, and the upstream
+// connections that go with it.
+type Handlers struct {
+	serveHandler     http.Handler
+	commandScheduler *command.Scheduler
+	proxies          *proxy.Proxies
+	rateLimiter      *ratelimit.Limiter
+	requests         activeRequests
+}
+
+func (handlers *Handlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handlers.serveHandler.ServeHTTP(w, r)
+}
+
+// serveIfActive serves r and returns true unless handlers has been retired and its
+// requests have completed.
+func (handlers *Handlers) serveIfActive(w http.ResponseWriter, r *http.Request) bool {
+	if !handlers.requests.begin() {
+		return false
+	}
+	defer handlers.requests.end()
+
+	handlers.ServeHTTP(w, r)
+	return true
+}
+
+// adopt replaces the state kept across configurations, such as command histories and
+// rate limit buckets, with that of handlers.  CreateHandlers only reads the kept state,
+// so handlers that are never stored do not change it.
+func (handlers *Handlers) adopt() {
+	handlers.commandScheduler.Adopt()
+	if handlers.rateLimiter != nil {
+		handlers.rateLimiter.Adopt()
+	}
+}
+
+// Start starts the background tasks.
+func (handlers *Handlers) Start() {
+	handlers.commandScheduler.Start()
+	handlers.proxies.Start()
+}
+
+// Stop stops the background tasks.
+func (handlers *Handlers) Stop() {
+	handlers.commandScheduler.Stop()
+	handlers.proxies.Stop()
+}
+
+// Close closes the upstream connections of handlers that were created but not stored,
+// such as when a reload is rejected.
+func (handlers *Handlers) Close() {
+	handlers.proxies.Close()
+}
+
+// retire stops the background tasks, and closes the upstream connections once the
+// requests being served have completed.
+func (handlers *Handlers) retire() {
+	handlers.Stop()
+
+	done := handlers.requests.retire()
+	go func() {
+		<-done
+		handlers.proxies.Close()
+	}()
+}
+
+// CreateHandlers creates the Handlers for configuration.  Background tasks are not started,
+// and state kept across configurations is not changed until the Handlers is stored.
 func CreateHandlers(
 	configuration *config.Configuration,
-) http.Handler {
+) (handlers *Handlers, err error) {
+
+	var proxies *proxy.Proxies
+	defer func() {
+		if (err != nil) && (proxies != nil) {
+			proxies.Close()
+		}
+	}()
+
+	// http.ServeMux.Handle panics on invalid or duplicate patterns.
+	defer func() {
+		if r := recover(); r != nil {
+			handlers = nil
+			err = fmt.Errorf("error registering handlers: %v", r)
+		}
+	}()
+
+	if err = templates.Load(); err != nil {
+		return
+	}
 
 	serveMux := http.NewServeMux()
 
-	mainpage.CreateMainPageHandler(configuration, serveMux)
+	if err = mainpage.CreateMainPageHandler(configuration, serveMux); err != nil {
+		return
+	}
 
-	file.CreateFileHandler(configuration, serveMux)
+	if err = file.CreateFileHandler(configuration, serveMux); err != nil {
+		return
+	}
 
-	command.CreateCommandHandler(configuration, serveMux)
+	commandScheduler, err := command.CreateCommandHandler(configuration, serveMux)
+	if err != nil {
+		return
+	}
+
+	proxies, err = proxy.CreateProxyHandler(configuration, serveMux)
+	if err != nil {
+		return
+	}
+
+	if err = system.CreateSystemHandler(configuration, serveMux); err != nil {
+		return
+	}
 
-	proxy.CreateProxyHandler(configuration, serveMux)
+	var rateLimiter *ratelimit.Limiter
+	if configuration.RateLimitInfo != nil {
+		if rateLimiter, err = ratelimit.NewLimiter(configuration.RateLimitInfo); err != nil {
+			return
+		}
+	}
 
-	debug.CreateDebugHandler(configuration, serveMux)
+	if err = debug.CreateDebugHandler(configuration, rateLimiter, serveMux); err != nil {
+		return
+	}
 
 	allowedHTTPMethodsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !allowedHTTPMethods[r.Method] {
+		if !allowedHTTPMethods[r.Method] && !routeAllowsMethod(serveMux, r) {
 			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 			return
 		}
@@ -45,9 +246,80 @@ func CreateHandlers(
 	})
 
 	var serveHandler http.Handler = allowedHTTPMethodsHandler
-	if configuration.LogRequests {
-		serveHandler = gorillaHandlers.CombinedLoggingHandler(os.Stdout, serveHandler)
+	if configuration.AuthInfo != nil {
+		var authenticator *auth.Authenticator
+		if authenticator, err = auth.NewAuthenticator(configuration); err != nil {
+			return
+		}
+		serveHandler = authenticator.Handler(serveHandler)
+	}
+	// Rate limiting comes before authentication so it also limits password guessing.
+	if rateLimiter != nil {
+		serveHandler = rateLimiter.Handler(serveHandler)
+	}
+	serveHandler = requestMetricsHandler(serveMux, serveHandler)
+	serveHandler = requestLogHandler(configuration.LogRequests, serveHandler)
+
+	handlers = &Handlers{
+		serveHandler:     serveHandler,
+		commandScheduler: commandScheduler,
+		proxies:          proxies,
+		rateLimiter:      rateLimiter,
+		requests: activeRequests{
+			done: make(chan struct{}),
+		},
 	}
+	return
+}
+
+// ReloadableHandler serves each request with the Handlers most recently passed to Store,
+// so handlers can be rebuilt without restarting the servers.
+type ReloadableHandler struct {
+	mutex    sync.Mutex
+	handlers atomic.Value
+}
+
+// NewReloadableHandler returns a ReloadableHandler serving handlers and starts its background tasks.
+func NewReloadableHandler(handlers *Handlers) *ReloadableHandler {
+	reloadableHandler := &ReloadableHandler{}
+	reloadableHandler.Store(handlers)
+	return reloadableHandler
+}
+
+// Store adopts the state of handlers, starts its background tasks, serves subsequent
+// requests with it, and retires the previous Handlers.
+func (reloadableHandler *ReloadableHandler) Store(handlers *Handlers) {
+	reloadableHandler.mutex.Lock()
+	defer reloadableHandler.mutex.Unlock()
+
+	previousHandlers, _ := reloadableHandler.handlers.Load().(*Handlers)
+
+	handlers.adopt()
+	handlers.Start()
+	reloadableHandler.handlers.Store(handlers)
+
+	if previousHandlers != nil {
+		previousHandlers.retire()
+	}
+}
+
+func (reloadableHandler *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// A Handlers loaded just before it is retired may already be closed, in which case
+	// the Handlers that replaced it serves the request.
+	for {
+		handlers := reloadableHandler.handlers.Load().(*Handlers)
+		if handlers.serveIfActive(w, r) {
+			return
+		}
+	}
+}
+
+// Shutdown stops the background tasks of the current Handlers and waits for work
+// started by the handlers, such as running commands, to complete.
+func (reloadableHandler *ReloadableHandler) Shutdown(ctx context.Context) error {
+	reloadableHandler.mutex.Lock()
+	reloadableHandler.handlers.Load().(*Handlers).Stop()
+	reloadableHandler.mutex.Unlock()
 
-	return serveHandler
+	return command.WaitForRunningCommands(ctx)
 }
@@ -1,49 +1,96 @@
 package mainpage
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/aaronriekenberg/pi-web/config"
 	"github.com/aaronriekenberg/pi-web/environment"
+	"github.com/aaronriekenberg/pi-web/handlers/auth"
 	"github.com/aaronriekenberg/pi-web/templates"
 	"github.com/aaronriekenberg/pi-web/utils"
 )
 
 type mainPageMetadata struct {
-	Configuration                  *config.Configuration
-	NumStaticDirectoriesInMainPage int
-	Environment                    *environment.Environment
-	LastModified                   string
+	Configuration     *config.Configuration
+	Commands          []config.CommandInfo
+	Proxies           []config.ProxyInfo
+	StaticDirectories []config.StaticDirectoryInfo
+	User              *auth.User
+	LoginPath         string
+	Environment       *environment.Environment
+	LastModified      string
+	access            *auth.Access
 }
 
-func buildMainPageString(configuration *config.Configuration, lastModified time.Time) string {
+// PathAllowed reports whether the page's user may access path.
+func (mainPageMetadata *mainPageMetadata) PathAllowed(path string) bool {
+	return mainPageMetadata.access.PathAllowed(path)
+}
+
+// buildMainPageString builds the main page listing only what access allows.
+func buildMainPageString(configuration *config.Configuration, access *auth.Access, lastModified time.Time) (string, error) {
 	var builder strings.Builder
 
 	mainPageMetadata := &mainPageMetadata{
 		Configuration: configuration,
+		User:          access.User(),
 		Environment:   environment.GetEnvironment(),
 		LastModified:  utils.FormatTime(lastModified),
+		access:        access,
+	}
+
+	for _, commandInfo := range configuration.CommandConfiguration.Commands {
+		if access.PathAllowed("/commands/" + commandInfo.ID + ".html") {
+			mainPageMetadata.Commands = append(mainPageMetadata.Commands, commandInfo)
+		}
 	}
 
-	for i := range configuration.StaticDirectories {
-		if configuration.StaticDirectories[i].IncludeInMainPage {
-			mainPageMetadata.NumStaticDirectoriesInMainPage++
+	for _, proxyInfo := range configuration.Proxies {
+		path := "/proxies/" + proxyInfo.ID + ".html"
+		if proxyInfo.IsReverse() {
+			path = proxyInfo.ReversePathPrefix()
+		}
+		if access.PathAllowed(path) {
+			mainPageMetadata.Proxies = append(mainPageMetadata.Proxies, proxyInfo)
 		}
 	}
 
+	for _, staticDirectoryInfo := range configuration.StaticDirectories {
+		if staticDirectoryInfo.IncludeInMainPage && access.PathAllowed(staticDirectoryInfo.HTTPPath) {
+			mainPageMetadata.StaticDirectories = append(mainPageMetadata.StaticDirectories, staticDirectoryInfo)
+		}
+	}
+
+	if (configuration.AuthInfo != nil) && (len(configuration.AuthInfo.Users) > 0) && (mainPageMetadata.User == nil) {
+		mainPageMetadata.LoginPath = auth.LoginPath
+	}
+
 	if err := templates.Templates.ExecuteTemplate(&builder, templates.MainTemplateFile, mainPageMetadata); err != nil {
-		log.Fatalf("error executing main page template %v", err)
+		return "", fmt.Errorf("error executing main page template %w", err)
 	}
-	return builder.String()
+	return builder.String(), nil
 }
 
-func mainPageHandlerFunc(configuration *config.Configuration) http.HandlerFunc {
+// mainPageHandlerFunc serves the main page.  Without authentication it is the same for
+// every request and built once, otherwise it is built for each request's user.
+// A per-user page is served without Last-Modified, so a conditional request can not
+// be answered with 304 for a page cached for a different user.
+func mainPageHandlerFunc(configuration *config.Configuration) (http.HandlerFunc, error) {
 	lastModified := time.Now()
-	mainPageString := buildMainPageString(configuration, lastModified)
+	mainPageString, err := buildMainPageString(configuration, nil, lastModified)
+	if err != nil {
+		return nil, err
+	}
 	cacheControlValue := configuration.TemplatePageInfo.CacheControlValue
+	perUser := configuration.AuthInfo != nil
+
+	varyValue := "Authorization"
+	if perUser && (configuration.AuthInfo.TrustedHeader != nil) {
+		varyValue += ", " + http.CanonicalHeaderKey(configuration.AuthInfo.TrustedHeader.Header)
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -51,12 +98,31 @@ func mainPageHandlerFunc(configuration *config.Configuration) http.HandlerFunc {
 			return
 		}
 
+		pageString := mainPageString
+		pageModified := lastModified
+		if perUser {
+			var err error
+			pageString, err = buildMainPageString(configuration, auth.AccessFromContext(r.Context()), lastModified)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Add("Vary", varyValue)
+			pageModified = time.Time{}
+		}
+
 		w.Header().Add(utils.CacheControlHeaderKey, cacheControlValue)
 		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeTextHTML)
-		http.ServeContent(w, r, templates.MainTemplateFile, lastModified, strings.NewReader(mainPageString))
-	}
+		http.ServeContent(w, r, templates.MainTemplateFile, pageModified, strings.NewReader(pageString))
+	}, nil
 }
 
-func CreateMainPageHandler(configuration *config.Configuration, serveMux *http.ServeMux) {
-	serveMux.Handle("/", mainPageHandlerFunc(configuration))
+func CreateMainPageHandler(configuration *config.Configuration, serveMux *http.ServeMux) error {
+	handlerFunc, err := mainPageHandlerFunc(configuration)
+	if err != nil {
+		return err
+	}
+
+	serveMux.Handle("/", handlerFunc)
+	return nil
 }
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+const (
+	circuitStateClosed   = "closed"
+	circuitStateOpen     = "open"
+	circuitStateHalfOpen = "halfOpen"
+)
+
+type circuitBreakerState struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	OpenedAt            string `json:"openedAt,omitempty"`
+	NextProbeAt         string `json:"nextProbeAt,omitempty"`
+}
+
+// circuitBreaker fails requests to an upstream fast after consecutive failures.
+// A nil circuitBreaker allows every request.
+type circuitBreaker struct {
+	mutex               sync.Mutex
+	failureThreshold    int
+	openDuration        time.Duration
+	probeInterval       time.Duration
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+}
+
+func newCircuitBreaker(circuitBreakerInfo *config.ProxyCircuitBreakerInfo) *circuitBreaker {
+	if circuitBreakerInfo == nil {
+		return nil
+	}
+
+	breaker := &circuitBreaker{
+		failureThreshold: circuitBreakerInfo.FailureThreshold,
+		openDuration:     time.Duration(circuitBreakerInfo.OpenMilliseconds) * time.Millisecond,
+		probeInterval:    time.Duration(circuitBreakerInfo.ProbeIntervalMilliseconds) * time.Millisecond,
+		state:            circuitStateClosed,
+	}
+	if breaker.probeInterval == 0 {
+		breaker.probeInterval = breaker.openDuration
+	}
+	return breaker
+}
+
+// allow reports whether a request may be made.  Once the circuit has been open
+// for openDuration the next caller is allowed as the probe, and the circuit is
+// half open until the probe's result is recorded.
+func (breaker *circuitBreaker) allow() (allowed bool, probe bool) {
+	if breaker == nil {
+		return true, false
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	switch breaker.state {
+	case circuitStateOpen:
+		if time.Since(breaker.openedAt) < breaker.openDuration {
+			return false, false
+		}
+		breaker.state = circuitStateHalfOpen
+		return true, true
+
+	case circuitStateHalfOpen:
+		return false, false
+	}
+	return true, false
+}
+
+// record records the result of an allowed request.
+func (breaker *circuitBreaker) record(success bool) {
+	if breaker == nil {
+		return
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if success {
+		breaker.state = circuitStateClosed
+		breaker.consecutiveFailures = 0
+		return
+	}
+
+	breaker.consecutiveFailures++
+	if (breaker.state == circuitStateHalfOpen) || (breaker.consecutiveFailures >= breaker.failureThreshold) {
+		breaker.state = circuitStateOpen
+		breaker.openedAt = time.Now()
+	}
+}
+
+// abandonProbe reopens a half open circuit when the probe ends without a result,
+// so the next request becomes the probe.
+func (breaker *circuitBreaker) abandonProbe() {
+	if breaker == nil {
+		return
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state == circuitStateHalfOpen {
+		breaker.state = circuitStateOpen
+	}
+}
+
+func (breaker *circuitBreaker) snapshot() *circuitBreakerState {
+	if breaker == nil {
+		return nil
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	state := &circuitBreakerState{
+		State:               breaker.state,
+		ConsecutiveFailures: breaker.consecutiveFailures,
+	}
+	if breaker.state != circuitStateClosed {
+		state.OpenedAt = utils.FormatTime(breaker.openedAt)
+		state.NextProbeAt = utils.FormatTime(breaker.openedAt.Add(breaker.openDuration))
+	}
+	return state
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/handlers/auth"
+	"github.com/aaronriekenberg/pi-web/jsonpath"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+type proxyVerdict struct {
+	Passed  bool     `json:"passed"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func (verdict *proxyVerdict) fail(format string, args ...interface{}) {
+	verdict.Passed = false
+	verdict.Reasons = append(verdict.Reasons, fmt.Sprintf(format, args...))
+}
+
+// proxyAssertions checks proxy responses against config.ProxyAssertionsInfo.
+type proxyAssertions struct {
+	statusCodes map[int]bool
+	bodyRegexp  *regexp.Regexp
+	jsonPath    *jsonpath.Path
+	jsonValue   interface{}
+	maxLatency  time.Duration
+}
+
+func newProxyAssertions(assertionsInfo *config.ProxyAssertionsInfo) (*proxyAssertions, error) {
+	assertions := &proxyAssertions{}
+	if assertionsInfo == nil {
+		return assertions, nil
+	}
+
+	if len(assertionsInfo.StatusCodes) > 0 {
+		assertions.statusCodes = make(map[int]bool, len(assertionsInfo.StatusCodes))
+		for _, statusCode := range assertionsInfo.StatusCodes {
+			assertions.statusCodes[statusCode] = true
+		}
+	}
+
+	if len(assertionsInfo.BodyRegex) > 0 {
+		bodyRegexp, err := regexp.Compile(assertionsInfo.BodyRegex)
+		if err != nil {
+			return nil, err
+		}
+		assertions.bodyRegexp = bodyRegexp
+	}
+
+	if len(assertionsInfo.JSONPath) > 0 {
+		jsonPath, err := jsonpath.Parse(assertionsInfo.JSONPath)
+		if err != nil {
+			return nil, err
+		}
+		assertions.jsonPath = jsonPath
+		assertions.jsonValue = assertionsInfo.JSONValue
+	}
+
+	assertions.maxLatency = time.Duration(assertionsInfo.MaxLatencyMilliseconds) * time.Millisecond
+
+	return assertions, nil
+}
+
+// jsonValuesEqual returns true if decoded JSON values a and b are equal.  Numbers are
+// json.Number and are compared by value, so 1 equals 1.0 and large integers are exact.
+func jsonValuesEqual(a, b interface{}) bool {
+	switch typedA := a.(type) {
+	case json.Number:
+		typedB, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ratA, okA := new(big.Rat).SetString(typedA.String())
+		ratB, okB := new(big.Rat).SetString(typedB.String())
+		return okA && okB && (ratA.Cmp(ratB) == 0)
+
+	case map[string]interface{}:
+		typedB, ok := b.(map[string]interface{})
+		if !ok || (len(typedA) != len(typedB)) {
+			return false
+		}
+		for key, valueA := range typedA {
+			valueB, ok := typedB[key]
+			if !ok || !jsonValuesEqual(valueA, valueB) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		typedB, ok := b.([]interface{})
+		if !ok || (len(typedA) != len(typedB)) {
+			return false
+		}
+		for i := range typedA {
+			if !jsonValuesEqual(typedA[i], typedB[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a == b
+}
+
+// check returns the verdict for a response.  Every failed assertion adds a reason.
+func (assertions *proxyAssertions) check(statusCode int, body []byte, latency time.Duration) *proxyVerdict {
+	verdict := &proxyVerdict{
+		Passed: true,
+	}
+
+	if (assertions.statusCodes != nil) && !assertions.statusCodes[statusCode] {
+		verdict.fail("status code %v not expected", statusCode)
+	}
+
+	if (assertions.bodyRegexp != nil) && !assertions.bodyRegexp.Match(body) {
+		verdict.fail("body does not match %v", assertions.bodyRegexp)
+	}
+
+	if assertions.jsonPath != nil {
+		if bodyValue, err := decodeJSON(body); err != nil {
+			verdict.fail("body is not json: %v", err)
+		} else if value, ok := assertions.jsonPath.Lookup(bodyValue); !ok {
+			verdict.fail("json path %v not found", assertions.jsonPath)
+		} else if (assertions.jsonValue != nil) && !jsonValuesEqual(value, assertions.jsonValue) {
+			verdict.fail("json path %v is %v, expected %v", assertions.jsonPath, value, assertions.jsonValue)
+		}
+	}
+
+	if (assertions.maxLatency > 0) && (latency > assertions.maxLatency) {
+		verdict.fail("latency %v over %v", latency, assertions.maxLatency)
+	}
+
+	return verdict
+}
+
+type proxyHealth struct {
+	verdict *proxyVerdict
+	time    time.Time
+}
+
+// proxyHealthRegistry holds the latest verdict for each proxy ID.
+// Verdicts are kept across configuration reloads.
+type proxyHealthRegistry struct {
+	mutex  sync.RWMutex
+	latest map[string]proxyHealth
+}
+
+var healthRegistry = &proxyHealthRegistry{
+	latest: make(map[string]proxyHealth),
+}
+
+func (registry *proxyHealthRegistry) record(proxyID string, verdict *proxyVerdict) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.latest[proxyID] = proxyHealth{
+		verdict: verdict,
+		time:    time.Now(),
+	}
+}
+
+func (registry *proxyHealthRegistry) get(proxyID string) (proxyHealth, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	health, ok := registry.latest[proxyID]
+	return health, ok
+}
+
+const (
+	healthStatusPass    = "pass"
+	healthStatusFail    = "fail"
+	healthStatusUnknown = "unknown"
+)
+
+type proxyHealthResponse struct {
+	ID             string               `json:"id"`
+	Description    string               `json:"description"`
+	Status         string               `json:"status"`
+	CheckedAt      string               `json:"checkedAt,omitempty"`
+	Reasons        []string             `json:"reasons,omitempty"`
+	CircuitBreaker *circuitBreakerState `json:"circuitBreaker,omitempty"`
+}
+
+type healthAPIResponse struct {
+	Now     string                `json:"now"`
+	Healthy bool                  `json:"healthy"`
+	Proxies []proxyHealthResponse `json:"proxies"`
+}
+
+// healthAPIHandlerFunc returns the latest verdict and circuit breaker state of every
+// json mode proxy the user may access.  The status is 503 if any of those proxies'
+// latest verdict failed.
+func healthAPIHandlerFunc(configuration *config.Configuration, circuitBreakers map[string]*circuitBreaker) http.HandlerFunc {
+	var proxyInfos []config.ProxyInfo
+	for _, proxyInfo := range configuration.Proxies {
+		if !proxyInfo.IsReverse() {
+			proxyInfos = append(proxyInfos, proxyInfo)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		healthAPIResponse := &healthAPIResponse{
+			Now:     utils.FormatTime(time.Now()),
+			Healthy: true,
+			Proxies: make([]proxyHealthResponse, 0, len(proxyInfos)),
+		}
+
+		access := auth.AccessFromContext(r.Context())
+
+		for _, proxyInfo := range proxyInfos {
+			if !access.PathAllowed("/api/proxies/" + proxyInfo.ID) {
+				continue
+			}
+
+			proxyHealthResponse := proxyHealthResponse{
+				ID:             proxyInfo.ID,
+				Description:    proxyInfo.Description,
+				Status:         healthStatusUnknown,
+				CircuitBreaker: circuitBreakers[proxyInfo.ID].snapshot(),
+			}
+
+			if health, ok := healthRegistry.get(proxyInfo.ID); ok {
+				proxyHealthResponse.CheckedAt = utils.FormatTime(health.time)
+				proxyHealthResponse.Reasons = health.verdict.Reasons
+				if health.verdict.Passed {
+					proxyHealthResponse.Status = healthStatusPass
+				} else {
+					proxyHealthResponse.Status = healthStatusFail
+					healthAPIResponse.Healthy = false
+				}
+			}
+
+			healthAPIResponse.Proxies = append(healthAPIResponse.Proxies, proxyHealthResponse)
+		}
+
+		jsonText, err := json.Marshal(healthAPIResponse)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeApplicationJSON)
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+		if !healthAPIResponse.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		io.Copy(w, bytes.NewReader(jsonText))
+	}
+}
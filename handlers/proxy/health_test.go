@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/handlers/auth"
+)
+
+func TestAssertionsJSONValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		jsonPath  string
+		jsonValue interface{}
+		body      string
+		want      bool
+	}{
+		{
+			name:      "large integer equal",
+			jsonPath:  "$.id",
+			jsonValue: json.Number("12345678901234567890"),
+			body:      `{"id":12345678901234567890}`,
+			want:      true,
+		},
+		{
+			name:      "large integer differing past float64 precision",
+			jsonPath:  "$.id",
+			jsonValue: json.Number("12345678901234567890"),
+			body:      `{"id":12345678901234567891}`,
+			want:      false,
+		},
+		{
+			name:      "number with different representation",
+			jsonPath:  "$.count",
+			jsonValue: json.Number("1"),
+			body:      `{"count":1.0}`,
+			want:      true,
+		},
+		{
+			name:      "object",
+			jsonPath:  "$.status",
+			jsonValue: map[string]interface{}{"ok": true, "checks": []interface{}{json.Number("2"), "db"}},
+			body:      `{"status":{"checks":[2,"db"],"ok":true}}`,
+			want:      true,
+		},
+		{
+			name:      "string not equal to number",
+			jsonPath:  "$.count",
+			jsonValue: "1",
+			body:      `{"count":1}`,
+			want:      false,
+		},
+		{
+			name:     "path exists",
+			jsonPath: "$.count",
+			body:     `{"count":1}`,
+			want:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertions, err := newProxyAssertions(&config.ProxyAssertionsInfo{
+				JSONPath:  test.jsonPath,
+				JSONValue: test.jsonValue,
+			})
+			if err != nil {
+				t.Fatalf("newProxyAssertions error = %v", err)
+			}
+
+			verdict := assertions.check(http.StatusOK, []byte(test.body), 0)
+			if verdict.Passed != test.want {
+				t.Errorf("check passed = %v, reasons %v, want %v", verdict.Passed, verdict.Reasons, test.want)
+			}
+		})
+	}
+}
+
+func TestHealthAPIFiltersProxiesByAccess(t *testing.T) {
+	configuration := &config.Configuration{
+		Proxies: []config.ProxyInfo{
+			{ID: "net_router", URL: "http://127.0.0.1:8081/"},
+			{ID: "nas", URL: "http://127.0.0.1:8082/"},
+		},
+		AuthInfo: &config.AuthInfo{
+			TrustedHeader: &config.AuthTrustedHeaderInfo{
+				Header:         "X-User",
+				TrustedProxies: []string{"192.0.2.1/32"},
+			},
+			Roles: []config.AuthRoleInfo{
+				{Name: "network", ProxyIDs: []string{"net_*"}},
+			},
+			UserRoles: map[string][]string{
+				"alice": {"network"},
+			},
+		},
+	}
+	authenticator, err := auth.NewAuthenticator(configuration)
+	if err != nil {
+		t.Fatalf("NewAuthenticator error = %v", err)
+	}
+	handler := authenticator.Handler(healthAPIHandlerFunc(configuration, nil))
+
+	tests := []struct {
+		user     string
+		wantCode int
+		wantIDs  []string
+	}{
+		{user: "alice", wantCode: http.StatusOK, wantIDs: []string{"net_router"}},
+		{user: "bob", wantCode: http.StatusOK, wantIDs: []string{}},
+		{user: "", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.user, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/health", nil)
+			if len(test.user) > 0 {
+				r.Header.Set("X-User", test.user)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != test.wantCode {
+				t.Fatalf("code = %v, want %v", w.Code, test.wantCode)
+			}
+			if test.wantCode != http.StatusOK {
+				return
+			}
+
+			var response healthAPIResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("json.Unmarshal error = %v", err)
+			}
+			ids := []string{}
+			for _, proxyHealthResponse := range response.Proxies {
+				ids = append(ids, proxyHealthResponse.ID)
+			}
+			if !reflect.DeepEqual(ids, test.wantIDs) {
+				t.Errorf("proxy ids = %v, want %v", ids, test.wantIDs)
+			}
+		})
+	}
+}
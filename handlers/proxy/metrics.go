@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/metrics"
+)
+
+const (
+	proxyFailureCircuitBreakerOpen = "circuitBreakerOpen"
+	proxyFailureError              = "error"
+	proxyFailureServerError        = "serverError"
+	proxyFailureAssertion          = "assertion"
+)
+
+var (
+	proxyRequestDuration = metrics.NewHistogram(
+		"pi_web_proxy_request_duration_seconds",
+		"Proxy request latency by proxy ID in seconds, including any retries.",
+		metrics.DefaultBuckets,
+		"id")
+
+	proxyFailures = metrics.NewCounter(
+		"pi_web_proxy_failures_total",
+		"Failed proxy requests by proxy ID and reason.",
+		"id", "reason")
+
+	proxyClientDisconnects = metrics.NewCounter(
+		"pi_web_proxy_client_disconnects_total",
+		"Proxy requests cancelled because the client went away by proxy ID.",
+		"id")
+)
+
+// jsonProxyFailureReason returns the reason a json mode proxy request failed, or
+// "" if it did not.  response is nil if err is not.
+func jsonProxyFailureReason(response *proxyAPIResponse, err error) string {
+	switch {
+	case err != nil:
+		return proxyFailureError
+	case response.ProxyStatusCode >= http.StatusInternalServerError:
+		return proxyFailureServerError
+	case !response.Verdict.Passed:
+		return proxyFailureAssertion
+	}
+	return ""
+}
+
+// recordClientDisconnect counts and logs a proxy request cancelled because the client went away.
+func recordClientDisconnect(ctx context.Context, proxyID string) {
+	proxyClientDisconnects.Inc(proxyID)
+
+	logging.FromContext(ctx).Info("proxy request cancelled by client disconnect", "proxyID", proxyID)
+}
@@ -4,15 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
 	"github.com/aaronriekenberg/pi-web/templates"
 	"github.com/aaronriekenberg/pi-web/utils"
 )
@@ -22,17 +24,17 @@ type proxyHTMLData struct {
 }
 
 func proxyHTMLHandlerFunc(
-	configuration *config.Configuration, proxyInfo config.ProxyInfo) http.HandlerFunc {
+	configuration *config.Configuration, proxyInfo config.ProxyInfo) (http.HandlerFunc, error) {
 
 	cacheControlValue := configuration.TemplatePageInfo.CacheControlValue
 
 	proxyHTMLData := &proxyHTMLData{
-		ProxyInfo: &proxyInfo,
+		ProxyInfo: proxyInfo.Redacted(),
 	}
 
 	var builder strings.Builder
 	if err := templates.Templates.ExecuteTemplate(&builder, templates.ProxyTemplateFile, proxyHTMLData); err != nil {
-		log.Fatalf("Error executing proxy template ID %v: %v", proxyInfo.ID, err)
+		return nil, fmt.Errorf("error executing proxy template ID %v: %w", proxyInfo.ID, err)
 	}
 
 	htmlString := builder.String()
@@ -42,29 +44,112 @@ func proxyHTMLHandlerFunc(
 		w.Header().Add(utils.CacheControlHeaderKey, cacheControlValue)
 		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeTextHTML)
 		http.ServeContent(w, r, templates.ProxyTemplateFile, lastModified, strings.NewReader(htmlString))
-	}
+	}, nil
 }
 
 type proxyAPIResponse struct {
-	ProxyInfo        *config.ProxyInfo `json:"proxyInfo"`
-	Now              string            `json:"now"`
-	ProxyDuration    string            `json:"proxyDuration"`
-	ProxyStatus      string            `json:"proxyStatus"`
-	ProxyRespHeaders http.Header       `json:"proxyRespHeaders"`
-	ProxyOutput      string            `json:"proxyOutput"`
+	ProxyInfo         *config.ProxyInfo    `json:"proxyInfo"`
+	Now               string               `json:"now"`
+	ProxyDuration     string               `json:"proxyDuration"`
+	ProxyStatus       string               `json:"proxyStatus"`
+	ProxyStatusCode   int                  `json:"proxyStatusCode"`
+	ProxyRespHeaders  http.Header          `json:"proxyRespHeaders"`
+	ProxyOutput       string               `json:"proxyOutput"`
+	ProxyOutputFormat string               `json:"proxyOutputFormat"`
+	ProxyOutputError  string               `json:"proxyOutputError,omitempty"`
+	ProxyBodyBytes    int64                `json:"proxyBodyBytes"`
+	ProxyTruncated    bool                 `json:"proxyTruncated"`
+	ProxyError        string               `json:"proxyError,omitempty"`
+	Attempts          int                  `json:"attempts"`
+	CircuitBreaker    *circuitBreakerState `json:"circuitBreaker,omitempty"`
+	Verdict           *proxyVerdict        `json:"verdict"`
 }
 
-func makeProxyRequest(ctx context.Context, proxyInfo *config.ProxyInfo) (response *proxyAPIResponse, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
-	defer cancel()
+const defaultProxyTimeout = 5 * time.Second
+
+// proxyTimeout returns proxyInfo.TimeoutMilliseconds if set, otherwise defaultProxyTimeout.
+func proxyTimeout(proxyInfo *config.ProxyInfo) time.Duration {
+	if proxyInfo.TimeoutMilliseconds > 0 {
+		return time.Duration(proxyInfo.TimeoutMilliseconds) * time.Millisecond
+	}
+	return defaultProxyTimeout
+}
+
+// readProxyBody reads proxyResponse.Body, keeping at most maxBodyBytes of it if set.
+// bodyBytes is the length of the whole body, from Content-Length if known, otherwise
+// by reading and discarding the rest of the body.
+func readProxyBody(proxyResponse *http.Response, maxBodyBytes int64) (bodyBuffer []byte, bodyBytes int64, err error) {
+	if maxBodyBytes <= 0 {
+		bodyBuffer, err = ioutil.ReadAll(proxyResponse.Body)
+		bodyBytes = int64(len(bodyBuffer))
+		return
+	}
+
+	bodyBuffer, err = ioutil.ReadAll(io.LimitReader(proxyResponse.Body, maxBodyBytes))
+	if err != nil {
+		return
+	}
+	bodyBytes = int64(len(bodyBuffer))
+
+	if bodyBytes < maxBodyBytes {
+		return
+	}
+
+	if proxyResponse.ContentLength >= 0 {
+		bodyBytes = proxyResponse.ContentLength
+		return
+	}
+
+	// The count is a lower bound if the proxy timeout expires while discarding.
+	discardedBytes, _ := io.Copy(ioutil.Discard, proxyResponse.Body)
+	bodyBytes += discardedBytes
+	return
+}
+
+// jsonProxy makes json mode proxy requests and checks and formats the responses.
+type jsonProxy struct {
+	proxyUpstream     *proxyUpstream
+	redactedProxyInfo *config.ProxyInfo
+	assertions        *proxyAssertions
+	renderer          *proxyOutputRenderer
+	retryPolicy       *proxyRetryPolicy
+	circuitBreaker    *circuitBreaker
+}
+
+func newJSONProxy(proxyUpstream *proxyUpstream) (*jsonProxy, error) {
+	proxyInfo := proxyUpstream.proxyInfo
+
+	assertions, err := newProxyAssertions(proxyInfo.Assertions)
+	if err != nil {
+		return nil, fmt.Errorf("error creating proxy ID %v assertions: %w", proxyInfo.ID, err)
+	}
+
+	renderer, err := newProxyOutputRenderer(proxyInfo)
+	if err != nil {
+		return nil, fmt.Errorf("error creating proxy ID %v renderer: %w", proxyInfo.ID, err)
+	}
+
+	return &jsonProxy{
+		proxyUpstream:     proxyUpstream,
+		redactedProxyInfo: proxyInfo.Redacted(),
+		assertions:        assertions,
+		renderer:          renderer,
+		retryPolicy:       newProxyRetryPolicy(proxyInfo),
+		circuitBreaker:    newCircuitBreaker(proxyInfo.CircuitBreaker),
+	}, nil
+}
 
-	httpRequest, err := http.NewRequestWithContext(ctx, "GET", proxyInfo.URL, nil)
+func (jsonProxy *jsonProxy) makeProxyAttempt(ctx context.Context) (response *proxyAPIResponse, err error) {
+	proxyUpstream := jsonProxy.proxyUpstream
+	proxyInfo := proxyUpstream.proxyInfo
+
+	httpRequest, err := proxyUpstream.newRequest(ctx)
 	if err != nil {
 		return
 	}
 
 	proxyStartTime := time.Now()
-	proxyResponse, err := http.DefaultClient.Do(httpRequest)
+	proxyResponse, err := proxyUpstream.client.Do(httpRequest)
 	proxyEndTime := time.Now()
 
 	if err != nil {
@@ -73,33 +158,192 @@ func makeProxyRequest(ctx context.Context, proxyInfo *config.ProxyInfo) (respons
 
 	defer proxyResponse.Body.Close()
 
-	bodyBuffer, err := ioutil.ReadAll(proxyResponse.Body)
+	bodyBuffer, bodyBytes, err := readProxyBody(proxyResponse, proxyInfo.MaxBodyBytes)
 	if err != nil {
 		return
 	}
 
-	proxyDuration := fmt.Sprintf("%.9f sec", proxyEndTime.Sub(proxyStartTime).Seconds())
+	latency := proxyEndTime.Sub(proxyStartTime)
+	proxyDuration := fmt.Sprintf("%.9f sec", latency.Seconds())
 
 	response = &proxyAPIResponse{
-		ProxyInfo:        proxyInfo,
+		ProxyInfo:        jsonProxy.redactedProxyInfo,
 		Now:              utils.FormatTime(proxyEndTime),
 		ProxyDuration:    proxyDuration,
 		ProxyStatus:      proxyResponse.Status,
+		ProxyStatusCode:  proxyResponse.StatusCode,
 		ProxyRespHeaders: proxyResponse.Header,
 		ProxyOutput:      string(bodyBuffer),
+		ProxyBodyBytes:   bodyBytes,
+		ProxyTruncated:   bodyBytes > int64(len(bodyBuffer)),
+		Verdict:          jsonProxy.assertions.check(proxyResponse.StatusCode, bodyBuffer, latency),
 	}
 	return
 }
 
-func proxyAPIHandlerFunc(proxyInfo config.ProxyInfo) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+// makeProxyRequest makes attempts until one succeeds or the retry policy gives up.
+// All attempts and the waits between them share the proxy timeout.
+func (jsonProxy *jsonProxy) makeProxyRequest(ctx context.Context) (response *proxyAPIResponse, attempts int, err error) {
+	proxyInfo := jsonProxy.proxyUpstream.proxyInfo
 
-		proxyAPIResponse, err := makeProxyRequest(ctx, &proxyInfo)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	ctx, cancel := context.WithTimeout(ctx, proxyTimeout(proxyInfo))
+	defer cancel()
+
+	for attempts = 1; ; attempts++ {
+		response, err = jsonProxy.makeProxyAttempt(ctx)
+
+		statusCode := 0
+		if response != nil {
+			statusCode = response.ProxyStatusCode
+		}
+		if (ctx.Err() != nil) || !jsonProxy.retryPolicy.shouldRetry(attempts, statusCode, err) {
+			return
+		}
+
+		backoff := jsonProxy.retryPolicy.backoff(attempts)
+		logging.FromContext(ctx).Info("proxy attempt failed, retrying",
+			"proxyID", proxyInfo.ID, "attempt", attempts, "backoff", backoff, "error", err, "status", statusCode)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// probeInBackground checks the upstream every probeInterval while the circuit is
+// open, taking the probe once openDuration has passed so the circuit closes without
+// waiting for a request, until ctx is done.
+func (jsonProxy *jsonProxy) probeInBackground(ctx context.Context) {
+	proxyInfo := jsonProxy.proxyUpstream.proxyInfo
+	circuitBreaker := jsonProxy.circuitBreaker
+
+	ticker := time.NewTicker(circuitBreaker.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
+		}
+
+		if _, probe := circuitBreaker.allow(); !probe {
+			continue
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, proxyTimeout(proxyInfo))
+		response, err := jsonProxy.makeProxyAttempt(attemptCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			circuitBreaker.abandonProbe()
+			return
+		}
+
+		verdict := &proxyVerdict{}
+		if err != nil {
+			verdict.fail("%v", err)
+		} else {
+			verdict = response.Verdict
+		}
+		healthRegistry.record(proxyInfo.ID, verdict)
+
+		success := (err == nil) && (response.ProxyStatusCode < http.StatusInternalServerError)
+		circuitBreaker.record(success)
+		if err != nil {
+			logging.Warn("proxy background probe failed",
+				"proxyID", proxyInfo.ID, "circuitBreakerState", circuitBreaker.snapshot().State, "error", err)
+		} else {
+			logging.Info("proxy background probe",
+				"proxyID", proxyInfo.ID, "upstreamStatus", response.ProxyStatusCode, "circuitBreakerState", circuitBreaker.snapshot().State)
+		}
+	}
+}
+
+// formatProxyOutput replaces ProxyOutput with the formatted body unless raw is true.
+func (response *proxyAPIResponse) formatProxyOutput(renderer *proxyOutputRenderer, raw bool) {
+	response.ProxyOutputFormat = outputFormatRaw
+	if raw {
+		return
+	}
+
+	output, format, err := renderer.render([]byte(response.ProxyOutput), response.ProxyRespHeaders.Get(utils.ContentTypeHeaderKey))
+	response.ProxyOutput = output
+	response.ProxyOutputFormat = format
+	if err != nil {
+		response.ProxyOutputError = err.Error()
+	}
+}
+
+// proxyErrorResponse returns the response when no upstream response is available.
+func (jsonProxy *jsonProxy) proxyErrorResponse(proxyError string, startTime time.Time) *proxyAPIResponse {
+	now := time.Now()
+	return &proxyAPIResponse{
+		ProxyInfo:         jsonProxy.redactedProxyInfo,
+		Now:               utils.FormatTime(now),
+		ProxyDuration:     fmt.Sprintf("%.9f sec", now.Sub(startTime).Seconds()),
+		ProxyOutputFormat: outputFormatRaw,
+		ProxyError:        proxyError,
+		Verdict: &proxyVerdict{
+			Reasons: []string{proxyError},
+		},
+	}
+}
+
+// apiHandlerFunc returns the proxy response with the body formatted for display,
+// or as is if the raw query parameter is true.  If no upstream response is available
+// the status is 502, or 503 if the circuit breaker is open.
+func (jsonProxy *jsonProxy) apiHandlerFunc() http.HandlerFunc {
+	proxyInfo := jsonProxy.proxyUpstream.proxyInfo
+	circuitBreaker := jsonProxy.circuitBreaker
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
+		startTime := time.Now()
+		statusCode := http.StatusOK
+
+		var proxyAPIResponse *proxyAPIResponse
+		if allowed, probe := circuitBreaker.allow(); !allowed {
+			proxyAPIResponse = jsonProxy.proxyErrorResponse("circuit breaker open", startTime)
+			statusCode = http.StatusServiceUnavailable
+			proxyFailures.Inc(proxyInfo.ID, proxyFailureCircuitBreakerOpen)
+			logger.Warn("proxy request rejected, circuit breaker open", "proxyID", proxyInfo.ID)
+		} else {
+			var attempts int
+			var err error
+			proxyAPIResponse, attempts, err = jsonProxy.makeProxyRequest(r.Context())
+			proxyRequestDuration.Observe(time.Since(startTime).Seconds(), proxyInfo.ID)
+			if errors.Is(r.Context().Err(), context.Canceled) {
+				if probe {
+					circuitBreaker.abandonProbe()
+				}
+				recordClientDisconnect(r.Context(), proxyInfo.ID)
+				return
+			}
+			failureReason := jsonProxyFailureReason(proxyAPIResponse, err)
+			if len(failureReason) > 0 {
+				proxyFailures.Inc(proxyInfo.ID, failureReason)
+			}
+			if err != nil {
+				proxyAPIResponse = jsonProxy.proxyErrorResponse(err.Error(), startTime)
+				statusCode = http.StatusBadGateway
+			}
+			proxyAPIResponse.Attempts = attempts
+			logger.Info("proxy request",
+				"proxyID", proxyInfo.ID, "upstreamStatus", proxyAPIResponse.ProxyStatusCode, "attempts", attempts,
+				"duration", time.Since(startTime).Seconds(), "failureReason", failureReason, "error", proxyAPIResponse.ProxyError)
+			circuitBreaker.record((err == nil) && (proxyAPIResponse.ProxyStatusCode < http.StatusInternalServerError))
+		}
+		proxyAPIResponse.CircuitBreaker = circuitBreaker.snapshot()
+		healthRegistry.record(proxyInfo.ID, proxyAPIResponse.Verdict)
+
+		if statusCode == http.StatusOK {
+			raw, _ := strconv.ParseBool(r.URL.Query().Get("raw"))
+			proxyAPIResponse.formatProxyOutput(jsonProxy.renderer, raw)
 		}
 
 		jsonText, err := json.Marshal(proxyAPIResponse)
@@ -110,19 +354,104 @@ func proxyAPIHandlerFunc(proxyInfo config.ProxyInfo) http.HandlerFunc {
 
 		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeApplicationJSON)
 		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+		w.WriteHeader(statusCode)
 		io.Copy(w, bytes.NewReader(jsonText))
 	}
 }
 
-func CreateProxyHandler(configuration *config.Configuration, serveMux *http.ServeMux) {
+// Proxies owns the upstream transports of the proxies of one configuration, and the
+// background probes of their circuit breakers.
+type Proxies struct {
+	upstreams   []*proxyUpstream
+	jsonProxies []*jsonProxy
+	cancel      context.CancelFunc
+}
+
+// Start starts the background probes.
+func (proxies *Proxies) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	proxies.cancel = cancel
+
+	for _, jsonProxy := range proxies.jsonProxies {
+		if jsonProxy.circuitBreaker != nil {
+			go jsonProxy.probeInBackground(ctx)
+		}
+	}
+}
+
+// Stop stops the background probes.
+func (proxies *Proxies) Stop() {
+	if proxies.cancel != nil {
+		proxies.cancel()
+	}
+}
+
+// Close closes the upstream connections.  It must only be called once the handlers
+// registered by CreateProxyHandler are no longer serving requests.
+func (proxies *Proxies) Close() {
+	for _, proxyUpstream := range proxies.upstreams {
+		proxyUpstream.close()
+	}
+}
+
+// CreateProxyHandler registers the proxy handlers on serveMux and returns the Proxies
+// owning their upstream transports.  The background probes are not started.
+func CreateProxyHandler(configuration *config.Configuration, serveMux *http.ServeMux) (*Proxies, error) {
+	proxies := &Proxies{}
+	circuitBreakers := make(map[string]*circuitBreaker)
+
+	// Close the transports already created if a proxy fails or serveMux.Handle panics.
+	created := false
+	defer func() {
+		if !created {
+			proxies.Close()
+		}
+	}()
+
 	for _, proxyInfo := range configuration.Proxies {
+		proxyInfo := proxyInfo
+		proxyUpstream, err := newProxyUpstream(&proxyInfo)
+		if err != nil {
+			return nil, err
+		}
+		proxies.upstreams = append(proxies.upstreams, proxyUpstream)
+
+		if proxyInfo.IsReverse() {
+			reverseProxyHandler, err := newReverseProxyHandler(proxyUpstream)
+			if err != nil {
+				return nil, err
+			}
+			serveMux.Handle(
+				reverseProxyHandler.pathPrefix,
+				reverseProxyHandler)
+			continue
+		}
+
+		jsonProxy, err := newJSONProxy(proxyUpstream)
+		if err != nil {
+			return nil, err
+		}
+		proxies.jsonProxies = append(proxies.jsonProxies, jsonProxy)
+		if jsonProxy.circuitBreaker != nil {
+			circuitBreakers[proxyInfo.ID] = jsonProxy.circuitBreaker
+		}
+
 		apiPath := "/api/proxies/" + proxyInfo.ID
 		htmlPath := "/proxies/" + proxyInfo.ID + ".html"
+		htmlHandlerFunc, err := proxyHTMLHandlerFunc(configuration, proxyInfo)
+		if err != nil {
+			return nil, err
+		}
 		serveMux.Handle(
 			htmlPath,
-			proxyHTMLHandlerFunc(configuration, proxyInfo))
+			htmlHandlerFunc)
 		serveMux.Handle(
 			apiPath,
-			proxyAPIHandlerFunc(proxyInfo))
+			jsonProxy.apiHandlerFunc())
 	}
+
+	serveMux.Handle("/api/health", healthAPIHandlerFunc(configuration, circuitBreakers))
+
+	created = true
+	return proxies, nil
 }
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/jsonpath"
+)
+
+const (
+	outputFormatRaw      = "raw"
+	outputFormatJSON     = "json"
+	outputFormatXML      = "xml"
+	outputFormatHTMLText = "htmlText"
+)
+
+var (
+	htmlScriptOrStyleRegexp = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	htmlCommentRegexp       = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlLineBreakTagRegexp  = regexp.MustCompile(`(?i)<(br|/?p|/?div|/?li|/?tr|/?h[1-6]|/?title|/?table|/?ul|/?ol)\b[^>]*>`)
+	htmlTagRegexp           = regexp.MustCompile(`(?s)<[^>]*>`)
+	horizontalSpaceRegexp   = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+// proxyOutputRenderer formats proxy response bodies for display according to their content type.
+type proxyOutputRenderer struct {
+	extractPath *jsonpath.Path
+}
+
+func newProxyOutputRenderer(proxyInfo *config.ProxyInfo) (*proxyOutputRenderer, error) {
+	renderer := &proxyOutputRenderer{}
+
+	if len(proxyInfo.Extract) > 0 {
+		extractPath, err := jsonpath.Parse(proxyInfo.Extract)
+		if err != nil {
+			return nil, err
+		}
+		renderer.extractPath = extractPath
+	}
+
+	return renderer, nil
+}
+
+// decodeJSON decodes body like json.Unmarshal, except that numbers are json.Number.
+func decodeJSON(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return value, nil
+}
+
+// renderJSON indents body, or the value at the extract path in body.  Numbers are kept
+// as they are in body rather than converted to float64, which would round large integers.
+func (renderer *proxyOutputRenderer) renderJSON(body []byte) (string, error) {
+	if renderer.extractPath == nil {
+		// Indenting the body as is also keeps the order of object keys.
+		var buffer bytes.Buffer
+		if err := json.Indent(&buffer, body, "", "  "); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buffer.String()), nil
+	}
+
+	value, err := decodeJSON(body)
+	if err != nil {
+		return "", err
+	}
+
+	extractedValue, ok := renderer.extractPath.Lookup(value)
+	if !ok {
+		return "", fmt.Errorf("json path %v not found", renderer.extractPath)
+	}
+
+	jsonText, err := json.MarshalIndent(extractedValue, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(jsonText), nil
+}
+
+// flattenXMLName returns name with its prefix as part of the local name, because
+// xml.Encoder treats Space as a namespace URL and would otherwise add xmlns attributes.
+func flattenXMLName(name xml.Name) xml.Name {
+	if len(name.Space) == 0 {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+func flattenXMLToken(token xml.Token) xml.Token {
+	switch typedToken := token.(type) {
+	case xml.StartElement:
+		typedToken.Name = flattenXMLName(typedToken.Name)
+		attrs := make([]xml.Attr, 0, len(typedToken.Attr))
+		for _, attr := range typedToken.Attr {
+			attr.Name = flattenXMLName(attr.Name)
+			attrs = append(attrs, attr)
+		}
+		typedToken.Attr = attrs
+		return typedToken
+
+	case xml.EndElement:
+		typedToken.Name = flattenXMLName(typedToken.Name)
+		return typedToken
+	}
+	return token
+}
+
+func renderXML(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	var buffer bytes.Buffer
+	encoder := xml.NewEncoder(&buffer)
+	encoder.Indent("", "  ")
+
+	for {
+		// RawToken leaves namespace prefixes as they are in the body.
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// Drop whitespace between elements so the encoder controls indentation.
+		if charData, ok := token.(xml.CharData); ok && (len(bytes.TrimSpace(charData)) == 0) {
+			continue
+		}
+
+		if err := encoder.EncodeToken(flattenXMLToken(xml.CopyToken(token))); err != nil {
+			return "", err
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// renderHTMLText returns the text of an HTML document without tags, scripts, or styles.
+func renderHTMLText(body []byte) string {
+	text := string(body)
+	text = htmlScriptOrStyleRegexp.ReplaceAllString(text, "")
+	text = htmlCommentRegexp.ReplaceAllString(text, "")
+	text = htmlLineBreakTagRegexp.ReplaceAllString(text, "\n")
+	text = htmlTagRegexp.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(horizontalSpaceRegexp.ReplaceAllString(line, " "))
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return (mediaType == "application/json") || strings.HasSuffix(mediaType, "+json")
+}
+
+func isXMLMediaType(mediaType string) bool {
+	return (mediaType == "application/xml") || (mediaType == "text/xml") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// render returns body formatted according to contentType, and the format used.
+// If the body cannot be formatted it is returned as is along with the error.
+func (renderer *proxyOutputRenderer) render(body []byte, contentType string) (output string, format string, err error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	// Bodies are parsed as JSON regardless of content type if extraction is configured.
+	case isJSONMediaType(mediaType) || (renderer.extractPath != nil):
+		output, err = renderer.renderJSON(body)
+		format = outputFormatJSON
+
+	case isXMLMediaType(mediaType):
+		output, err = renderXML(body)
+		format = outputFormatXML
+
+	case mediaType == "text/html":
+		output = renderHTMLText(body)
+		format = outputFormatHTMLText
+
+	default:
+		output = string(body)
+		format = outputFormatRaw
+	}
+
+	if err != nil {
+		output = string(body)
+		format = outputFormatRaw
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/aaronriekenberg/pi-web/config"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name        string
+		extract     string
+		body        string
+		contentType string
+		wantOutput  string
+		wantFormat  string
+	}{
+		{
+			name:        "json keeps key order and large integers",
+			body:        `{"z":1,"a":{"id":12345678901234567890}}` + "\n",
+			contentType: "application/json",
+			wantOutput:  "{\n  \"z\": 1,\n  \"a\": {\n    \"id\": 12345678901234567890\n  }\n}",
+			wantFormat:  outputFormatJSON,
+		},
+		{
+			name:        "extract keeps large integers",
+			extract:     "$.a.id",
+			body:        `{"a":{"id":12345678901234567890}}`,
+			contentType: "text/plain",
+			wantOutput:  "12345678901234567890",
+			wantFormat:  outputFormatJSON,
+		},
+		{
+			name:        "extract path not found",
+			extract:     "$.b",
+			body:        `{"a":1}`,
+			contentType: "application/json",
+			wantOutput:  `{"a":1}`,
+			wantFormat:  outputFormatRaw,
+		},
+		{
+			name:        "extract with trailing data",
+			extract:     "$.a",
+			body:        `{"a":1} {"a":2}`,
+			contentType: "application/json",
+			wantOutput:  `{"a":1} {"a":2}`,
+			wantFormat:  outputFormatRaw,
+		},
+		{
+			name:        "invalid json",
+			body:        `{"a":`,
+			contentType: "application/json",
+			wantOutput:  `{"a":`,
+			wantFormat:  outputFormatRaw,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			renderer, err := newProxyOutputRenderer(&config.ProxyInfo{Extract: test.extract})
+			if err != nil {
+				t.Fatalf("newProxyOutputRenderer error = %v", err)
+			}
+
+			output, format, _ := renderer.render([]byte(test.body), test.contentType)
+			if (output != test.wantOutput) || (format != test.wantFormat) {
+				t.Errorf("render = %q, %v, want %q, %v", output, format, test.wantOutput, test.wantFormat)
+			}
+		})
+	}
+}
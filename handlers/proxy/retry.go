@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+)
+
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// proxyRetryPolicy decides whether and when a failed json mode request is retried.
+type proxyRetryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// newProxyRetryPolicy returns a policy making a single attempt unless retries are
+// configured and the request method is idempotent.
+func newProxyRetryPolicy(proxyInfo *config.ProxyInfo) *proxyRetryPolicy {
+	retryInfo := proxyInfo.Retry
+	if (retryInfo == nil) || !idempotentMethods[proxyInfo.RequestMethod()] {
+		return &proxyRetryPolicy{
+			maxAttempts: 1,
+		}
+	}
+
+	// No wait can be longer than the proxy timeout shared by all attempts.
+	maxBackoff := proxyTimeout(proxyInfo)
+	if (retryInfo.MaxBackoffMilliseconds > 0) && (time.Duration(retryInfo.MaxBackoffMilliseconds)*time.Millisecond < maxBackoff) {
+		maxBackoff = time.Duration(retryInfo.MaxBackoffMilliseconds) * time.Millisecond
+	}
+
+	return &proxyRetryPolicy{
+		maxAttempts:    retryInfo.MaxAttempts,
+		initialBackoff: time.Duration(retryInfo.InitialBackoffMilliseconds) * time.Millisecond,
+		maxBackoff:     maxBackoff,
+	}
+}
+
+// shouldRetry reports whether another attempt should follow attempt number attempt.
+func (policy *proxyRetryPolicy) shouldRetry(attempt int, statusCode int, err error) bool {
+	if attempt >= policy.maxAttempts {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait after attempt number attempt, doubling from
+// initialBackoff up to maxBackoff.
+func (policy *proxyRetryPolicy) backoff(attempt int) time.Duration {
+	backoff := policy.initialBackoff
+	for i := 1; (i < attempt) && (backoff < policy.maxBackoff); i++ {
+		backoff *= 2
+	}
+	if backoff > policy.maxBackoff {
+		backoff = policy.maxBackoff
+	}
+	return backoff
+}
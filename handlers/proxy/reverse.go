@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+)
+
+// reverseProxyHandler forwards requests under pathPrefix to targetURL.
+type reverseProxyHandler struct {
+	proxyInfo      *config.ProxyInfo
+	proxyUpstream  *proxyUpstream
+	pathPrefix     string
+	targetURL      *url.URL
+	allowedMethods map[string]bool
+	reverseProxy   *httputil.ReverseProxy
+}
+
+// joinURLPath joins the target path and the request path after the prefix with a single slash.
+func joinURLPath(targetPath string, requestPath string) string {
+	switch {
+	case len(requestPath) == 0:
+		return targetPath
+	case strings.HasSuffix(targetPath, "/"):
+		return targetPath + strings.TrimPrefix(requestPath, "/")
+	default:
+		return targetPath + "/" + strings.TrimPrefix(requestPath, "/")
+	}
+}
+
+func forwardedProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func (handler *reverseProxyHandler) director(r *http.Request) {
+	targetURL := handler.targetURL
+
+	// Set X-Forwarded-* from the original request before it is rewritten.
+	// X-Forwarded-For is added by httputil.ReverseProxy.
+	r.Header.Set("X-Forwarded-Host", r.Host)
+	r.Header.Set("X-Forwarded-Proto", forwardedProto(r))
+	r.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(handler.pathPrefix, "/"))
+
+	r.URL.Scheme = targetURL.Scheme
+	r.URL.Host = targetURL.Host
+	r.URL.Path = joinURLPath(targetURL.Path, strings.TrimPrefix(r.URL.Path, handler.pathPrefix))
+	r.URL.RawPath = ""
+	switch {
+	case len(targetURL.RawQuery) == 0:
+	case len(r.URL.RawQuery) == 0:
+		r.URL.RawQuery = targetURL.RawQuery
+	default:
+		r.URL.RawQuery = targetURL.RawQuery + "&" + r.URL.RawQuery
+	}
+	r.Host = targetURL.Host
+	handler.proxyUpstream.setHeaders(r)
+
+	// Prevent net/http from adding a default User-Agent.
+	if _, ok := r.Header["User-Agent"]; !ok {
+		r.Header.Set("User-Agent", "")
+	}
+}
+
+// rewriteLocation maps a Location pointing into the target back under pathPrefix.
+// Locations pointing elsewhere are returned unchanged.
+func (handler *reverseProxyHandler) rewriteLocation(location string) string {
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	targetURL := handler.targetURL
+	if locationURL.IsAbs() || (len(locationURL.Host) > 0) {
+		if !strings.EqualFold(locationURL.Host, targetURL.Host) {
+			return location
+		}
+	} else if !strings.HasPrefix(locationURL.Path, "/") {
+		// Relative locations resolve correctly without rewriting.
+		return location
+	}
+
+	targetPath := strings.TrimSuffix(targetURL.Path, "/")
+	if !strings.HasPrefix(locationURL.Path, targetPath) {
+		return location
+	}
+
+	rewrittenURL := url.URL{
+		Path:     joinURLPath(handler.pathPrefix, strings.TrimPrefix(locationURL.Path, targetPath)),
+		RawQuery: locationURL.RawQuery,
+		Fragment: locationURL.Fragment,
+	}
+	return rewrittenURL.String()
+}
+
+func (handler *reverseProxyHandler) modifyResponse(response *http.Response) error {
+	if response.StatusCode >= http.StatusInternalServerError {
+		proxyFailures.Inc(handler.proxyInfo.ID, proxyFailureServerError)
+	}
+	if location := response.Header.Get("Location"); len(location) > 0 {
+		response.Header.Set("Location", handler.rewriteLocation(location))
+	}
+	return nil
+}
+
+func (handler *reverseProxyHandler) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).Warn("reverse proxy error", "proxyID", handler.proxyInfo.ID, "error", err)
+	proxyFailures.Inc(handler.proxyInfo.ID, proxyFailureError)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// AllowsMethod implements utils.MethodAllower.
+func (handler *reverseProxyHandler) AllowsMethod(method string) bool {
+	return handler.allowedMethods[method]
+}
+
+func (handler *reverseProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !handler.allowedMethods[r.Method] {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+	handler.reverseProxy.ServeHTTP(w, r)
+	proxyRequestDuration.Observe(time.Since(startTime).Seconds(), handler.proxyInfo.ID)
+}
+
+func newReverseProxyHandler(proxyUpstream *proxyUpstream) (*reverseProxyHandler, error) {
+	proxyInfo := proxyUpstream.proxyInfo
+
+	targetURL, err := url.Parse(proxyInfo.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing reverse proxy ID %v url: %w", proxyInfo.ID, err)
+	}
+
+	handler := &reverseProxyHandler{
+		proxyInfo:     proxyInfo,
+		proxyUpstream: proxyUpstream,
+		pathPrefix:    proxyInfo.ReversePathPrefix(),
+		targetURL:     targetURL,
+		allowedMethods: map[string]bool{
+			http.MethodGet:  true,
+			http.MethodHead: true,
+		},
+	}
+	for _, method := range proxyInfo.AllowedMethods {
+		handler.allowedMethods[method] = true
+	}
+
+	handler.reverseProxy = &httputil.ReverseProxy{
+		Director:       handler.director,
+		Transport:      proxyUpstream.transport,
+		ModifyResponse: handler.modifyResponse,
+		ErrorHandler:   handler.errorHandler,
+		// Flush immediately so streamed responses such as server-sent events are not delayed.
+		FlushInterval: -1,
+	}
+
+	return handler, nil
+}
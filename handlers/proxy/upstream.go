@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/lucas-clemente/quic-go/http3"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+)
+
+// proxyUpstream makes requests to a proxy's upstream with the configured
+// headers, TLS settings, and transport.
+type proxyUpstream struct {
+	proxyInfo *config.ProxyInfo
+	header    http.Header
+	host      string
+	transport http.RoundTripper
+	client    *http.Client
+}
+
+func newUpstreamTLSConfig(tlsInfo *config.ProxyTLSInfo) (*tls.Config, error) {
+	if tlsInfo == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: tlsInfo.InsecureSkipVerify,
+	}
+
+	if len(tlsInfo.CAFile) > 0 {
+		caPEM, err := os.ReadFile(tlsInfo.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %v", tlsInfo.CAFile)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	if len(tlsInfo.CertFile) > 0 {
+		certificate, err := tls.LoadX509KeyPair(tlsInfo.CertFile, tlsInfo.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+func newUpstreamTransport(proxyInfo *config.ProxyInfo) (http.RoundTripper, error) {
+	tlsConfig, err := newUpstreamTLSConfig(proxyInfo.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyInfo.HTTP3 {
+		return &http3.RoundTripper{
+			TLSClientConfig: tlsConfig,
+		}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	if unixSocket := proxyInfo.UnixSocket; len(unixSocket) > 0 {
+		// The URL host is still sent in the Host header, but every connection is to unixSocket.
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", unixSocket)
+		}
+	}
+
+	return transport, nil
+}
+
+func newProxyUpstream(proxyInfo *config.ProxyInfo) (*proxyUpstream, error) {
+	transport, err := newUpstreamTransport(proxyInfo)
+	if err != nil {
+		return nil, fmt.Errorf("error creating proxy ID %v transport: %w", proxyInfo.ID, err)
+	}
+
+	proxyUpstream := &proxyUpstream{
+		proxyInfo: proxyInfo,
+		header:    make(http.Header),
+		transport: transport,
+		client: &http.Client{
+			Transport: transport,
+		},
+	}
+
+	for name, value := range proxyInfo.Headers {
+		if strings.EqualFold(name, "Host") {
+			proxyUpstream.host = value
+			continue
+		}
+		proxyUpstream.header.Set(name, value)
+	}
+
+	if len(proxyInfo.AuthorizationFile) > 0 {
+		authorization, err := os.ReadFile(proxyInfo.AuthorizationFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading proxy ID %v authorization file: %w", proxyInfo.ID, err)
+		}
+		proxyUpstream.header.Set("Authorization", strings.TrimSpace(string(authorization)))
+	}
+
+	return proxyUpstream, nil
+}
+
+// close closes the connections of the transport.  It is called once no requests are
+// using the upstream.
+func (proxyUpstream *proxyUpstream) close() {
+	switch transport := proxyUpstream.transport.(type) {
+	case *http3.RoundTripper:
+		if err := transport.Close(); err != nil {
+			logging.Warn("error closing proxy transport", "proxyID", proxyUpstream.proxyInfo.ID, "error", err)
+		}
+	case *http.Transport:
+		transport.CloseIdleConnections()
+	}
+}
+
+// setHeaders sets the configured headers on r, and passes on the request ID unless
+// the configured headers replace it.
+func (proxyUpstream *proxyUpstream) setHeaders(r *http.Request) {
+	if requestID := logging.RequestIDFromContext(r.Context()); len(requestID) > 0 {
+		r.Header.Set(logging.RequestIDHeaderKey, requestID)
+	}
+	for name, values := range proxyUpstream.header {
+		r.Header[name] = values
+	}
+	if len(proxyUpstream.host) > 0 {
+		r.Host = proxyUpstream.host
+	}
+}
+
+// newRequest returns the json mode request to the upstream.
+func (proxyUpstream *proxyUpstream) newRequest(ctx context.Context) (*http.Request, error) {
+	proxyInfo := proxyUpstream.proxyInfo
+
+	var body io.Reader
+	if len(proxyInfo.Body) > 0 {
+		body = strings.NewReader(proxyInfo.Body)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, proxyInfo.RequestMethod(), proxyInfo.URL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyUpstream.setHeaders(httpRequest)
+	return httpRequest, nil
+}
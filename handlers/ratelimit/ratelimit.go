@@ -0,0 +1,347 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+// Clients idle this long are forgotten, unless their bucket takes longer to refill.
+const clientIdleTimeout = 10 * time.Minute
+
+// clientBucket is one client's token bucket and in flight requests within a class.
+type clientBucket struct {
+	tokens      float64
+	lastRefill  time.Time
+	lastRequest time.Time
+	inFlight    int
+}
+
+// classState is the clients and counts of a class.  It is kept by class name across
+// configuration reloads, so a reload does not give every client a full bucket or let
+// it exceed maxConcurrentRequests.
+type classState struct {
+	// Accessed atomically, and first so they are 64-bit aligned on 32-bit platforms.
+	allowed            uint64
+	rateLimited        uint64
+	concurrencyLimited uint64
+
+	mutex     sync.Mutex
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+var (
+	classStatesMutex sync.Mutex
+	classStates      = make(map[string]*classState)
+)
+
+// getClassStates returns the state of each class in rateLimitInfo, that of the class
+// with the same name in the current configuration if there is one.  The states are not
+// kept, and the states of removed classes are not forgotten, until adoptClassStates.
+func getClassStates(rateLimitInfo *config.RateLimitInfo) map[string]*classState {
+	classStatesMutex.Lock()
+	defer classStatesMutex.Unlock()
+
+	states := make(map[string]*classState, len(rateLimitInfo.Classes))
+	for i := range rateLimitInfo.Classes {
+		name := rateLimitInfo.Classes[i].Name
+		state, ok := classStates[name]
+		if !ok {
+			state = &classState{
+				clients:   make(map[string]*clientBucket),
+				lastSweep: time.Now(),
+			}
+		}
+		states[name] = state
+	}
+	return states
+}
+
+// adoptClassStates keeps states in place of those of the current configuration.
+func adoptClassStates(states map[string]*classState) {
+	classStatesMutex.Lock()
+	defer classStatesMutex.Unlock()
+
+	classStates = states
+}
+
+// class limits the requests of each client to the paths of one config.RateLimitClassInfo.
+type class struct {
+	name          string
+	paths         []utils.PathPattern
+	rate          float64
+	burst         float64
+	maxConcurrent int
+	idleTimeout   time.Duration
+
+	*classState
+}
+
+func newClass(classInfo *config.RateLimitClassInfo, classState *classState) *class {
+	class := &class{
+		name:          classInfo.Name,
+		rate:          classInfo.RequestsPerSecond,
+		burst:         float64(classInfo.Burst),
+		maxConcurrent: classInfo.MaxConcurrentRequests,
+		idleTimeout:   clientIdleTimeout,
+		classState:    classState,
+	}
+	if class.burst < 1 {
+		class.burst = 1
+	}
+	if class.rate > 0 {
+		if refillTime := time.Duration(class.burst / class.rate * float64(time.Second)); refillTime > class.idleTimeout {
+			class.idleTimeout = refillTime
+		}
+	}
+	for _, path := range classInfo.Paths {
+		class.paths = append(class.paths, utils.NewPathPattern(path))
+	}
+	return class
+}
+
+func (class *class) matches(path string) bool {
+	for _, pathPattern := range class.paths {
+		if pathPattern.Matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// refill adds the tokens earned since the last refill.  class.mutex must be held.
+func (class *class) refill(bucket *clientBucket, now time.Time) {
+	if class.rate > 0 {
+		elapsed := now.Sub(bucket.lastRefill).Seconds()
+		bucket.tokens = math.Min(class.burst, bucket.tokens+(elapsed*class.rate))
+	}
+	bucket.lastRefill = now
+}
+
+// sweep forgets idle clients.  class.mutex must be held.
+func (class *class) sweep(now time.Time) {
+	if now.Sub(class.lastSweep) < class.idleTimeout {
+		return
+	}
+	class.lastSweep = now
+
+	for clientIP, bucket := range class.clients {
+		if (bucket.inFlight == 0) && (now.Sub(bucket.lastRequest) >= class.idleTimeout) {
+			delete(class.clients, clientIP)
+		}
+	}
+}
+
+// take admits a request from clientIP if within its limits, returning a function
+// to call when the request completes.  Otherwise it returns how long to wait.
+func (class *class) take(clientIP string, now time.Time) (release func(), retryAfter time.Duration) {
+	class.mutex.Lock()
+	defer class.mutex.Unlock()
+
+	class.sweep(now)
+
+	bucket, ok := class.clients[clientIP]
+	if !ok {
+		bucket = &clientBucket{
+			tokens:     class.burst,
+			lastRefill: now,
+		}
+		class.clients[clientIP] = bucket
+	}
+	class.refill(bucket, now)
+	bucket.lastRequest = now
+
+	if (class.maxConcurrent > 0) && (bucket.inFlight >= class.maxConcurrent) {
+		atomic.AddUint64(&class.concurrencyLimited, 1)
+		return nil, time.Second
+	}
+
+	if class.rate > 0 {
+		if bucket.tokens < 1 {
+			atomic.AddUint64(&class.rateLimited, 1)
+			return nil, time.Duration((1 - bucket.tokens) / class.rate * float64(time.Second))
+		}
+		bucket.tokens--
+	}
+
+	atomic.AddUint64(&class.allowed, 1)
+	bucket.inFlight++
+
+	return func() {
+		class.mutex.Lock()
+		defer class.mutex.Unlock()
+		bucket.inFlight--
+	}, 0
+}
+
+// Limiter limits the requests of each client according to config.RateLimitInfo.
+type Limiter struct {
+	trustedProxies []*net.IPNet
+	classes        []*class
+	classStates    map[string]*classState
+	now            func() time.Time
+}
+
+func NewLimiter(rateLimitInfo *config.RateLimitInfo) (*Limiter, error) {
+	trustedProxies, err := utils.ParseIPNets(rateLimitInfo.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing rate limit trusted proxies: %w", err)
+	}
+
+	limiter := &Limiter{
+		trustedProxies: trustedProxies,
+		classStates:    getClassStates(rateLimitInfo),
+		now:            time.Now,
+	}
+	for i := range rateLimitInfo.Classes {
+		classInfo := &rateLimitInfo.Classes[i]
+		limiter.classes = append(limiter.classes, newClass(classInfo, limiter.classStates[classInfo.Name]))
+	}
+	return limiter, nil
+}
+
+// Adopt keeps the state of the classes of limiter across configuration reloads, in
+// place of that of the current configuration.  It is called once limiter is to limit
+// requests, so a configuration that is not used does not change the kept state.
+func (limiter *Limiter) Adopt() {
+	adoptClassStates(limiter.classStates)
+}
+
+// clientIP returns the IP address of the client making r.  For requests from trusted
+// proxies this is the last address in X-Forwarded-For that is not a trusted proxy.
+func (limiter *Limiter) clientIP(r *http.Request) string {
+	remoteIP := utils.RemoteIP(r.RemoteAddr)
+	if remoteIP == nil {
+		return r.RemoteAddr
+	}
+	if !utils.IPNetsContain(limiter.trustedProxies, remoteIP) {
+		return remoteIP.String()
+	}
+
+	clientIP := remoteIP
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+		if forwardedIP == nil {
+			break
+		}
+		clientIP = forwardedIP
+		if !utils.IPNetsContain(limiter.trustedProxies, forwardedIP) {
+			break
+		}
+	}
+	return clientIP.String()
+}
+
+func (limiter *Limiter) classForPath(path string) *class {
+	for _, class := range limiter.classes {
+		if class.matches(path) {
+			return class
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler passing requests within their client's limits to next.
+// Other requests get a 429 response with a Retry-After header.
+func (limiter *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := limiter.classForPath(r.URL.Path)
+		if class == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		clientIP := limiter.clientIP(r)
+		release, retryAfter := class.take(clientIP, limiter.now())
+		if release == nil {
+			retryAfterSeconds := int64(math.Ceil(retryAfter.Seconds()))
+			if retryAfterSeconds < 1 {
+				retryAfterSeconds = 1
+			}
+			logging.FromContext(r.Context()).Info("rate limited",
+				"clientIP", clientIP, "class", class.name, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfterSeconds, 10))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type ClientStats struct {
+	IP          string  `json:"ip"`
+	Tokens      float64 `json:"tokens"`
+	InFlight    int     `json:"inFlight"`
+	LastRequest string  `json:"lastRequest"`
+}
+
+type ClassStats struct {
+	Name                  string        `json:"name"`
+	RequestsPerSecond     float64       `json:"requestsPerSecond"`
+	Burst                 float64       `json:"burst"`
+	MaxConcurrentRequests int           `json:"maxConcurrentRequests"`
+	Allowed               uint64        `json:"allowed"`
+	RateLimited           uint64        `json:"rateLimited"`
+	ConcurrencyLimited    uint64        `json:"concurrencyLimited"`
+	Clients               []ClientStats `json:"clients"`
+}
+
+type Stats struct {
+	Now     string       `json:"now"`
+	Classes []ClassStats `json:"classes"`
+}
+
+// Stats returns the counts of each class and the current state of its clients.
+func (limiter *Limiter) Stats() *Stats {
+	now := limiter.now()
+	stats := &Stats{
+		Now: utils.FormatTime(now),
+	}
+
+	for _, class := range limiter.classes {
+		classStats := ClassStats{
+			Name:                  class.name,
+			RequestsPerSecond:     class.rate,
+			Burst:                 class.burst,
+			MaxConcurrentRequests: class.maxConcurrent,
+			Allowed:               atomic.LoadUint64(&class.allowed),
+			RateLimited:           atomic.LoadUint64(&class.rateLimited),
+			ConcurrencyLimited:    atomic.LoadUint64(&class.concurrencyLimited),
+			Clients:               []ClientStats{},
+		}
+
+		class.mutex.Lock()
+		for clientIP, bucket := range class.clients {
+			class.refill(bucket, now)
+			classStats.Clients = append(classStats.Clients, ClientStats{
+				IP:          clientIP,
+				Tokens:      math.Floor(bucket.tokens*100) / 100,
+				InFlight:    bucket.inFlight,
+				LastRequest: utils.FormatTime(bucket.lastRequest),
+			})
+		}
+		class.mutex.Unlock()
+
+		sort.Slice(classStats.Clients, func(i, j int) bool {
+			return classStats.Clients[i].IP < classStats.Clients[j].IP
+		})
+		stats.Classes = append(stats.Classes, classStats)
+	}
+
+	return stats
+}
@@ -0,0 +1,221 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+)
+
+// testClock is a clock for a Limiter that only moves when advanced.
+type testClock struct {
+	now time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *testClock) advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+// newTestLimiter returns a Limiter of classInfo using clock, with no state kept
+// from other tests.
+func newTestLimiter(t *testing.T, clock *testClock, classInfo config.RateLimitClassInfo) *Limiter {
+	t.Helper()
+
+	classStatesMutex.Lock()
+	classStates = make(map[string]*classState)
+	classStatesMutex.Unlock()
+
+	limiter, err := NewLimiter(&config.RateLimitInfo{
+		Classes: []config.RateLimitClassInfo{classInfo},
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter error = %v", err)
+	}
+	limiter.now = clock.Now
+	limiter.Adopt()
+	return limiter
+}
+
+// serve makes a request from remoteAddr to path, returning the response status
+// and Retry-After header.
+func serve(handler http.Handler, remoteAddr, path string) (int, string) {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, w.Header().Get("Retry-After")
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestBurst(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	limiter := newTestLimiter(t, clock, config.RateLimitClassInfo{
+		Name:              "api",
+		Paths:             []string{"/api/*"},
+		RequestsPerSecond: 1,
+		Burst:             3,
+	})
+	handler := limiter.Handler(okHandler)
+
+	for i := 0; i < 3; i++ {
+		if code, _ := serve(handler, "10.0.0.1:1234", "/api/x"); code != http.StatusOK {
+			t.Fatalf("request %v code = %v, want %v", i, code, http.StatusOK)
+		}
+	}
+	if code, retryAfter := serve(handler, "10.0.0.1:1234", "/api/x"); (code != http.StatusTooManyRequests) || (retryAfter != "1") {
+		t.Errorf("request over burst = %v, Retry-After %q, want %v, Retry-After \"1\"", code, retryAfter, http.StatusTooManyRequests)
+	}
+
+	if code, _ := serve(handler, "10.0.0.2:1234", "/api/x"); code != http.StatusOK {
+		t.Errorf("request from other client code = %v, want %v", code, http.StatusOK)
+	}
+	if code, _ := serve(handler, "10.0.0.1:1234", "/other"); code != http.StatusOK {
+		t.Errorf("request to unlimited path code = %v, want %v", code, http.StatusOK)
+	}
+
+	stats := limiter.Stats()
+	if classStats := stats.Classes[0]; (classStats.Allowed != 4) || (classStats.RateLimited != 1) {
+		t.Errorf("stats allowed = %v, rate limited = %v, want 4, 1", classStats.Allowed, classStats.RateLimited)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	limiter := newTestLimiter(t, clock, config.RateLimitClassInfo{
+		Name:              "api",
+		Paths:             []string{"/api/*"},
+		RequestsPerSecond: 2,
+		Burst:             2,
+	})
+	class := limiter.classes[0]
+
+	take := func() (bool, time.Duration) {
+		release, retryAfter := class.take("10.0.0.1", clock.Now())
+		if release != nil {
+			release()
+		}
+		return release != nil, retryAfter
+	}
+
+	take()
+	take()
+	if ok, retryAfter := take(); ok || (retryAfter != 500*time.Millisecond) {
+		t.Fatalf("take with empty bucket = %v, %v, want false, 500ms", ok, retryAfter)
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if ok, retryAfter := take(); ok || (retryAfter != 250*time.Millisecond) {
+		t.Errorf("take with half a token = %v, %v, want false, 250ms", ok, retryAfter)
+	}
+
+	clock.advance(250 * time.Millisecond)
+	if ok, _ := take(); !ok {
+		t.Errorf("take after refilling one token = false, want true")
+	}
+	if ok, _ := take(); ok {
+		t.Errorf("take after using refilled token = true, want false")
+	}
+
+	// A long wait refills no more than burst tokens.
+	clock.advance(time.Hour)
+	if tokens := limiter.Stats().Classes[0].Clients[0].Tokens; tokens != 2 {
+		t.Errorf("tokens after an hour = %v, want 2", tokens)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := take(); !ok {
+			t.Errorf("take %v after an hour = false, want true", i)
+		}
+	}
+	if ok, _ := take(); ok {
+		t.Errorf("take over burst after an hour = true, want false")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	limiter := newTestLimiter(t, clock, config.RateLimitClassInfo{
+		Name:                  "commands",
+		Paths:                 []string{"/commands/*"},
+		MaxConcurrentRequests: 1,
+	})
+	class := limiter.classes[0]
+
+	release, _ := class.take("10.0.0.1", clock.Now())
+	if release == nil {
+		t.Fatalf("first take = nil, want release")
+	}
+	if second, retryAfter := class.take("10.0.0.1", clock.Now()); (second != nil) || (retryAfter != time.Second) {
+		t.Errorf("take while in flight = %v, %v, want nil, 1s", second != nil, retryAfter)
+	}
+	release()
+	if third, _ := class.take("10.0.0.1", clock.Now()); third == nil {
+		t.Errorf("take after release = nil, want release")
+	}
+
+	if concurrencyLimited := limiter.Stats().Classes[0].ConcurrencyLimited; concurrencyLimited != 1 {
+		t.Errorf("stats concurrency limited = %v, want 1", concurrencyLimited)
+	}
+}
+
+func TestStateKeptAcrossReload(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	classInfo := config.RateLimitClassInfo{
+		Name:              "api",
+		Paths:             []string{"/api/*"},
+		RequestsPerSecond: 1,
+	}
+	limiter := newTestLimiter(t, clock, classInfo)
+	if release, _ := limiter.classes[0].take("10.0.0.1", clock.Now()); release == nil {
+		t.Fatalf("take = nil, want release")
+	}
+
+	reloaded, err := NewLimiter(&config.RateLimitInfo{
+		Classes: []config.RateLimitClassInfo{classInfo},
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter error = %v", err)
+	}
+	reloaded.now = clock.Now
+	if release, _ := reloaded.classes[0].take("10.0.0.1", clock.Now()); release != nil {
+		t.Errorf("take after reload = release, want nil")
+	}
+	if allowed := reloaded.Stats().Classes[0].Allowed; allowed != 1 {
+		t.Errorf("allowed after reload = %v, want 1", allowed)
+	}
+}
+
+func TestStateNotKeptUntilAdopted(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	classInfo := config.RateLimitClassInfo{
+		Name:              "api",
+		Paths:             []string{"/api/*"},
+		RequestsPerSecond: 1,
+	}
+	limiter := newTestLimiter(t, clock, classInfo)
+
+	// A rejected reload renaming the class must not forget the state of "api".
+	renamedClassInfo := classInfo
+	renamedClassInfo.Name = "renamed"
+	if _, err := NewLimiter(&config.RateLimitInfo{
+		Classes: []config.RateLimitClassInfo{renamedClassInfo},
+	}); err != nil {
+		t.Fatalf("NewLimiter error = %v", err)
+	}
+
+	reloaded, err := NewLimiter(&config.RateLimitInfo{
+		Classes: []config.RateLimitClassInfo{classInfo},
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter error = %v", err)
+	}
+	if reloaded.classes[0].classState != limiter.classes[0].classState {
+		t.Errorf("class state after a limiter that was not adopted is new, want the adopted state")
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/aaronriekenberg/pi-web/logging"
+
+	"github.com/felixge/httpsnoop"
+)
+
+const maxRequestIDLength = 128
+
+// validRequestID reports whether requestID from a client is safe to log and return.
+func validRequestID(requestID string) bool {
+	if (len(requestID) == 0) || (len(requestID) > maxRequestIDLength) {
+		return false
+	}
+	for _, c := range []byte(requestID) {
+		switch {
+		case (c >= 'a') && (c <= 'z'):
+		case (c >= 'A') && (c <= 'Z'):
+		case (c >= '0') && (c <= '9'):
+		case (c == '-') || (c == '_') || (c == '.') || (c == ':') || (c == '+') || (c == '/') || (c == '='):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var randomBytes [16]byte
+	if _, err := rand.Read(randomBytes[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand.Read error %v", err))
+	}
+	return hex.EncodeToString(randomBytes[:])
+}
+
+// requestProtocol returns the protocol of r as h3, h2, or the request protocol such as HTTP/1.1.
+func requestProtocol(r *http.Request) string {
+	switch r.ProtoMajor {
+	case 3:
+		return "h3"
+	case 2:
+		return "h2"
+	}
+	return r.Proto
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsVersion returns the TLS version of r, or "" if r did not use TLS.
+func tlsVersion(r *http.Request) string {
+	if (r.TLS == nil) || (r.TLS.Version == 0) {
+		return ""
+	}
+	if name, ok := tlsVersionNames[r.TLS.Version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", r.TLS.Version)
+}
+
+// requestLogHandler gives each request an ID, taken from the X-Request-ID header if
+// valid, returns it in the response, and adds it to log entries written with the request
+// context.  If logRequests is true an access log entry is written for each request.
+func requestLogHandler(logRequests bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(logging.RequestIDHeaderKey)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeaderKey, requestID)
+		r = r.WithContext(logging.ContextWithRequestID(r.Context(), requestID))
+
+		if !logRequests {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestMetrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		logging.Access().Info("request",
+			"requestID", requestID,
+			"remoteAddr", r.RemoteAddr,
+			"method", r.Method,
+			"host", r.Host,
+			"uri", r.RequestURI,
+			"protocol", requestProtocol(r),
+			"tlsVersion", tlsVersion(r),
+			"status", requestMetrics.Code,
+			"bytes", requestMetrics.Written,
+			"duration", requestMetrics.Duration.Seconds(),
+			"referer", r.Referer(),
+			"userAgent", r.UserAgent())
+	})
+}
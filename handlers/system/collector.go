@@ -0,0 +1,506 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProcRoot = "/proc"
+	defaultSysRoot  = "/sys"
+
+	// minSampleInterval is the shortest interval CPU usage and network rates are
+	// measured over, so clients polling at the same time do not shorten it.
+	minSampleInterval = time.Second
+)
+
+type loadAverage struct {
+	OneMinute      float64 `json:"oneMinute"`
+	FiveMinutes    float64 `json:"fiveMinutes"`
+	FifteenMinutes float64 `json:"fifteenMinutes"`
+	RunnableTasks  int     `json:"runnableTasks"`
+	Tasks          int     `json:"tasks"`
+}
+
+type memory struct {
+	TotalBytes     uint64  `json:"totalBytes"`
+	FreeBytes      uint64  `json:"freeBytes"`
+	AvailableBytes uint64  `json:"availableBytes"`
+	BuffersBytes   uint64  `json:"buffersBytes"`
+	CachedBytes    uint64  `json:"cachedBytes"`
+	SwapTotalBytes uint64  `json:"swapTotalBytes"`
+	SwapFreeBytes  uint64  `json:"swapFreeBytes"`
+	UsedPercent    float64 `json:"usedPercent"`
+}
+
+type cpuUsage struct {
+	Name         string  `json:"name"`
+	UsagePercent float64 `json:"usagePercent"`
+}
+
+// cpuStatus is CPU usage over IntervalSeconds, or since boot if IntervalSeconds is 0.
+type cpuStatus struct {
+	IntervalSeconds float64    `json:"intervalSeconds"`
+	Total           cpuUsage   `json:"total"`
+	CPUs            []cpuUsage `json:"cpus"`
+}
+
+type temperature struct {
+	Zone    string  `json:"zone"`
+	Type    string  `json:"type,omitempty"`
+	Celsius float64 `json:"celsius"`
+}
+
+type filesystem struct {
+	Mount          string  `json:"mount"`
+	TotalBytes     uint64  `json:"totalBytes"`
+	FreeBytes      uint64  `json:"freeBytes"`
+	AvailableBytes uint64  `json:"availableBytes"`
+	UsedPercent    float64 `json:"usedPercent"`
+}
+
+// networkInterface has counters since boot, and rates since the previous sample.
+type networkInterface struct {
+	Name                   string  `json:"name"`
+	ReceiveBytes           uint64  `json:"receiveBytes"`
+	ReceivePackets         uint64  `json:"receivePackets"`
+	ReceiveErrors          uint64  `json:"receiveErrors"`
+	TransmitBytes          uint64  `json:"transmitBytes"`
+	TransmitPackets        uint64  `json:"transmitPackets"`
+	TransmitErrors         uint64  `json:"transmitErrors"`
+	ReceiveBytesPerSecond  float64 `json:"receiveBytesPerSecond"`
+	TransmitBytesPerSecond float64 `json:"transmitBytesPerSecond"`
+}
+
+// systemStatus is what was collected.  A section that could not be read is omitted and
+// its error added to Errors.
+type systemStatus struct {
+	LoadAverage       *loadAverage       `json:"loadAverage,omitempty"`
+	Memory            *memory            `json:"memory,omitempty"`
+	CPU               *cpuStatus         `json:"cpu,omitempty"`
+	Temperatures      []temperature      `json:"temperatures"`
+	Filesystems       []filesystem       `json:"filesystems"`
+	NetworkInterfaces []networkInterface `json:"networkInterfaces"`
+	Errors            []string           `json:"errors,omitempty"`
+}
+
+func (systemStatus *systemStatus) addError(section string, err error) {
+	systemStatus.Errors = append(systemStatus.Errors, fmt.Sprintf("%v: %v", section, err))
+}
+
+// cpuTimes are the jiffies a CPU has spent busy and in total since boot.
+type cpuTimes struct {
+	name  string
+	busy  uint64
+	total uint64
+}
+
+type networkCounters struct {
+	receiveBytes  uint64
+	transmitBytes uint64
+}
+
+// sample is the counters CPU usage and network rates are computed from.
+type sample struct {
+	time     time.Time
+	cpuTimes []cpuTimes
+	network  map[string]networkCounters
+}
+
+// collector reads system status from files under procRoot and sysRoot.
+type collector struct {
+	procRoot string
+	sysRoot  string
+	mounts   []string
+
+	mutex          sync.Mutex
+	previousSample *sample
+}
+
+func newCollector(procRoot string, sysRoot string, mounts []string) *collector {
+	if len(procRoot) == 0 {
+		procRoot = defaultProcRoot
+	}
+	if len(sysRoot) == 0 {
+		sysRoot = defaultSysRoot
+	}
+	return &collector{
+		procRoot: procRoot,
+		sysRoot:  sysRoot,
+		mounts:   mounts,
+	}
+}
+
+func readFields(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(content)), nil
+}
+
+// readLines calls handleLine with each line of path until it returns an error.
+func readLines(path string, handleLine func(line string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := handleLine(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseUint(field string) (uint64, error) {
+	return strconv.ParseUint(field, 10, 64)
+}
+
+func percent(part float64, whole float64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return 100 * part / whole
+}
+
+// readLoadAverage reads /proc/loadavg, such as "0.15 0.10 0.05 1/123 4567".
+func (collector *collector) readLoadAverage() (*loadAverage, error) {
+	fields, err := readFields(filepath.Join(collector.procRoot, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("expected at least 4 fields, got %v", len(fields))
+	}
+
+	loadAverage := &loadAverage{}
+	for i, value := range []*float64{&loadAverage.OneMinute, &loadAverage.FiveMinutes, &loadAverage.FifteenMinutes} {
+		if *value, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return nil, err
+		}
+	}
+
+	tasks := strings.Split(fields[3], "/")
+	if len(tasks) != 2 {
+		return nil, fmt.Errorf("invalid tasks field %q", fields[3])
+	}
+	if loadAverage.RunnableTasks, err = strconv.Atoi(tasks[0]); err != nil {
+		return nil, err
+	}
+	if loadAverage.Tasks, err = strconv.Atoi(tasks[1]); err != nil {
+		return nil, err
+	}
+
+	return loadAverage, nil
+}
+
+// readMemory reads /proc/meminfo, which has lines such as "MemTotal:  948012 kB".
+func (collector *collector) readMemory() (*memory, error) {
+	values := make(map[string]uint64)
+	err := readLines(filepath.Join(collector.procRoot, "meminfo"), func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil
+		}
+		value, err := parseUint(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid line %q", line)
+		}
+		if (len(fields) > 2) && (fields[2] == "kB") {
+			value *= 1024
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	totalBytes, ok := values["MemTotal"]
+	if !ok {
+		return nil, fmt.Errorf("MemTotal not found")
+	}
+
+	memory := &memory{
+		TotalBytes:     totalBytes,
+		FreeBytes:      values["MemFree"],
+		BuffersBytes:   values["Buffers"],
+		CachedBytes:    values["Cached"],
+		SwapTotalBytes: values["SwapTotal"],
+		SwapFreeBytes:  values["SwapFree"],
+	}
+	// MemAvailable is missing before Linux 3.14.
+	if availableBytes, ok := values["MemAvailable"]; ok {
+		memory.AvailableBytes = availableBytes
+	} else {
+		memory.AvailableBytes = memory.FreeBytes + memory.BuffersBytes + memory.CachedBytes
+	}
+	if memory.AvailableBytes < memory.TotalBytes {
+		memory.UsedPercent = percent(float64(memory.TotalBytes-memory.AvailableBytes), float64(memory.TotalBytes))
+	}
+
+	return memory, nil
+}
+
+// readCPUTimes reads the cpu lines of /proc/stat, such as
+// "cpu0 user nice system idle iowait irq softirq steal guest guest_nice".
+// Guest time is already counted in user and nice time.
+func (collector *collector) readCPUTimes() ([]cpuTimes, error) {
+	var cpuTimesList []cpuTimes
+	err := readLines(filepath.Join(collector.procRoot, "stat"), func(line string) error {
+		fields := strings.Fields(line)
+		if (len(fields) < 5) || !strings.HasPrefix(fields[0], "cpu") {
+			return nil
+		}
+
+		cpuTimes := cpuTimes{
+			name: fields[0],
+		}
+		for i, field := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			value, err := parseUint(field)
+			if err != nil {
+				return fmt.Errorf("invalid line %q", line)
+			}
+			cpuTimes.total += value
+			// Idle and iowait are not busy.
+			if (i != 3) && (i != 4) {
+				cpuTimes.busy += value
+			}
+		}
+		cpuTimesList = append(cpuTimesList, cpuTimes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cpuTimesList) == 0 {
+		return nil, fmt.Errorf("no cpu lines found")
+	}
+	return cpuTimesList, nil
+}
+
+// readNetworkInterfaces reads /proc/net/dev, which has two header lines then a line per
+// interface such as "eth0: <receive bytes> <packets> <errs> <drop> <fifo> <frame>
+// <compressed> <multicast> <transmit bytes> <packets> <errs> ...".
+func (collector *collector) readNetworkInterfaces() ([]networkInterface, error) {
+	var networkInterfaces []networkInterface
+	lineNumber := 0
+	err := readLines(filepath.Join(collector.procRoot, "net", "dev"), func(line string) error {
+		lineNumber++
+		if lineNumber <= 2 {
+			return nil
+		}
+
+		colonIndex := strings.IndexByte(line, ':')
+		if colonIndex < 0 {
+			return fmt.Errorf("invalid line %q", line)
+		}
+		fields := strings.Fields(line[colonIndex+1:])
+		if len(fields) < 11 {
+			return fmt.Errorf("invalid line %q", line)
+		}
+
+		networkInterface := networkInterface{
+			Name: strings.TrimSpace(line[:colonIndex]),
+		}
+		for _, counter := range []struct {
+			field int
+			value *uint64
+		}{
+			{field: 0, value: &networkInterface.ReceiveBytes},
+			{field: 1, value: &networkInterface.ReceivePackets},
+			{field: 2, value: &networkInterface.ReceiveErrors},
+			{field: 8, value: &networkInterface.TransmitBytes},
+			{field: 9, value: &networkInterface.TransmitPackets},
+			{field: 10, value: &networkInterface.TransmitErrors},
+		} {
+			var err error
+			if *counter.value, err = parseUint(fields[counter.field]); err != nil {
+				return fmt.Errorf("invalid line %q", line)
+			}
+		}
+		networkInterfaces = append(networkInterfaces, networkInterface)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return networkInterfaces, nil
+}
+
+// readTemperatures reads the temp file of each thermal zone, in millidegrees Celsius.
+// Zones without a readable temperature, such as disabled sensors, are skipped.
+func (collector *collector) readTemperatures() ([]temperature, error) {
+	zonePaths, err := filepath.Glob(filepath.Join(collector.sysRoot, "class", "thermal", "thermal_zone*"))
+	if err != nil {
+		return nil, err
+	}
+
+	temperatures := []temperature{}
+	for _, zonePath := range zonePaths {
+		fields, err := readFields(filepath.Join(zonePath, "temp"))
+		if (err != nil) || (len(fields) == 0) {
+			continue
+		}
+		milliCelsius, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		temperature := temperature{
+			Zone:    filepath.Base(zonePath),
+			Celsius: float64(milliCelsius) / 1000,
+		}
+		if typeFields, err := readFields(filepath.Join(zonePath, "type")); (err == nil) && (len(typeFields) > 0) {
+			temperature.Type = strings.Join(typeFields, " ")
+		}
+		temperatures = append(temperatures, temperature)
+	}
+	return temperatures, nil
+}
+
+// readFilesystems returns the usage of each mount.  UsedPercent is of the space
+// available to unprivileged users, as shown by df.
+func (collector *collector) readFilesystems(systemStatus *systemStatus) []filesystem {
+	filesystems := []filesystem{}
+	for _, mount := range collector.mounts {
+		totalBytes, freeBytes, availableBytes, err := statfs(mount)
+		if err != nil {
+			systemStatus.addError("filesystem "+mount, err)
+			continue
+		}
+		usedBytes := totalBytes - freeBytes
+		filesystems = append(filesystems, filesystem{
+			Mount:          mount,
+			TotalBytes:     totalBytes,
+			FreeBytes:      freeBytes,
+			AvailableBytes: availableBytes,
+			UsedPercent:    percent(float64(usedBytes), float64(usedBytes+availableBytes)),
+		})
+	}
+	return filesystems
+}
+
+func cpuUsagePercent(current cpuTimes, previous cpuTimes) float64 {
+	if (current.total < previous.total) || (current.busy < previous.busy) {
+		return 0
+	}
+	return percent(float64(current.busy-previous.busy), float64(current.total-previous.total))
+}
+
+// newCPUStatus returns CPU usage since previousSample, or since boot if it is nil.
+func newCPUStatus(currentSample *sample, previousSample *sample) *cpuStatus {
+	previousCPUTimes := make(map[string]cpuTimes)
+	cpuStatus := &cpuStatus{}
+	if previousSample != nil {
+		cpuStatus.IntervalSeconds = currentSample.time.Sub(previousSample.time).Seconds()
+		for _, cpuTimes := range previousSample.cpuTimes {
+			previousCPUTimes[cpuTimes.name] = cpuTimes
+		}
+	}
+
+	for _, cpuTimes := range currentSample.cpuTimes {
+		cpuUsage := cpuUsage{
+			Name:         cpuTimes.name,
+			UsagePercent: cpuUsagePercent(cpuTimes, previousCPUTimes[cpuTimes.name]),
+		}
+		// The cpu line without a number is the total of all CPUs.
+		if cpuTimes.name == "cpu" {
+			cpuStatus.Total = cpuUsage
+		} else {
+			cpuStatus.CPUs = append(cpuStatus.CPUs, cpuUsage)
+		}
+	}
+	return cpuStatus
+}
+
+func rate(current uint64, previous uint64, intervalSeconds float64) float64 {
+	if (current < previous) || (intervalSeconds <= 0) {
+		return 0
+	}
+	return float64(current-previous) / intervalSeconds
+}
+
+// addNetworkRates sets the rates of networkInterfaces since previousSample.
+func addNetworkRates(networkInterfaces []networkInterface, currentSample *sample, previousSample *sample) {
+	if previousSample == nil {
+		return
+	}
+	intervalSeconds := currentSample.time.Sub(previousSample.time).Seconds()
+	for i := range networkInterfaces {
+		networkInterface := &networkInterfaces[i]
+		previous, ok := previousSample.network[networkInterface.Name]
+		if !ok {
+			continue
+		}
+		networkInterface.ReceiveBytesPerSecond = rate(networkInterface.ReceiveBytes, previous.receiveBytes, intervalSeconds)
+		networkInterface.TransmitBytesPerSecond = rate(networkInterface.TransmitBytes, previous.transmitBytes, intervalSeconds)
+	}
+}
+
+// collect reads the current system status.  CPU usage and network rates are measured
+// since the previous sample, which is replaced once it is minSampleInterval old.
+func (collector *collector) collect() *systemStatus {
+	systemStatus := &systemStatus{}
+
+	var err error
+	if systemStatus.LoadAverage, err = collector.readLoadAverage(); err != nil {
+		systemStatus.addError("loadavg", err)
+	}
+
+	if systemStatus.Memory, err = collector.readMemory(); err != nil {
+		systemStatus.addError("meminfo", err)
+	}
+
+	if systemStatus.Temperatures, err = collector.readTemperatures(); err != nil {
+		systemStatus.addError("thermal", err)
+	}
+
+	systemStatus.Filesystems = collector.readFilesystems(systemStatus)
+
+	currentSample := &sample{
+		time:    time.Now(),
+		network: make(map[string]networkCounters),
+	}
+
+	if currentSample.cpuTimes, err = collector.readCPUTimes(); err != nil {
+		systemStatus.addError("stat", err)
+	}
+
+	if systemStatus.NetworkInterfaces, err = collector.readNetworkInterfaces(); err != nil {
+		systemStatus.addError("net/dev", err)
+	}
+	for _, networkInterface := range systemStatus.NetworkInterfaces {
+		currentSample.network[networkInterface.Name] = networkCounters{
+			receiveBytes:  networkInterface.ReceiveBytes,
+			transmitBytes: networkInterface.TransmitBytes,
+		}
+	}
+
+	collector.mutex.Lock()
+	previousSample := collector.previousSample
+	if (previousSample == nil) || (currentSample.time.Sub(previousSample.time) >= minSampleInterval) {
+		collector.previousSample = currentSample
+	}
+	collector.mutex.Unlock()
+
+	if len(currentSample.cpuTimes) > 0 {
+		systemStatus.CPU = newCPUStatus(currentSample, previousSample)
+	}
+	addNetworkRates(systemStatus.NetworkInterfaces, currentSample, previousSample)
+
+	return systemStatus
+}
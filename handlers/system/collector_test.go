@@ -0,0 +1,345 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCollector() *collector {
+	return newCollector(filepath.Join("testdata", "proc"), filepath.Join("testdata", "sys"), nil)
+}
+
+// newTempCollector returns a collector of a temporary proc root containing files,
+// keyed by path relative to the root.
+func newTempCollector(t *testing.T, files map[string]string) *collector {
+	t.Helper()
+
+	procRoot := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(procRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newCollector(procRoot, t.TempDir(), nil)
+}
+
+// wantError fails t unless err is not nil and contains want.
+func wantError(t *testing.T, err error, want string) {
+	t.Helper()
+
+	if err == nil {
+		t.Errorf("error = nil, want %q", want)
+	} else if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestReadLoadAverage(t *testing.T) {
+	got, err := newTestCollector().readLoadAverage()
+	if err != nil {
+		t.Fatalf("readLoadAverage error = %v", err)
+	}
+	want := &loadAverage{
+		OneMinute:      0.52,
+		FiveMinutes:    0.58,
+		FifteenMinutes: 0.59,
+		RunnableTasks:  2,
+		Tasks:          312,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readLoadAverage = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadLoadAverageErrors(t *testing.T) {
+	tests := []struct {
+		loadavg   string
+		wantError string
+	}{
+		{"0.52 0.58 0.59", "expected at least 4 fields, got 3"},
+		{"0.52 x 0.59 2/312 12345", `parsing "x"`},
+		{"0.52 0.58 0.59 2 12345", `invalid tasks field "2"`},
+		{"0.52 0.58 0.59 2/x 12345", `parsing "x"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.loadavg, func(t *testing.T) {
+			_, err := newTempCollector(t, map[string]string{"loadavg": test.loadavg}).readLoadAverage()
+			wantError(t, err, test.wantError)
+		})
+	}
+}
+
+func TestReadMemory(t *testing.T) {
+	got, err := newTestCollector().readMemory()
+	if err != nil {
+		t.Fatalf("readMemory error = %v", err)
+	}
+	want := &memory{
+		TotalBytes:     3884376 * 1024,
+		FreeBytes:      285216 * 1024,
+		AvailableBytes: 2760140 * 1024,
+		BuffersBytes:   127492 * 1024,
+		CachedBytes:    2223336 * 1024,
+		SwapTotalBytes: 102396 * 1024,
+		SwapFreeBytes:  102396 * 1024,
+		UsedPercent:    percent((3884376-2760140)*1024, 3884376*1024),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readMemory = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadMemoryWithoutMemAvailable(t *testing.T) {
+	memory, err := newTempCollector(t, map[string]string{"meminfo": "" +
+		"MemTotal:        1000 kB\n" +
+		"MemFree:          100 kB\n" +
+		"Buffers:          200 kB\n" +
+		"Cached:           300 kB\n",
+	}).readMemory()
+	if err != nil {
+		t.Fatalf("readMemory error = %v", err)
+	}
+	if want := uint64(600 * 1024); memory.AvailableBytes != want {
+		t.Errorf("AvailableBytes = %v, want %v", memory.AvailableBytes, want)
+	}
+	if want := 40.0; memory.UsedPercent != want {
+		t.Errorf("UsedPercent = %v, want %v", memory.UsedPercent, want)
+	}
+}
+
+func TestReadMemoryErrors(t *testing.T) {
+	_, err := newTempCollector(t, map[string]string{"meminfo": "MemFree: 100 kB\n"}).readMemory()
+	wantError(t, err, "MemTotal not found")
+
+	_, err = newTempCollector(t, map[string]string{"meminfo": "MemTotal: x kB\n"}).readMemory()
+	wantError(t, err, `invalid line "MemTotal: x kB"`)
+}
+
+func TestReadCPUTimes(t *testing.T) {
+	cpuTimesList, err := newTestCollector().readCPUTimes()
+	if err != nil {
+		t.Fatalf("readCPUTimes error = %v", err)
+	}
+	// Guest time, counted in user time, and lines other than cpu lines are ignored.
+	want := []cpuTimes{
+		{name: "cpu", busy: 300, total: 2000},
+		{name: "cpu0", busy: 225, total: 650},
+		{name: "cpu1", busy: 75, total: 1350},
+	}
+	if !reflect.DeepEqual(cpuTimesList, want) {
+		t.Errorf("readCPUTimes = %+v, want %+v", cpuTimesList, want)
+	}
+}
+
+func TestReadCPUTimesErrors(t *testing.T) {
+	_, err := newTempCollector(t, map[string]string{"stat": "intr 1 2 3\n"}).readCPUTimes()
+	wantError(t, err, "no cpu lines found")
+
+	_, err = newTempCollector(t, map[string]string{"stat": "cpu 1 2 x 4\n"}).readCPUTimes()
+	wantError(t, err, `invalid line "cpu 1 2 x 4"`)
+}
+
+func TestReadNetworkInterfaces(t *testing.T) {
+	networkInterfaces, err := newTestCollector().readNetworkInterfaces()
+	if err != nil {
+		t.Fatalf("readNetworkInterfaces error = %v", err)
+	}
+	want := []networkInterface{
+		{
+			Name:            "lo",
+			ReceiveBytes:    1000,
+			ReceivePackets:  10,
+			TransmitBytes:   1000,
+			TransmitPackets: 10,
+		},
+		{
+			Name:            "eth0",
+			ReceiveBytes:    123556789,
+			ReceivePackets:  5000,
+			ReceiveErrors:   1,
+			TransmitBytes:   987654,
+			TransmitPackets: 4000,
+			TransmitErrors:  2,
+		},
+	}
+	if !reflect.DeepEqual(networkInterfaces, want) {
+		t.Errorf("readNetworkInterfaces = %+v, want %+v", networkInterfaces, want)
+	}
+}
+
+func TestReadNetworkInterfacesErrors(t *testing.T) {
+	header := "Inter-|   Receive\n face |bytes\n"
+
+	_, err := newTempCollector(t, map[string]string{"net/dev": header + "eth0 1 2 3\n"}).readNetworkInterfaces()
+	wantError(t, err, `invalid line "eth0 1 2 3"`)
+
+	_, err = newTempCollector(t, map[string]string{"net/dev": header + "eth0: 1 2 3\n"}).readNetworkInterfaces()
+	wantError(t, err, `invalid line "eth0: 1 2 3"`)
+
+	_, err = newTempCollector(t, map[string]string{"net/dev": header + "eth0: x 0 0 0 0 0 0 0 0 0 0\n"}).readNetworkInterfaces()
+	wantError(t, err, `invalid line "eth0: x 0 0 0 0 0 0 0 0 0 0"`)
+}
+
+func TestReadTemperatures(t *testing.T) {
+	temperatures, err := newTestCollector().readTemperatures()
+	if err != nil {
+		t.Fatalf("readTemperatures error = %v", err)
+	}
+	// thermal_zone1 has no temp and is skipped, thermal_zone2 has no type.
+	want := []temperature{
+		{Zone: "thermal_zone0", Type: "cpu-thermal", Celsius: 48.312},
+		{Zone: "thermal_zone2", Celsius: -5.25},
+	}
+	if !reflect.DeepEqual(temperatures, want) {
+		t.Errorf("readTemperatures = %+v, want %+v", temperatures, want)
+	}
+}
+
+func TestReadTemperaturesWithoutZones(t *testing.T) {
+	temperatures, err := newTempCollector(t, nil).readTemperatures()
+	if err != nil {
+		t.Fatalf("readTemperatures error = %v", err)
+	}
+	if (temperatures == nil) || (len(temperatures) != 0) {
+		t.Errorf("readTemperatures = %#v, want empty", temperatures)
+	}
+}
+
+func TestCPUUsagePercent(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  cpuTimes
+		previous cpuTimes
+		want     float64
+	}{
+		{"since boot", cpuTimes{busy: 300, total: 2000}, cpuTimes{}, 15},
+		{"interval", cpuTimes{busy: 400, total: 2200}, cpuTimes{busy: 300, total: 2000}, 50},
+		{"idle", cpuTimes{busy: 300, total: 2200}, cpuTimes{busy: 300, total: 2000}, 0},
+		{"no time passed", cpuTimes{busy: 300, total: 2000}, cpuTimes{busy: 300, total: 2000}, 0},
+		{"total wrapped", cpuTimes{busy: 400, total: 100}, cpuTimes{busy: 300, total: 2000}, 0},
+		{"busy wrapped", cpuTimes{busy: 10, total: 2200}, cpuTimes{busy: 300, total: 2000}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cpuUsagePercent(test.current, test.previous); got != test.want {
+				t.Errorf("cpuUsagePercent(%+v, %+v) = %v, want %v", test.current, test.previous, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name            string
+		current         uint64
+		previous        uint64
+		intervalSeconds float64
+		want            float64
+	}{
+		{"rate", 3000, 1000, 2, 1000},
+		{"unchanged", 1000, 1000, 2, 0},
+		{"counter wrapped", 100, 1000, 2, 0},
+		{"32-bit counter wrapped", 10, 1<<32 - 10, 2, 0},
+		{"zero interval", 3000, 1000, 0, 0},
+		{"negative interval", 3000, 1000, -1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rate(test.current, test.previous, test.intervalSeconds); got != test.want {
+				t.Errorf("rate(%v, %v, %v) = %v, want %v", test.current, test.previous, test.intervalSeconds, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewCPUStatus(t *testing.T) {
+	now := time.Now()
+	previousSample := &sample{
+		time: now.Add(-2 * time.Second),
+		cpuTimes: []cpuTimes{
+			{name: "cpu", busy: 300, total: 2000},
+			{name: "cpu0", busy: 225, total: 650},
+		},
+	}
+	currentSample := &sample{
+		time: now,
+		cpuTimes: []cpuTimes{
+			{name: "cpu", busy: 400, total: 2400},
+			{name: "cpu0", busy: 325, total: 850},
+			// A CPU brought online since the previous sample is measured since boot.
+			{name: "cpu1", busy: 20, total: 100},
+		},
+	}
+
+	want := &cpuStatus{
+		IntervalSeconds: 2,
+		Total:           cpuUsage{Name: "cpu", UsagePercent: 25},
+		CPUs: []cpuUsage{
+			{Name: "cpu0", UsagePercent: 50},
+			{Name: "cpu1", UsagePercent: 20},
+		},
+	}
+	if got := newCPUStatus(currentSample, previousSample); !reflect.DeepEqual(got, want) {
+		t.Errorf("newCPUStatus = %+v, want %+v", got, want)
+	}
+
+	want = &cpuStatus{
+		Total: cpuUsage{Name: "cpu", UsagePercent: percent(400, 2400)},
+		CPUs: []cpuUsage{
+			{Name: "cpu0", UsagePercent: percent(325, 850)},
+			{Name: "cpu1", UsagePercent: 20},
+		},
+	}
+	if got := newCPUStatus(currentSample, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("newCPUStatus without previous sample = %+v, want %+v", got, want)
+	}
+}
+
+func TestAddNetworkRates(t *testing.T) {
+	now := time.Now()
+	previousSample := &sample{
+		time: now.Add(-2 * time.Second),
+		network: map[string]networkCounters{
+			"eth0":  {receiveBytes: 1000, transmitBytes: 500},
+			"wlan0": {receiveBytes: 1 << 40, transmitBytes: 1 << 40},
+		},
+	}
+	currentSample := &sample{
+		time: now,
+	}
+	networkInterfaces := []networkInterface{
+		{Name: "eth0", ReceiveBytes: 5000, TransmitBytes: 1500},
+		// Counters reset, such as when the interface was recreated.
+		{Name: "wlan0", ReceiveBytes: 100, TransmitBytes: 100},
+		{Name: "usb0", ReceiveBytes: 100, TransmitBytes: 100},
+	}
+
+	addNetworkRates(networkInterfaces, currentSample, previousSample)
+
+	wantRates := []struct {
+		receive  float64
+		transmit float64
+	}{
+		{2000, 500},
+		{0, 0},
+		{0, 0},
+	}
+	for i, networkInterface := range networkInterfaces {
+		want := wantRates[i]
+		if (networkInterface.ReceiveBytesPerSecond != want.receive) || (networkInterface.TransmitBytesPerSecond != want.transmit) {
+			t.Errorf("%v rates = %v, %v, want %v, %v", networkInterface.Name,
+				networkInterface.ReceiveBytesPerSecond, networkInterface.TransmitBytesPerSecond, want.receive, want.transmit)
+		}
+	}
+}
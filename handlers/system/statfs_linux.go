@@ -0,0 +1,17 @@
+package system
+
+import "syscall"
+
+// statfs returns the total, free, and available to unprivileged users bytes of the
+// filesystem mounted at path.
+func statfs(path string) (totalBytes uint64, freeBytes uint64, availableBytes uint64, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(path, &stat); err != nil {
+		return
+	}
+	blockSize := uint64(stat.Bsize)
+	totalBytes = stat.Blocks * blockSize
+	freeBytes = stat.Bfree * blockSize
+	availableBytes = stat.Bavail * blockSize
+	return
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package system
+
+import (
+	"errors"
+	"runtime"
+)
+
+// statfs is only implemented on Linux, like the rest of the collector.
+func statfs(path string) (totalBytes uint64, freeBytes uint64, availableBytes uint64, err error) {
+	err = errors.New("statfs not supported on " + runtime.GOOS)
+	return
+}
@@ -0,0 +1,78 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/templates"
+	"github.com/aaronriekenberg/pi-web/utils"
+)
+
+type systemAPIResponse struct {
+	Now string `json:"now"`
+	*systemStatus
+}
+
+func systemAPIHandlerFunc(collector *collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		systemAPIResponse := &systemAPIResponse{
+			Now:          utils.FormatTime(time.Now()),
+			systemStatus: collector.collect(),
+		}
+
+		jsonText, err := json.Marshal(systemAPIResponse)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeApplicationJSON)
+		w.Header().Add(utils.CacheControlHeaderKey, utils.MaxAgeZero)
+		io.Copy(w, bytes.NewReader(jsonText))
+	}
+}
+
+func systemHTMLHandlerFunc(configuration *config.Configuration) (http.HandlerFunc, error) {
+	cacheControlValue := configuration.TemplatePageInfo.CacheControlValue
+
+	var builder strings.Builder
+	if err := templates.Templates.ExecuteTemplate(&builder, templates.SystemTemplateFile, nil); err != nil {
+		return nil, fmt.Errorf("error executing system template: %w", err)
+	}
+
+	htmlString := builder.String()
+	lastModified := time.Now()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(utils.CacheControlHeaderKey, cacheControlValue)
+		w.Header().Add(utils.ContentTypeHeaderKey, utils.ContentTypeTextHTML)
+		http.ServeContent(w, r, templates.SystemTemplateFile, lastModified, strings.NewReader(htmlString))
+	}, nil
+}
+
+// CreateSystemHandler registers /system.html and /api/system on serveMux if
+// configuration.SystemInfo is set.
+func CreateSystemHandler(configuration *config.Configuration, serveMux *http.ServeMux) error {
+	systemInfo := configuration.SystemInfo
+	if systemInfo == nil {
+		return nil
+	}
+
+	htmlHandlerFunc, err := systemHTMLHandlerFunc(configuration)
+	if err != nil {
+		return err
+	}
+
+	collector := newCollector(systemInfo.ProcRoot, systemInfo.SysRoot, systemInfo.Mounts)
+
+	serveMux.Handle("/system.html", htmlHandlerFunc)
+	serveMux.Handle("/api/system", systemAPIHandlerFunc(collector))
+
+	return nil
+}
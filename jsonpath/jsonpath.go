@@ -0,0 +1,138 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed path into a decoded JSON value such as $.items[0].name
+//
+// The leading $ is optional, and array indexes may be written as [0] or .0
+// so items.0.name is the same path.  Object keys containing . or [ can be
+// written as ["key"], with Go string escapes, or as ['key'], where \' and \\
+// are the only escapes.
+type Path struct {
+	expression string
+	keys       []string
+}
+
+func (path *Path) String() string {
+	return path.expression
+}
+
+// Parse parses a path expression.  An empty expression or $ refers to the whole value.
+func Parse(expression string) (*Path, error) {
+	path := &Path{
+		expression: expression,
+	}
+
+	remaining := strings.TrimPrefix(strings.TrimSpace(expression), "$")
+	for len(remaining) > 0 {
+		switch remaining[0] {
+		case '.':
+			remaining = remaining[1:]
+			end := strings.IndexAny(remaining, ".[")
+			if end < 0 {
+				end = len(remaining)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in json path %q", expression)
+			}
+			path.keys = append(path.keys, remaining[:end])
+			remaining = remaining[end:]
+
+		case '[':
+			if (len(remaining) > 1) && ((remaining[1] == '"') || (remaining[1] == '\'')) {
+				key, length, err := parseQuotedKey(remaining[1:])
+				if err != nil {
+					return nil, fmt.Errorf("%v in json path %q", err, expression)
+				}
+				remaining = remaining[1+length:]
+				if !strings.HasPrefix(remaining, "]") {
+					return nil, fmt.Errorf("missing ] after key %q in json path %q", key, expression)
+				}
+				path.keys = append(path.keys, key)
+				remaining = remaining[1:]
+				continue
+			}
+
+			end := strings.Index(remaining, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in json path %q", expression)
+			}
+			key := remaining[1:end]
+			if _, err := strconv.Atoi(key); err != nil {
+				return nil, fmt.Errorf("invalid index %q in json path %q", key, expression)
+			}
+			path.keys = append(path.keys, key)
+			remaining = remaining[end+1:]
+
+		default:
+			// Allow the first key without a leading dot.
+			if len(path.keys) > 0 {
+				return nil, fmt.Errorf("unexpected %q in json path %q", remaining, expression)
+			}
+			remaining = "." + remaining
+		}
+	}
+
+	return path, nil
+}
+
+// parseQuotedKey parses the key quoted at the start of s, returning it and the length
+// of s up to and including the closing quote.  The closing quote is found before
+// looking for the ] after it, so keys may contain ].
+func parseQuotedKey(s string) (key string, length int, err error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+
+		case quote:
+			length = i + 1
+			if quote == '"' {
+				if key, err = strconv.Unquote(s[:length]); err != nil {
+					return "", 0, fmt.Errorf("invalid key %v", s[:length])
+				}
+				return key, length, nil
+			}
+
+			var builder strings.Builder
+			for j := 1; j < i; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+				builder.WriteByte(s[j])
+			}
+			return builder.String(), length, nil
+		}
+	}
+	return "", 0, fmt.Errorf("missing closing %c", quote)
+}
+
+// Lookup returns the value at path in value, which must be decoded by encoding/json
+// into interface{}.  ok is false if the path does not exist.
+func (path *Path) Lookup(value interface{}) (result interface{}, ok bool) {
+	result = value
+	for _, key := range path.keys {
+		switch typedResult := result.(type) {
+		case map[string]interface{}:
+			if result, ok = typedResult[key]; !ok {
+				return nil, false
+			}
+
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if (err != nil) || (index < 0) || (index >= len(typedResult)) {
+				return nil, false
+			}
+			result = typedResult[index]
+
+		default:
+			return nil, false
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,115 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantKeys   []string
+	}{
+		{"", nil},
+		{"$", nil},
+		{"$.items[0].name", []string{"items", "0", "name"}},
+		{"items.0.name", []string{"items", "0", "name"}},
+		{"items[0]", []string{"items", "0"}},
+		{`$["a.b"]`, []string{"a.b"}},
+		{`$["a]b"]`, []string{"a]b"}},
+		{`$["a\"]b"]`, []string{`a"]b`}},
+		{`$['a]b']`, []string{"a]b"}},
+		{`$['a.b'].c`, []string{"a.b", "c"}},
+		{`$['it\'s']`, []string{"it's"}},
+		{`$['a\\']`, []string{`a\`}},
+		{`$['a"b']`, []string{`a"b`}},
+		{`$["it's"]`, []string{"it's"}},
+		{`$['[0]']['x']`, []string{"[0]", "x"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			path, err := Parse(test.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", test.expression, err)
+			}
+			if !reflect.DeepEqual(path.keys, test.wantKeys) {
+				t.Errorf("Parse(%q) keys = %q, want %q", test.expression, path.keys, test.wantKeys)
+			}
+			if path.String() != test.expression {
+				t.Errorf("Parse(%q).String() = %q", test.expression, path.String())
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantError  string
+	}{
+		{"$.", `empty key in json path "$."`},
+		{"$.a..b", `empty key in json path "$.a..b"`},
+		{"$[0", `missing ] in json path "$[0"`},
+		{"$[x]", `invalid index "x" in json path "$[x]"`},
+		{`$['a]`, `missing closing ' in json path "$['a]"`},
+		{`$["a]`, `missing closing " in json path "$[\"a]"`},
+		{`$['a'b]`, `missing ] after key "a" in json path "$['a'b]"`},
+		{`$['a']b`, `unexpected "b" in json path "$['a']b"`},
+		{`$["\q"]`, `invalid key "\q" in json path "$[\"\\q\"]"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Parse(test.expression)
+			if err == nil {
+				t.Fatalf("Parse(%q) error = nil, want %q", test.expression, test.wantError)
+			}
+			if err.Error() != test.wantError {
+				t.Errorf("Parse(%q) error = %q, want %q", test.expression, err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(`{
+		"items": [{"name": "first"}, {"name": "second"}],
+		"a]b": {"c.d": 1},
+		"count": 2
+	}`), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expression string
+		want       interface{}
+		wantOK     bool
+	}{
+		{"$.items[1].name", "second", true},
+		{"items.0.name", "first", true},
+		{`$['a]b']['c.d']`, float64(1), true},
+		{`$["a]b"]["c.d"]`, float64(1), true},
+		{"$.count", float64(2), true},
+		{"$.items[2]", nil, false},
+		{"$.items[-1]", nil, false},
+		{"$.items.name", nil, false},
+		{"$.count.value", nil, false},
+		{"$.missing", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			path, err := Parse(test.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", test.expression, err)
+			}
+			got, ok := path.Lookup(value)
+			if (ok != test.wantOK) || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Lookup(%q) = %v, %v, want %v, %v", test.expression, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBufferEntries is the number of entries kept in memory if not configured.
+const DefaultBufferEntries = 1000
+
+const (
+	LogApplication = "application"
+	LogAccess      = "access"
+)
+
+// BufferedEntry is an entry kept in memory.  ID increases by one with each entry
+// written, and Text is the entry as written without the trailing newline.
+type BufferedEntry struct {
+	ID      uint64 `json:"id"`
+	Time    string `json:"time"`
+	Log     string `json:"log"`
+	Level   Level  `json:"level"`
+	Message string `json:"msg"`
+	Text    string `json:"text"`
+}
+
+// entryBuffer is a ring buffer of the most recent application and access entries.
+type entryBuffer struct {
+	mutex   sync.Mutex
+	entries []BufferedEntry
+	next    int
+	full    bool
+	lastID  uint64
+	updated chan struct{}
+}
+
+var recentEntries = &entryBuffer{
+	entries: make([]BufferedEntry, DefaultBufferEntries),
+	updated: make(chan struct{}),
+}
+
+// orderedLocked returns the entries oldest first.
+func (entryBuffer *entryBuffer) orderedLocked() []BufferedEntry {
+	if !entryBuffer.full {
+		return entryBuffer.entries[:entryBuffer.next]
+	}
+	ordered := make([]BufferedEntry, 0, len(entryBuffer.entries))
+	ordered = append(ordered, entryBuffer.entries[entryBuffer.next:]...)
+	return append(ordered, entryBuffer.entries[:entryBuffer.next]...)
+}
+
+// resize changes the number of entries kept, keeping the most recent ones.
+func (entryBuffer *entryBuffer) resize(size int) {
+	entryBuffer.mutex.Lock()
+	defer entryBuffer.mutex.Unlock()
+
+	if size == len(entryBuffer.entries) {
+		return
+	}
+
+	ordered := entryBuffer.orderedLocked()
+	if len(ordered) > size {
+		ordered = ordered[len(ordered)-size:]
+	}
+	entries := make([]BufferedEntry, size)
+	copy(entries, ordered)
+
+	entryBuffer.entries = entries
+	entryBuffer.next = len(ordered) % size
+	entryBuffer.full = (len(ordered) == size)
+}
+
+func (entryBuffer *entryBuffer) add(log string, level Level, msg string, now time.Time, text []byte) {
+	entryBuffer.mutex.Lock()
+	defer entryBuffer.mutex.Unlock()
+
+	entryBuffer.lastID++
+	entryBuffer.entries[entryBuffer.next] = BufferedEntry{
+		ID:      entryBuffer.lastID,
+		Time:    now.Format(timeFormat),
+		Log:     log,
+		Level:   level,
+		Message: msg,
+		Text:    string(text),
+	}
+	entryBuffer.next++
+	if entryBuffer.next == len(entryBuffer.entries) {
+		entryBuffer.next = 0
+		entryBuffer.full = true
+	}
+
+	close(entryBuffer.updated)
+	entryBuffer.updated = make(chan struct{})
+}
+
+// BufferedEntries returns the entries in memory with an ID after afterID, oldest first,
+// and a channel that is closed when the next entry is added.
+func BufferedEntries(afterID uint64) ([]BufferedEntry, <-chan struct{}) {
+	recentEntries.mutex.Lock()
+	defer recentEntries.mutex.Unlock()
+
+	ordered := recentEntries.orderedLocked()
+	start := len(ordered)
+	for (start > 0) && (ordered[start-1].ID > afterID) {
+		start--
+	}
+	entries := make([]BufferedEntry, len(ordered)-start)
+	copy(entries, ordered[start:])
+	return entries, recentEntries.updated
+}
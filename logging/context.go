@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+)
+
+// RequestIDHeaderKey is the header a request ID is taken from and returned in.
+const RequestIDHeaderKey = "X-Request-ID"
+
+type contextKey struct{}
+
+type requestContext struct {
+	requestID string
+	logger    *Logger
+}
+
+// ContextWithRequestID returns a context whose logger adds requestID to every entry.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestContext{
+		requestID: requestID,
+		logger:    defaultLogger.With("requestID", requestID),
+	})
+}
+
+// RequestIDFromContext returns the request ID of ctx, or "" if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	if requestContext, ok := ctx.Value(contextKey{}).(*requestContext); ok {
+		return requestContext.requestID
+	}
+	return ""
+}
+
+// FromContext returns the logger for ctx, which adds the request ID if ctx has one.
+func FromContext(ctx context.Context) *Logger {
+	if requestContext, ok := ctx.Value(contextKey{}).(*requestContext); ok {
+		return requestContext.logger
+	}
+	return defaultLogger
+}
+
+// DetachedContext returns a background context with the request ID of ctx, for work
+// that outlives the request that started it.
+func DetachedContext(ctx context.Context) context.Context {
+	if requestContext, ok := ctx.Value(contextKey{}).(*requestContext); ok {
+		return context.WithValue(context.Background(), contextKey{}, requestContext)
+	}
+	return context.Background()
+}
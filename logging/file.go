@@ -0,0 +1,253 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotationOptions controls when a log file is rotated and how rotated files are kept.
+type RotationOptions struct {
+	// MaxSizeBytes rotates the file before it would grow over this size, 0 is unlimited.
+	MaxSizeBytes int64
+	// Daily rotates the file at the first write of each day.
+	Daily bool
+	// MaxBackups is the number of rotated files kept, 0 keeps all of them.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	dayFormat        = "2006-01-02"
+	compressedSuffix = ".gz"
+)
+
+// backgroundMutex serializes compressing and removing rotated files.
+var backgroundMutex sync.Mutex
+
+// rotatingFile is a log file that is rotated by size or day.  Rotated files are
+// renamed to the file name followed by the rotation time.
+type rotatingFile struct {
+	mutex    sync.Mutex
+	path     string
+	options  RotationOptions
+	file     *os.File
+	size     int64
+	openDay  string
+	failures int
+}
+
+func openRotatingFile(path string, options RotationOptions) (*rotatingFile, error) {
+	rotatingFile := &rotatingFile{
+		path:    path,
+		options: options,
+	}
+	if err := rotatingFile.open(); err != nil {
+		return nil, err
+	}
+	return rotatingFile, nil
+}
+
+// open opens path for appending.  The day of an existing file is the day it was
+// last written, so a file left from a previous day is rotated at the first write.
+func (rotatingFile *rotatingFile) open() error {
+	file, err := os.OpenFile(rotatingFile.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+
+	rotatingFile.file = file
+	rotatingFile.size = fileInfo.Size()
+	rotatingFile.openDay = fileInfo.ModTime().Format(dayFormat)
+	if rotatingFile.size == 0 {
+		rotatingFile.openDay = time.Now().Format(dayFormat)
+	}
+	return nil
+}
+
+func (rotatingFile *rotatingFile) closeFile() error {
+	if rotatingFile.file == nil {
+		return nil
+	}
+	err := rotatingFile.file.Close()
+	rotatingFile.file = nil
+	return err
+}
+
+func (rotatingFile *rotatingFile) shouldRotate(writeBytes int, now time.Time) bool {
+	if rotatingFile.size == 0 {
+		return false
+	}
+	if (rotatingFile.options.MaxSizeBytes > 0) && (rotatingFile.size+int64(writeBytes) > rotatingFile.options.MaxSizeBytes) {
+		return true
+	}
+	return rotatingFile.options.Daily && (now.Format(dayFormat) != rotatingFile.openDay)
+}
+
+// backupPath returns an unused name for the file rotated at now.
+func (rotatingFile *rotatingFile) backupPath(now time.Time) string {
+	backupPath := rotatingFile.path + "." + now.Format(backupTimeFormat)
+	for i := 1; ; i++ {
+		_, err := os.Lstat(backupPath)
+		_, compressedErr := os.Lstat(backupPath + compressedSuffix)
+		if os.IsNotExist(err) && os.IsNotExist(compressedErr) {
+			return backupPath
+		}
+		backupPath = fmt.Sprintf("%v.%v.%v", rotatingFile.path, now.Format(backupTimeFormat), i)
+	}
+}
+
+// rotate renames the file and opens a new one.  Compressing and removing old rotated
+// files happens in the background.
+func (rotatingFile *rotatingFile) rotate(now time.Time) error {
+	if err := rotatingFile.closeFile(); err != nil {
+		return err
+	}
+
+	backupPath := rotatingFile.backupPath(now)
+	if err := os.Rename(rotatingFile.path, backupPath); err != nil {
+		return fmt.Errorf("error renaming log file: %w", err)
+	}
+
+	if err := rotatingFile.open(); err != nil {
+		return err
+	}
+
+	go cleanUpBackups(rotatingFile.path, backupPath, rotatingFile.options)
+	return nil
+}
+
+func (rotatingFile *rotatingFile) Write(p []byte) (int, error) {
+	rotatingFile.mutex.Lock()
+	defer rotatingFile.mutex.Unlock()
+
+	now := time.Now()
+	if (rotatingFile.file != nil) && rotatingFile.shouldRotate(len(p), now) {
+		if err := rotatingFile.rotate(now); err != nil {
+			rotatingFile.reportFailure(err)
+		}
+	}
+	if rotatingFile.file == nil {
+		if err := rotatingFile.open(); err != nil {
+			rotatingFile.reportFailure(err)
+			return os.Stderr.Write(p)
+		}
+	}
+
+	n, err := rotatingFile.file.Write(p)
+	rotatingFile.size += int64(n)
+	if err != nil {
+		rotatingFile.reportFailure(err)
+	} else {
+		rotatingFile.failures = 0
+	}
+	return n, err
+}
+
+// reportFailure writes the first of consecutive errors to stderr, since it cannot be logged.
+func (rotatingFile *rotatingFile) reportFailure(err error) {
+	if rotatingFile.failures == 0 {
+		fmt.Fprintf(os.Stderr, "log file %v error: %v\n", rotatingFile.path, err)
+	}
+	rotatingFile.failures++
+}
+
+// Reopen closes and reopens the file, for use after it has been moved by an external tool.
+func (rotatingFile *rotatingFile) Reopen() error {
+	rotatingFile.mutex.Lock()
+	defer rotatingFile.mutex.Unlock()
+
+	rotatingFile.closeFile()
+	rotatingFile.failures = 0
+	return rotatingFile.open()
+}
+
+func (rotatingFile *rotatingFile) Close() error {
+	rotatingFile.mutex.Lock()
+	defer rotatingFile.mutex.Unlock()
+
+	return rotatingFile.closeFile()
+}
+
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(path+compressedSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(destination)
+	_, err = io.Copy(gzipWriter, source)
+	if closeErr := gzipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := destination.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressedSuffix)
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// cleanUpBackups compresses backupPath if options.Compress is set, then removes the
+// oldest rotated files of path over options.MaxBackups.
+func cleanUpBackups(path string, backupPath string, options RotationOptions) {
+	backgroundMutex.Lock()
+	defer backgroundMutex.Unlock()
+
+	if options.Compress {
+		if err := compressFile(backupPath); err != nil {
+			Error("error compressing rotated log file", "file", backupPath, "error", err)
+		}
+	}
+
+	if options.MaxBackups <= 0 {
+		return
+	}
+
+	directoryEntries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		Error("error reading log directory", "file", path, "error", err)
+		return
+	}
+
+	// Backup names start with the rotation time, so they sort oldest first.
+	backupPrefix := filepath.Base(path) + "."
+	var backupNames []string
+	for _, directoryEntry := range directoryEntries {
+		if directoryEntry.Type().IsRegular() && strings.HasPrefix(directoryEntry.Name(), backupPrefix) {
+			backupNames = append(backupNames, directoryEntry.Name())
+		}
+	}
+	sort.Strings(backupNames)
+
+	for len(backupNames) > options.MaxBackups {
+		oldestPath := filepath.Join(filepath.Dir(path), backupNames[0])
+		if err := os.Remove(oldestPath); err != nil {
+			Error("error removing rotated log file", "file", oldestPath, "error", err)
+		}
+		backupNames = backupNames[1:]
+	}
+}
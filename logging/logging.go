@@ -0,0 +1,429 @@
+// Package logging writes leveled, structured log entries in logfmt or JSON.
+// Entries have a time, level, and message followed by key value pairs.
+// Application entries go to stderr and access entries to stdout, unless
+// configured to go to rotated files.  The most recent entries are also kept in memory.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if (level >= LevelDebug) && (int(level) < len(levelNames)) {
+		return levelNames[level]
+	}
+	return strconv.Itoa(int(level))
+}
+
+// MarshalText writes level by name, such as in JSON.
+func (level Level) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// ParseLevel returns the Level named name.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if levelName == name {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %v", name, strings.Join(levelNames, ", "))
+}
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// CheckFormat returns an error if format is not FormatLogfmt or FormatJSON.
+func CheckFormat(format string) error {
+	if (format != FormatLogfmt) && (format != FormatJSON) {
+		return fmt.Errorf("unknown log format %q, expected %v or %v", format, FormatLogfmt, FormatJSON)
+	}
+	return nil
+}
+
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// output writes entries at or above level to file if set, otherwise to writer.
+// Entries written are also kept in recentEntries under name.
+type output struct {
+	mutex  sync.Mutex
+	name   string
+	writer io.Writer
+	file   *rotatingFile
+	format string
+	level  Level
+}
+
+// set replaces the settings of output, closing its previous file.
+func (output *output) set(format string, level Level, file *rotatingFile) {
+	output.mutex.Lock()
+	previousFile := output.file
+	output.format = format
+	output.level = level
+	output.file = file
+	output.mutex.Unlock()
+
+	if previousFile != nil {
+		previousFile.Close()
+	}
+}
+
+func (output *output) reopen() error {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if output.file == nil {
+		return nil
+	}
+	return output.file.Reopen()
+}
+
+func (output *output) enabled(level Level) bool {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	return level >= output.level
+}
+
+func (output *output) write(level Level, msg string, fields []interface{}, keyValues []interface{}) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if level < output.level {
+		return
+	}
+
+	now := time.Now()
+	var buffer bytes.Buffer
+	encoder := logfmtEncoder
+	if output.format == FormatJSON {
+		encoder = jsonEncoder
+	}
+
+	encoder.begin(&buffer)
+	encoder.field(&buffer, "time", now.Format(timeFormat))
+	encoder.field(&buffer, "level", level.String())
+	encoder.field(&buffer, "msg", msg)
+	for _, list := range [][]interface{}{fields, keyValues} {
+		for i := 0; i < len(list); i += 2 {
+			key := fmt.Sprint(list[i])
+			if i+1 >= len(list) {
+				encoder.field(&buffer, "!BADKEY", key)
+				break
+			}
+			encoder.field(&buffer, key, list[i+1])
+		}
+	}
+	encoder.end(&buffer)
+
+	if output.file != nil {
+		output.file.Write(buffer.Bytes())
+	} else {
+		output.writer.Write(buffer.Bytes())
+	}
+
+	recentEntries.add(output.name, level, msg, now, bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
+}
+
+var (
+	applicationOutput = &output{
+		name:   LogApplication,
+		writer: os.Stderr,
+		format: FormatLogfmt,
+		level:  LevelInfo,
+	}
+
+	accessOutput = &output{
+		name:   LogAccess,
+		writer: os.Stdout,
+		format: FormatLogfmt,
+		level:  LevelInfo,
+	}
+)
+
+// Options configures logging.  Format defaults to FormatLogfmt and Level, the minimum
+// level of application entries, to info.  Application entries are written to
+// ApplicationFile and access entries to AccessFile, rotated according to Rotation.
+// If a file is not set entries are written to stderr or stdout.  The most recent
+// BufferEntries entries, or DefaultBufferEntries if 0, are kept in memory.
+type Options struct {
+	Format          string
+	Level           string
+	ApplicationFile string
+	AccessFile      string
+	Rotation        RotationOptions
+	BufferEntries   int
+}
+
+func openOptionalFile(path string, rotation RotationOptions) (*rotatingFile, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return openRotatingFile(path, rotation)
+}
+
+// Configure applies options.  If an error is returned nothing has changed.
+func Configure(options Options) error {
+	format := FormatLogfmt
+	if len(options.Format) > 0 {
+		if err := CheckFormat(options.Format); err != nil {
+			return err
+		}
+		format = options.Format
+	}
+
+	level := LevelInfo
+	if len(options.Level) > 0 {
+		var err error
+		if level, err = ParseLevel(options.Level); err != nil {
+			return err
+		}
+	}
+
+	bufferEntries := DefaultBufferEntries
+	if options.BufferEntries > 0 {
+		bufferEntries = options.BufferEntries
+	}
+
+	applicationFile, err := openOptionalFile(options.ApplicationFile, options.Rotation)
+	if err != nil {
+		return err
+	}
+	accessFile, err := openOptionalFile(options.AccessFile, options.Rotation)
+	if err != nil {
+		if applicationFile != nil {
+			applicationFile.Close()
+		}
+		return err
+	}
+
+	applicationOutput.set(format, level, applicationFile)
+	accessOutput.set(format, LevelInfo, accessFile)
+	recentEntries.resize(bufferEntries)
+	return nil
+}
+
+// Reopen reopens the log files, for use after they have been moved by an external tool
+// such as logrotate.
+func Reopen() error {
+	applicationErr := applicationOutput.reopen()
+	accessErr := accessOutput.reopen()
+	if applicationErr != nil {
+		return applicationErr
+	}
+	return accessErr
+}
+
+// Logger writes entries with the key value pairs added by With.
+type Logger struct {
+	output *output
+	fields []interface{}
+}
+
+var (
+	defaultLogger = &Logger{
+		output: applicationOutput,
+	}
+
+	accessLogger = &Logger{
+		output: accessOutput,
+	}
+)
+
+// Default returns the application logger.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// Access returns the access logger.
+func Access() *Logger {
+	return accessLogger
+}
+
+// With returns a Logger adding keyValues to every entry.
+func (logger *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(logger.fields)+len(keyValues))
+	fields = append(fields, logger.fields...)
+	fields = append(fields, keyValues...)
+	return &Logger{
+		output: logger.output,
+		fields: fields,
+	}
+}
+
+// Enabled reports whether entries at level are written, to avoid building expensive values.
+func (logger *Logger) Enabled(level Level) bool {
+	return logger.output.enabled(level)
+}
+
+// Log writes an entry at level with msg and keyValues, which alternate keys and values.
+func (logger *Logger) Log(level Level, msg string, keyValues ...interface{}) {
+	logger.output.write(level, msg, logger.fields, keyValues)
+}
+
+func (logger *Logger) Debug(msg string, keyValues ...interface{}) {
+	logger.Log(LevelDebug, msg, keyValues...)
+}
+
+func (logger *Logger) Info(msg string, keyValues ...interface{}) {
+	logger.Log(LevelInfo, msg, keyValues...)
+}
+
+func (logger *Logger) Warn(msg string, keyValues ...interface{}) {
+	logger.Log(LevelWarn, msg, keyValues...)
+}
+
+func (logger *Logger) Error(msg string, keyValues ...interface{}) {
+	logger.Log(LevelError, msg, keyValues...)
+}
+
+func Debug(msg string, keyValues ...interface{}) {
+	defaultLogger.Log(LevelDebug, msg, keyValues...)
+}
+
+func Info(msg string, keyValues ...interface{}) {
+	defaultLogger.Log(LevelInfo, msg, keyValues...)
+}
+
+func Warn(msg string, keyValues ...interface{}) {
+	defaultLogger.Log(LevelWarn, msg, keyValues...)
+}
+
+func Error(msg string, keyValues ...interface{}) {
+	defaultLogger.Log(LevelError, msg, keyValues...)
+}
+
+// Fatal writes an error entry and exits.
+func Fatal(msg string, keyValues ...interface{}) {
+	defaultLogger.Log(LevelError, msg, keyValues...)
+	os.Exit(1)
+}
+
+// standardLogWriter writes each line from the standard library log package as a warning,
+// these come from packages such as net/http reporting errors.
+type standardLogWriter struct{}
+
+func (standardLogWriter) Write(p []byte) (int, error) {
+	defaultLogger.Warn(strings.TrimSuffix(string(p), "\n"), "source", "log")
+	return len(p), nil
+}
+
+// RedirectStandardLog sends output of the standard library log package to the application log.
+func RedirectStandardLog() {
+	log.SetFlags(0)
+	log.SetOutput(standardLogWriter{})
+}
+
+// fieldValue converts values with a more useful text form than their fields.
+func fieldValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case error:
+		return value.Error()
+	case time.Duration:
+		return value.String()
+	case time.Time:
+		return value.Format(timeFormat)
+	case fmt.Stringer:
+		return value.String()
+	}
+	return value
+}
+
+type encoder struct {
+	begin func(buffer *bytes.Buffer)
+	field func(buffer *bytes.Buffer, key string, value interface{})
+	end   func(buffer *bytes.Buffer)
+}
+
+func marshalJSON(value interface{}) []byte {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		jsonBytes, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	return jsonBytes
+}
+
+var jsonEncoder = encoder{
+	begin: func(buffer *bytes.Buffer) {
+		buffer.WriteByte('{')
+	},
+	field: func(buffer *bytes.Buffer, key string, value interface{}) {
+		if buffer.Len() > 1 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(marshalJSON(key))
+		buffer.WriteByte(':')
+		buffer.Write(marshalJSON(fieldValue(value)))
+	},
+	end: func(buffer *bytes.Buffer) {
+		buffer.WriteString("}\n")
+	},
+}
+
+func logfmtNeedsQuoting(s string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, r := range s {
+		if (r <= ' ') || (r == '=') || (r == '"') || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func logfmtString(s string) string {
+	if logfmtNeedsQuoting(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var logfmtEncoder = encoder{
+	begin: func(buffer *bytes.Buffer) {},
+	field: func(buffer *bytes.Buffer, key string, value interface{}) {
+		if buffer.Len() > 0 {
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(logfmtString(key))
+		buffer.WriteByte('=')
+
+		switch value := fieldValue(value).(type) {
+		case nil:
+			buffer.WriteString("null")
+		case string:
+			buffer.WriteString(logfmtString(value))
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			fmt.Fprint(buffer, value)
+		default:
+			// Values such as structs and slices are written as JSON.
+			buffer.WriteString(logfmtString(string(marshalJSON(value))))
+		}
+	},
+	end: func(buffer *bytes.Buffer) {
+		buffer.WriteByte('\n')
+	},
+}
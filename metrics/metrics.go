@@ -0,0 +1,319 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the
+// Prometheus text exposition format.  Metrics are created as package variables so
+// they are registered once and keep counting across configuration reloads.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the histogram bucket upper bounds used for durations in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	writeText(builder *strings.Builder)
+}
+
+type registry struct {
+	mutex   sync.Mutex
+	names   map[string]bool
+	metrics []metric
+}
+
+var defaultRegistry = &registry{
+	names: make(map[string]bool),
+}
+
+// register adds metric to the registry.  Names are registered during package
+// initialization, so a duplicate is a programming error and panics.
+func (registry *registry) register(name string, metric metric) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.names[name] {
+		panic(fmt.Sprintf("metric %v registered twice", name))
+	}
+	registry.names[name] = true
+	registry.metrics = append(registry.metrics, metric)
+}
+
+// WriteText writes every registered metric in the text exposition format.
+func WriteText(w io.Writer) error {
+	defaultRegistry.mutex.Lock()
+	metrics := defaultRegistry.metrics
+	defaultRegistry.mutex.Unlock()
+
+	var builder strings.Builder
+	for _, metric := range metrics {
+		metric.writeText(&builder)
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeHeader(builder *strings.Builder, name string, help string, metricType string) {
+	fmt.Fprintf(builder, "# HELP %v %v\n", name, helpReplacer.Replace(help))
+	fmt.Fprintf(builder, "# TYPE %v %v\n", name, metricType)
+}
+
+// writeSample writes one sample line.  extraLabelName, if not empty, is added after
+// labelNames, as for the le label of histogram buckets.
+func writeSample(
+	builder *strings.Builder, name string, labelNames []string, labelValues []string,
+	extraLabelName string, extraLabelValue string, value float64) {
+
+	builder.WriteString(name)
+	if (len(labelNames) > 0) || (len(extraLabelName) > 0) {
+		builder.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				builder.WriteByte(',')
+			}
+			fmt.Fprintf(builder, "%v=\"%v\"", labelName, labelValueReplacer.Replace(labelValues[i]))
+		}
+		if len(extraLabelName) > 0 {
+			if len(labelNames) > 0 {
+				builder.WriteByte(',')
+			}
+			fmt.Fprintf(builder, "%v=\"%v\"", extraLabelName, extraLabelValue)
+		}
+		builder.WriteByte('}')
+	}
+	builder.WriteByte(' ')
+	builder.WriteString(formatFloat(value))
+	builder.WriteByte('\n')
+}
+
+// desc is the name, help and label names shared by the metric types.
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+// seriesKey returns the key of the series with labelValues, which must have one
+// value for each label name.
+func (desc *desc) seriesKey(labelValues []string) string {
+	if len(labelValues) != len(desc.labelNames) {
+		panic(fmt.Sprintf("metric %v has %v labels, got %v values", desc.name, len(desc.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\x00")
+}
+
+type valueSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// valueVec holds the series of a counter or gauge.
+type valueVec struct {
+	desc
+	metricType string
+	mutex      sync.Mutex
+	series     map[string]*valueSeries
+}
+
+func newValueVec(name string, help string, metricType string, labelNames []string) *valueVec {
+	valueVec := &valueVec{
+		desc: desc{
+			name:       name,
+			help:       help,
+			labelNames: labelNames,
+		},
+		metricType: metricType,
+		series:     make(map[string]*valueSeries),
+	}
+	defaultRegistry.register(name, valueVec)
+	return valueVec
+}
+
+func (valueVec *valueVec) update(labelValues []string, update func(*valueSeries)) {
+	key := valueVec.seriesKey(labelValues)
+
+	valueVec.mutex.Lock()
+	defer valueVec.mutex.Unlock()
+
+	series, ok := valueVec.series[key]
+	if !ok {
+		series = &valueSeries{
+			labelValues: append([]string(nil), labelValues...),
+		}
+		valueVec.series[key] = series
+	}
+	update(series)
+}
+
+func (valueVec *valueVec) writeText(builder *strings.Builder) {
+	writeHeader(builder, valueVec.name, valueVec.help, valueVec.metricType)
+
+	valueVec.mutex.Lock()
+	defer valueVec.mutex.Unlock()
+
+	keys := make([]string, 0, len(valueVec.series))
+	for key := range valueVec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := valueVec.series[key]
+		writeSample(builder, valueVec.name, valueVec.labelNames, series.labelValues, "", "", series.value)
+	}
+}
+
+// Counter is a value that only goes up, with one series per combination of label values.
+type Counter struct {
+	valueVec *valueVec
+}
+
+// NewCounter registers a counter with labelNames.
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{
+		valueVec: newValueVec(name, help, "counter", labelNames),
+	}
+}
+
+// Add adds value, which must not be negative, to the series with labelValues.
+func (counter *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("counter %v decreased", counter.valueVec.name))
+	}
+	counter.valueVec.update(labelValues, func(series *valueSeries) {
+		series.value += value
+	})
+}
+
+// Inc adds 1 to the series with labelValues.
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+// Gauge is a value that goes up and down, with one series per combination of label values.
+type Gauge struct {
+	valueVec *valueVec
+}
+
+// NewGauge registers a gauge with labelNames.
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{
+		valueVec: newValueVec(name, help, "gauge", labelNames),
+	}
+}
+
+// Set sets the series with labelValues to value.
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	gauge.valueVec.update(labelValues, func(series *valueSeries) {
+		series.value = value
+	})
+}
+
+// Add adds value, which may be negative, to the series with labelValues.
+func (gauge *Gauge) Add(value float64, labelValues ...string) {
+	gauge.valueVec.update(labelValues, func(series *valueSeries) {
+		series.value += value
+	})
+}
+
+type histogramSeries struct {
+	labelValues  []string
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+// Histogram counts observations in buckets, with one series per combination of label values.
+type Histogram struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram registers a histogram with labelNames.  buckets are the upper bounds
+// of the buckets in increasing order, the +Inf bucket is added.
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	histogram := &Histogram{
+		desc: desc{
+			name:       name,
+			help:       help,
+			labelNames: labelNames,
+		},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	defaultRegistry.register(name, histogram)
+	return histogram
+}
+
+// Observe adds value to the series with labelValues.
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	key := histogram.seriesKey(labelValues)
+
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	series, ok := histogram.series[key]
+	if !ok {
+		series = &histogramSeries{
+			labelValues:  append([]string(nil), labelValues...),
+			bucketCounts: make([]uint64, len(histogram.buckets)),
+		}
+		histogram.series[key] = series
+	}
+
+	if i := sort.SearchFloat64s(histogram.buckets, value); i < len(histogram.buckets) {
+		series.bucketCounts[i]++
+	}
+	series.count++
+	series.sum += value
+}
+
+func (histogram *Histogram) writeText(builder *strings.Builder) {
+	writeHeader(builder, histogram.name, histogram.help, "histogram")
+
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+
+	keys := make([]string, 0, len(histogram.series))
+	for key := range histogram.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := histogram.series[key]
+
+		var cumulativeCount uint64
+		for i, upperBound := range histogram.buckets {
+			cumulativeCount += series.bucketCounts[i]
+			writeSample(builder, histogram.name+"_bucket", histogram.labelNames, series.labelValues,
+				"le", formatFloat(upperBound), float64(cumulativeCount))
+		}
+		writeSample(builder, histogram.name+"_bucket", histogram.labelNames, series.labelValues,
+			"le", "+Inf", float64(series.count))
+		writeSample(builder, histogram.name+"_sum", histogram.labelNames, series.labelValues, "", "", series.sum)
+		writeSample(builder, histogram.name+"_count", histogram.labelNames, series.labelValues, "", "", float64(series.count))
+	}
+}
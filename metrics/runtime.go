@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/aaronriekenberg/pi-web/environment"
+)
+
+// runtimeMetrics reads the Go runtime statistics when metrics are written, so one
+// runtime.ReadMemStats call covers all of them.
+type runtimeMetrics struct {
+	startTime   time.Time
+	environment *environment.Environment
+}
+
+func init() {
+	defaultRegistry.register("go_runtime", &runtimeMetrics{
+		startTime:   time.Now(),
+		environment: environment.GetEnvironment(),
+	})
+}
+
+func writeValue(builder *strings.Builder, name string, help string, metricType string, value float64) {
+	writeHeader(builder, name, help, metricType)
+	writeSample(builder, name, nil, nil, "", "", value)
+}
+
+func (runtimeMetrics *runtimeMetrics) writeText(builder *strings.Builder) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	environment := runtimeMetrics.environment
+	writeHeader(builder, "pi_web_build_info", "Build information, the value is always 1.", "gauge")
+	writeSample(builder, "pi_web_build_info",
+		[]string{"git_commit", "go_version", "goos", "goarch"},
+		[]string{environment.GitCommit, environment.GoVersion, environment.GoOS, environment.GoArch},
+		"", "", 1)
+
+	writeValue(builder, "process_start_time_seconds", "Start time of the process since the unix epoch in seconds.", "gauge",
+		float64(runtimeMetrics.startTime.UnixNano())/float64(time.Second))
+	writeValue(builder, "go_goroutines", "Number of goroutines that currently exist.", "gauge",
+		float64(runtime.NumGoroutine()))
+	writeValue(builder, "go_threads", "Number of OS threads created.", "gauge",
+		float64(pprof.Lookup("threadcreate").Count()))
+	writeValue(builder, "go_gomaxprocs", "Value of GOMAXPROCS.", "gauge",
+		float64(runtime.GOMAXPROCS(0)))
+	writeValue(builder, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge",
+		float64(memStats.Alloc))
+	writeValue(builder, "go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter",
+		float64(memStats.TotalAlloc))
+	writeValue(builder, "go_memstats_sys_bytes", "Number of bytes obtained from the system.", "gauge",
+		float64(memStats.Sys))
+	writeValue(builder, "go_memstats_heap_inuse_bytes", "Number of heap bytes in use.", "gauge",
+		float64(memStats.HeapInuse))
+	writeValue(builder, "go_memstats_heap_objects", "Number of allocated objects.", "gauge",
+		float64(memStats.HeapObjects))
+	writeValue(builder, "go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", "gauge",
+		float64(memStats.StackInuse))
+	writeValue(builder, "go_memstats_next_gc_bytes", "Heap size when the next garbage collection will take place.", "gauge",
+		float64(memStats.NextGC))
+	writeValue(builder, "go_memstats_last_gc_time_seconds", "Time of the last garbage collection since the unix epoch in seconds.", "gauge",
+		float64(memStats.LastGC)/float64(time.Second))
+	writeValue(builder, "go_gc_cycles_total", "Number of completed garbage collection cycles.", "counter",
+		float64(memStats.NumGC))
+	writeValue(builder, "go_gc_pause_seconds_total", "Total time garbage collection has stopped the world in seconds.", "counter",
+		float64(memStats.PauseTotalNs)/float64(time.Second))
+}
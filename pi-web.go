@@ -1,16 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-
-	"github.com/kr/pretty"
+	"time"
 
 	"github.com/aaronriekenberg/pi-web/config"
 	"github.com/aaronriekenberg/pi-web/environment"
 	"github.com/aaronriekenberg/pi-web/handlers"
+	"github.com/aaronriekenberg/pi-web/logging"
 	"github.com/aaronriekenberg/pi-web/servers"
 )
 
@@ -18,33 +19,94 @@ func awaitShutdownSignal() {
 	sig := make(chan os.Signal, 2)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	s := <-sig
-	log.Fatalf("Signal (%v) received, stopping", s)
+	logging.Info("signal received, stopping", "signal", s)
 }
 
-func main() {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+func shutdown(
+	configuration *config.Configuration,
+	runningServers *servers.Servers,
+	reloadableHandler *handlers.ReloadableHandler,
+) {
+	shutdownTimeout := time.Duration(configuration.ShutdownTimeoutMilliseconds) * time.Millisecond
+	logging.Info("shutting down", "shutdownTimeout", shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := runningServers.Shutdown(ctx); err != nil {
+		logging.Error("runningServers.Shutdown error", "error", err)
+	}
 
-	if len(os.Args) != 2 {
-		log.Fatalf("Usage: %v <config json file>", os.Args[0])
+	if err := reloadableHandler.Shutdown(ctx); err != nil {
+		logging.Error("reloadableHandler.Shutdown error", "error", err)
 	}
 
-	configFile := os.Args[1]
+	logging.Info("shutdown complete")
+}
 
-	configuration := config.ReadConfiguration(configFile)
-	log.Printf("configuration:\n%# v", pretty.Formatter(configuration))
+// configureLogging applies configuration.LogInfo.  If an error is returned the previous
+// logging configuration is kept.
+func configureLogging(configuration *config.Configuration) error {
+	logInfo := &configuration.LogInfo
+	options := logging.Options{
+		Format:        logInfo.Format,
+		Level:         logInfo.Level,
+		BufferEntries: logInfo.BufferEntries,
+	}
+	if fileInfo := logInfo.FileInfo; fileInfo != nil {
+		options.ApplicationFile = fileInfo.ApplicationLogPath()
+		options.AccessFile = fileInfo.AccessLogPath()
+		options.Rotation = logging.RotationOptions{
+			MaxSizeBytes: fileInfo.MaxSizeBytes,
+			Daily:        fileInfo.RotateDaily,
+			MaxBackups:   fileInfo.MaxBackups,
+			Compress:     fileInfo.Compress,
+		}
+	}
+	return logging.Configure(options)
+}
 
-	log.Printf("environment:\n%# v", pretty.Formatter(environment.GetEnvironment()))
+func serve(configFile string) {
+	configuration, err := config.ReadConfiguration(configFile)
+	if err != nil {
+		logging.Fatal("config.ReadConfiguration error", "error", err)
+	}
+	if err := configureLogging(configuration); err != nil {
+		logging.Fatal("configureLogging error", "error", err)
+	}
+	logging.RedirectStandardLog()
+	go handleReopenLogsSignal()
 
-	serveHandler := handlers.CreateHandlers(
+	logging.Info("configuration", "configuration", configuration.Redacted())
+
+	logging.Info("environment", "environment", environment.GetEnvironment())
+
+	serveHandler, err := handlers.CreateHandlers(
 		configuration,
 	)
+	if err != nil {
+		logging.Fatal("handlers.CreateHandlers error", "error", err)
+	}
 
-	servers.StartServers(
+	reloadableHandler := handlers.NewReloadableHandler(serveHandler)
+
+	runningServers := servers.StartServers(
 		configuration.ServerInfoList,
-		serveHandler,
+		reloadableHandler,
 	)
 
-	log.Printf("after StartServers")
+	logging.Info("after StartServers")
+
+	reloader := newConfigurationReloader(configFile, configuration, reloadableHandler)
+	reloader.start()
 
 	awaitShutdownSignal()
+
+	shutdown(reloader.currentConfiguration(), runningServers, reloadableHandler)
+}
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+
+	runSubcommand(os.Args[0], os.Args[1:])
 }
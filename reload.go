@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kr/pretty"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/handlers"
+	"github.com/aaronriekenberg/pi-web/logging"
+)
+
+// configurationReloader re-reads the configuration file and swaps newly built
+// handlers into reloadableHandler.  The running servers are not restarted, so
+// changes to serverInfoList are only logged.
+type configurationReloader struct {
+	configFile        string
+	reloadableHandler *handlers.ReloadableHandler
+
+	mutex         sync.Mutex
+	configuration *config.Configuration
+}
+
+func newConfigurationReloader(
+	configFile string,
+	configuration *config.Configuration,
+	reloadableHandler *handlers.ReloadableHandler,
+) *configurationReloader {
+	return &configurationReloader{
+		configFile:        configFile,
+		reloadableHandler: reloadableHandler,
+		configuration:     configuration,
+	}
+}
+
+func (reloader *configurationReloader) currentConfiguration() *config.Configuration {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	return reloader.configuration
+}
+
+func (reloader *configurationReloader) reload() {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	logging.Info("reloading configuration", "file", reloader.configFile)
+
+	newConfiguration, err := config.ReadConfiguration(reloader.configFile)
+	if err != nil {
+		logging.Error("reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	newHandler, err := handlers.CreateHandlers(newConfiguration)
+	if err != nil {
+		logging.Error("reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	if err := configureLogging(newConfiguration); err != nil {
+		newHandler.Close()
+		logging.Error("reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	// Secrets are redacted before diffing, so changes to them are reported without the values.
+	diffs := pretty.Diff(reloader.configuration.Redacted(), newConfiguration.Redacted())
+	switch {
+	case len(diffs) > 0:
+		for _, diff := range diffs {
+			logging.Info("configuration changed", "diff", diff)
+		}
+	case !reflect.DeepEqual(reloader.configuration, newConfiguration):
+		logging.Info("configuration changed", "diff", "redacted values changed")
+	default:
+		logging.Info("configuration unchanged")
+	}
+
+	if !reflect.DeepEqual(reloader.configuration.ServerInfoList, newConfiguration.ServerInfoList) {
+		logging.Warn("serverInfoList changes will not take effect until restart")
+	}
+
+	reloader.reloadableHandler.Store(newHandler)
+	reloader.configuration = newConfiguration
+
+	logging.Info("reload complete")
+}
+
+func (reloader *configurationReloader) handleReloadSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for s := range sig {
+		logging.Info("signal received, reloading", "signal", s)
+		reloader.reload()
+	}
+}
+
+// watchConfigFile polls the configuration file and reloads when its
+// modification time or size changes.
+func (reloader *configurationReloader) watchConfigFile(interval time.Duration) {
+	logging.Info("watching configuration file", "file", reloader.configFile, "interval", interval)
+
+	var lastModTime time.Time
+	var lastSize int64
+	if fileInfo, err := os.Stat(reloader.configFile); err == nil {
+		lastModTime = fileInfo.ModTime()
+		lastSize = fileInfo.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fileInfo, err := os.Stat(reloader.configFile)
+		if err != nil {
+			logging.Warn("watchConfigFile stat error", "error", err)
+			continue
+		}
+
+		if fileInfo.ModTime().Equal(lastModTime) && (fileInfo.Size() == lastSize) {
+			continue
+		}
+		lastModTime = fileInfo.ModTime()
+		lastSize = fileInfo.Size()
+
+		reloader.reload()
+	}
+}
+
+func (reloader *configurationReloader) start() {
+	go reloader.handleReloadSignal()
+
+	reloadInfo := reloader.configuration.ConfigurationReloadInfo
+	if reloadInfo.WatchConfigFile {
+		go reloader.watchConfigFile(
+			time.Duration(reloadInfo.WatchIntervalMilliseconds) * time.Millisecond)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aaronriekenberg/pi-web/logging"
+)
+
+// handleReopenLogsSignal reopens the log files on SIGUSR1, after an external tool
+// such as logrotate has moved them.
+func handleReopenLogsSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	for s := range sig {
+		if err := logging.Reopen(); err != nil {
+			logging.Error("logging.Reopen error", "signal", s, "error", err)
+		} else {
+			logging.Info("signal received, reopened log files", "signal", s)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// handleReopenLogsSignal does nothing, Windows has no SIGUSR1.  Log files are still
+// rotated by size and daily if configured.
+func handleReopenLogsSignal() {
+}
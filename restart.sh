@@ -3,10 +3,12 @@
 KILL_CMD=pkill
 CONFIG_FILE=configfiles/$(hostname -s)-config.json
 
+./pi-web check-config $CONFIG_FILE || exit 1
+
 $KILL_CMD pi-web
 
 sleep 2
 
 export PATH=${HOME}/bin:$PATH
 
-nohup ./pi-web $CONFIG_FILE 2>&1 | go-simplerotate logs &
+nohup ./pi-web serve $CONFIG_FILE 2>&1 | go-simplerotate logs &
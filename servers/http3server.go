@@ -1,26 +1,85 @@
 package servers
 
 import (
+	"context"
 	"crypto/tls"
-	"log"
+	"errors"
 	"net"
 	"net/http"
+	"sync"
 
-	"github.com/kr/pretty"
 	"github.com/lucas-clemente/quic-go/http3"
 
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
 )
 
+// inFlightRequests counts running requests so the QUIC server, which has no
+// graceful shutdown of its own, can be drained before it is closed.
+type inFlightRequests struct {
+	mutex    sync.Mutex
+	count    int
+	draining bool
+	drained  chan struct{}
+}
+
+func newInFlightRequests() *inFlightRequests {
+	return &inFlightRequests{
+		drained: make(chan struct{}),
+	}
+}
+
+// begin returns false once drain has been called.
+func (requests *inFlightRequests) begin() bool {
+	requests.mutex.Lock()
+	defer requests.mutex.Unlock()
+
+	if requests.draining {
+		return false
+	}
+	requests.count++
+	return true
+}
+
+func (requests *inFlightRequests) end() {
+	requests.mutex.Lock()
+	defer requests.mutex.Unlock()
+
+	requests.count--
+	if requests.draining && requests.count == 0 {
+		close(requests.drained)
+	}
+}
+
+// drain stops new requests and waits for running requests to complete or ctx to be done.
+func (requests *inFlightRequests) drain(ctx context.Context) error {
+	requests.mutex.Lock()
+	if !requests.draining {
+		requests.draining = true
+		if requests.count == 0 {
+			close(requests.drained)
+		}
+	}
+	requests.mutex.Unlock()
+
+	select {
+	case <-requests.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // See https://github.com/lucas-clemente/quic-go/blob/master/http3/server.go#L492
 // This function is needed so we can set quicServer.Port to http3ServerInfo.OverrideAltSvcPortValue.
 // Also read and write timeouts are set on the TCP http server to http3ServerInfo.HTTPServerTimeouts.
-func runHTTP3Server(
+// On shutdown the TCP server is shut down first, then in-flight requests are drained before the QUIC server is closed.
+func (servers *Servers) runHTTP3Server(
 	http3ServerInfo config.HTTP3ServerInfo,
 	handler http.Handler,
 ) error {
 
-	log.Printf("runHTTP3Server http3ServerInfo:\n%# v", pretty.Formatter(http3ServerInfo))
+	logging.Info("runHTTP3Server", "http3ServerInfo", http3ServerInfo)
 
 	// Load certs
 	var err error
@@ -74,13 +133,37 @@ func runHTTP3Server(
 		quicServer.Port = uint32(*http3ServerInfo.OverrideAltSvcPortValue)
 	}
 
+	requests := newInFlightRequests()
+
 	httpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requests.begin() {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer requests.end()
+
 		quicServer.SetQuicHeaders(w.Header())
 		handler.ServeHTTP(w, r)
 	})
 
-	hErr := make(chan error)
-	qErr := make(chan error)
+	if !servers.registerShutdownFunc(func(ctx context.Context) error {
+		httpErr := shutdownHTTPServer(ctx, httpServer)
+		drainErr := requests.drain(ctx)
+		quicErr := quicServer.Close()
+
+		if httpErr != nil {
+			return httpErr
+		}
+		if drainErr != nil {
+			return drainErr
+		}
+		return quicErr
+	}) {
+		return http.ErrServerClosed
+	}
+
+	hErr := make(chan error, 1)
+	qErr := make(chan error, 1)
 	go func() {
 		hErr <- httpServer.Serve(tlsConn)
 	}()
@@ -90,10 +173,15 @@ func runHTTP3Server(
 
 	select {
 	case err := <-hErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			// Shutdown closes the QUIC server once in-flight requests are drained.
+			<-qErr
+			return err
+		}
 		quicServer.Close()
 		return err
 	case err := <-qErr:
-		// Cannot close the HTTP server or wait for requests to complete properly :/
+		httpServer.Close()
 		return err
 	}
 }
@@ -1,20 +1,29 @@
 package servers
 
 import (
-	"log"
+	"context"
 	"net/http"
 
-	"github.com/kr/pretty"
-
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
 )
 
-func runHTTPServer(
+// shutdownHTTPServer gracefully shuts down server, forcibly closing any
+// connections still active when ctx is done.
+func shutdownHTTPServer(ctx context.Context, server *http.Server) error {
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+	}
+	return err
+}
+
+func (servers *Servers) runHTTPServer(
 	httpServerInfo config.HTTPServerInfo,
 	serveHandler http.Handler,
 ) error {
 
-	log.Printf("runHTTPServer httpServerInfo:\n%# v", pretty.Formatter(httpServerInfo))
+	logging.Info("runHTTPServer", "httpServerInfo", httpServerInfo)
 
 	server := &http.Server{
 		Addr:    httpServerInfo.ListenAddress,
@@ -22,6 +31,12 @@ func runHTTPServer(
 	}
 	httpServerInfo.HTTPServerTimeouts.ApplyToHTTPServer(server)
 
+	if !servers.registerShutdownFunc(func(ctx context.Context) error {
+		return shutdownHTTPServer(ctx, server)
+	}) {
+		return http.ErrServerClosed
+	}
+
 	if httpServerInfo.TLSInfo != nil {
 		return server.ListenAndServeTLS(
 			httpServerInfo.TLSInfo.CertFile,
@@ -1,41 +1,110 @@
 package servers
 
 import (
-	"log"
+	"context"
+	"errors"
 	"net/http"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
 
 	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/logging"
 )
 
-func runServer(serverInfo config.ServerInfo, serveHandler http.Handler) {
+type shutdownFunc func(ctx context.Context) error
+
+// Servers is a handle to the servers started by StartServers.
+type Servers struct {
+	mutex         sync.Mutex
+	shuttingDown  bool
+	shutdownFuncs []shutdownFunc
+}
+
+// registerShutdownFunc returns false if Shutdown has already been called,
+// in which case the caller should not start serving.
+func (servers *Servers) registerShutdownFunc(f shutdownFunc) bool {
+	servers.mutex.Lock()
+	defer servers.mutex.Unlock()
+
+	if servers.shuttingDown {
+		return false
+	}
+
+	servers.shutdownFuncs = append(servers.shutdownFuncs, f)
+	return true
+}
+
+func (servers *Servers) isShuttingDown() bool {
+	servers.mutex.Lock()
+	defer servers.mutex.Unlock()
+
+	return servers.shuttingDown
+}
+
+// Shutdown stops all servers, waiting for in-flight requests to complete
+// until ctx is done.  Connections still open at that point are closed.
+func (servers *Servers) Shutdown(ctx context.Context) error {
+	servers.mutex.Lock()
+	servers.shuttingDown = true
+	shutdownFuncs := servers.shutdownFuncs
+	servers.shutdownFuncs = nil
+	servers.mutex.Unlock()
+
+	var group errgroup.Group
+	for _, f := range shutdownFuncs {
+		f := f
+		group.Go(func() error {
+			return f(ctx)
+		})
+	}
+	return group.Wait()
+}
+
+func (servers *Servers) checkServerError(serverType string, err error) {
+	if errors.Is(err, http.ErrServerClosed) || servers.isShuttingDown() {
+		logging.Info("server stopped", "serverType", serverType, "error", err)
+		return
+	}
+
+	logging.Fatal("server error", "serverType", serverType, "error", err)
+}
+
+func (servers *Servers) runServer(serverInfo config.ServerInfo, serveHandler http.Handler) {
 	if serverInfo.HTTP3ServerInfo != nil {
-		log.Fatalf(
-			"runHTTP3Server error %v",
-			runHTTP3Server(
+		servers.checkServerError(
+			"runHTTP3Server",
+			servers.runHTTP3Server(
 				*serverInfo.HTTP3ServerInfo,
 				serveHandler,
 			),
 		)
+		return
 	}
 
 	if serverInfo.HTTPServerInfo != nil {
-		log.Fatalf(
-			"runHTTPServer error %v",
-			runHTTPServer(
+		servers.checkServerError(
+			"runHTTPServer",
+			servers.runHTTPServer(
 				*serverInfo.HTTPServerInfo,
 				serveHandler,
 			),
 		)
+		return
 	}
 
-	log.Fatalf("invalid serverInfo %+v", serverInfo)
+	logging.Fatal("invalid serverInfo", "serverInfo", serverInfo)
 }
 
 func StartServers(
 	serverInfoList []config.ServerInfo,
 	serveHandler http.Handler,
-) {
+) *Servers {
+	servers := &Servers{}
+
 	for _, serverInfo := range serverInfoList {
-		go runServer(serverInfo, serveHandler)
+		go servers.runServer(serverInfo, serveHandler)
 	}
+
+	return servers
 }
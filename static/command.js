@@ -10,7 +10,13 @@ const handleFetchResponse = (jsonObject) => {
     for (const arg of (jsonObject.commandInfo.args || [])) {
         commandAndArgsString += ` ${arg}`;
     }
-    let preText = `Now: ${jsonObject.now}\n\n`;
+    let nowText = jsonObject.now;
+    if (jsonObject.cached) {
+        nowText += ' (cached)';
+    } else if (jsonObject.shared) {
+        nowText += ' (shared)';
+    }
+    let preText = `Now: ${nowText}\n\n`;
     preText += `Command Duration: ${jsonObject.commandDuration}\n\n`;
     preText += `$ ${commandAndArgsString}\n\n`;
     preText += jsonObject.commandOutput;
@@ -42,7 +48,152 @@ const setTimer = (apiPath) => {
     }, 1000);
 };
 
+const handleHistoryFetchResponse = (jsonObject) => {
+    let preText = `Now: ${jsonObject.now}\n\n`;
+    const history = jsonObject.history || [];
+    if (history.length === 0) {
+        preText += 'No scheduled runs yet.';
+    }
+    for (const entry of history.slice().reverse()) {
+        preText += `${entry.now} (${entry.commandDuration})`;
+        if (entry.errorCategory) {
+            preText += ` [${entry.errorCategory}]`;
+        }
+        preText += `\n${entry.commandOutput}\n\n`;
+    }
+    updatePre(preText);
+};
+
+const fetchHistoryData = async (historyPath) => {
+    try {
+        const response = await fetch(historyPath, {
+            method: 'GET',
+            headers: {
+                'Accept': 'application/json'
+            }
+        });
+        const jsonObject = await response.json();
+        handleHistoryFetchResponse(jsonObject);
+    } catch (error) {
+        console.error('fetch error:', error);
+    }
+};
+
+const setHistoryTimer = (historyPath) => {
+    const checkbox = document.getElementById('autoRefresh');
+
+    setInterval(() => {
+        if (checkbox.checked) {
+            fetchHistoryData(historyPath);
+        }
+    }, 5000);
+};
+
+const streamData = (commandText, apiPath, parametersString) => {
+    const checkbox = document.getElementById('autoRefresh');
+
+    let headerText = `Now:\n\n`;
+    headerText += `Command Duration:\n\n`;
+    headerText += `$ ${commandText}\n\n`;
+    let outputText = '';
+    updatePre(headerText);
+
+    const restartIfAutoRefresh = () => {
+        if (checkbox.checked) {
+            setTimeout(() => streamData(commandText, apiPath, parametersString), 1000);
+        }
+    };
+
+    const appendLine = (event) => {
+        outputText += `${event.data}\n`;
+        updatePre(headerText + outputText);
+    };
+
+    let streamPath = `${apiPath}/stream`;
+    if (parametersString) {
+        streamPath += `?${parametersString}`;
+    }
+    const eventSource = new EventSource(streamPath);
+
+    eventSource.addEventListener('stdout', appendLine);
+    eventSource.addEventListener('stderr', appendLine);
+
+    eventSource.addEventListener('exit', (event) => {
+        eventSource.close();
+
+        const exitObject = JSON.parse(event.data);
+        headerText = `Now: ${exitObject.now}\n\n`;
+        headerText += `Command Duration: ${exitObject.commandDuration}\n\n`;
+        headerText += `$ ${commandText}\n\n`;
+        if (exitObject.outputTruncated) {
+            outputText += `\n[output truncated, ${exitObject.outputBytes} bytes]`;
+        }
+        outputText += `\n[exit status ${exitObject.exitStatus}]`;
+        if (exitObject.error) {
+            outputText += ` ${exitObject.error}`;
+        }
+        updatePre(headerText + outputText);
+
+        restartIfAutoRefresh();
+    });
+
+    eventSource.onerror = (error) => {
+        console.error('stream error:', error);
+        eventSource.close();
+        restartIfAutoRefresh();
+    };
+};
+
+const fillParametersForm = (pageParams) => {
+    const form = document.getElementById('parametersForm');
+    if (!form) {
+        return;
+    }
+    for (const [name, value] of pageParams) {
+        const element = form.elements[name];
+        if (element) {
+            element.value = value;
+        }
+    }
+};
+
 const onload = (commandText, apiPath) => {
+    const pageParams = new URLSearchParams(window.location.search);
+    const streamMode = (pageParams.get('mode') === 'stream');
+    const historyMode = (pageParams.get('mode') === 'history');
+    pageParams.delete('mode');
+
+    fillParametersForm(pageParams);
+
+    const parametersString = pageParams.toString();
+    const modeLink = document.getElementById('modeLink');
+    const historyLink = document.getElementById('historyLink');
+
+    if (historyMode) {
+        historyLink.href = '?';
+        historyLink.innerText = 'Run';
+
+        const historyPath = `${apiPath}/history`;
+        fetchHistoryData(historyPath);
+        setHistoryTimer(historyPath);
+        return;
+    }
+
+    if (streamMode) {
+        modeLink.href = `?${parametersString}`;
+        modeLink.innerText = 'Poll';
+
+        streamData(commandText, apiPath, parametersString);
+        return;
+    }
+
+    pageParams.set('mode', 'stream');
+    modeLink.href = `?${pageParams.toString()}`;
+
+    if (parametersString) {
+        apiPath += `?${parametersString}`;
+    }
+
     let preText = `Now:\n\n`;
     preText += `Command Duration:\n\n`;
     preText += `$ ${commandText}`;
@@ -0,0 +1,120 @@
+// The most entries shown, older entries are dropped while following.
+const maxDisplayedEntries = 1000;
+
+const updatePre = (text) => {
+    const preCollection = document.getElementsByTagName('pre');
+    for (i = 0; i < preCollection.length; ++i) {
+        preCollection[i].innerText = text;
+    }
+};
+
+const entriesText = (entries) => {
+    let text = '';
+    for (const entry of entries) {
+        text += `${entry.text}\n`;
+    }
+    return text;
+};
+
+const handleFetchResponse = (jsonObject) => {
+    const entries = (jsonObject.entries || []).slice(-maxDisplayedEntries);
+    let preText = `Now: ${jsonObject.now}\n\n`;
+    if (entries.length === 0) {
+        preText += 'No matching log entries.';
+    }
+    preText += entriesText(entries);
+    updatePre(preText);
+};
+
+const fetchData = async (apiPath) => {
+    try {
+        const response = await fetch(apiPath, {
+            method: 'GET',
+            headers: {
+                'Accept': 'application/json'
+            }
+        });
+        const jsonObject = await response.json();
+        handleFetchResponse(jsonObject);
+    } catch (error) {
+        console.error('fetch error:', error);
+    }
+};
+
+const setTimer = (apiPath) => {
+    const checkbox = document.getElementById('autoRefresh');
+
+    setInterval(() => {
+        if (checkbox.checked) {
+            fetchData(apiPath);
+        }
+    }, 1000);
+};
+
+const followData = (apiPath) => {
+    const checkbox = document.getElementById('autoRefresh');
+
+    const entries = [];
+    updatePre('Following...\n\n');
+
+    // EventSource reconnects by itself, sending the ID of the last entry received.
+    const eventSource = new EventSource(apiPath);
+
+    // Entries are still received while auto refresh is off, but not shown until it is on.
+    eventSource.addEventListener('entry', (event) => {
+        entries.push(JSON.parse(event.data));
+        if (entries.length > maxDisplayedEntries) {
+            entries.shift();
+        }
+        if (checkbox.checked) {
+            updatePre(`Following...\n\n${entriesText(entries)}`);
+        }
+    });
+
+    eventSource.onerror = (error) => {
+        console.error('stream error:', error);
+    };
+};
+
+const fillFilterForm = (pageParams) => {
+    const form = document.getElementById('filterForm');
+    for (const [name, value] of pageParams) {
+        const element = form.elements[name];
+        if (element) {
+            element.value = value;
+        }
+    }
+};
+
+const onload = (apiPath) => {
+    const pageParams = new URLSearchParams(window.location.search);
+    const followMode = (pageParams.get('mode') === 'follow');
+
+    fillFilterForm(pageParams);
+    pageParams.delete('mode');
+
+    const modeLink = document.getElementById('modeLink');
+
+    if (followMode) {
+        modeLink.href = `?${pageParams.toString()}`;
+        modeLink.innerText = 'Poll';
+
+        pageParams.set('follow', 'true');
+        followData(`${apiPath}?${pageParams.toString()}`);
+        return;
+    }
+
+    const filterString = pageParams.toString();
+    pageParams.set('mode', 'follow');
+    modeLink.href = `?${pageParams.toString()}`;
+
+    if (filterString) {
+        apiPath += `?${filterString}`;
+    }
+
+    updatePre('Now:\n\n');
+
+    fetchData(apiPath);
+
+    setTimer(apiPath);
+};
@@ -0,0 +1,51 @@
+const healthStatusText = {
+    pass: 'pass',
+    fail: 'FAIL',
+    unknown: 'not checked'
+};
+
+const updateHealth = (jsonObject) => {
+    let failCount = 0;
+    for (const proxyHealth of jsonObject.proxies) {
+        if (proxyHealth.status === 'fail') {
+            ++failCount;
+        }
+
+        const span = document.getElementById(`health_${proxyHealth.id}`);
+        if (!span) {
+            continue;
+        }
+        let text = healthStatusText[proxyHealth.status];
+        if (proxyHealth.circuitBreaker && (proxyHealth.circuitBreaker.state !== 'closed')) {
+            text += `, circuit ${proxyHealth.circuitBreaker.state}`;
+        }
+        span.innerText = `[${text}]`;
+        span.title = (proxyHealth.reasons || []).join('\n');
+    }
+
+    const summary = document.getElementById('healthSummary');
+    if (summary) {
+        summary.innerText = (failCount === 0) ? 'All proxies healthy' : `${failCount} proxies failing`;
+    }
+};
+
+const fetchHealth = async () => {
+    try {
+        const response = await fetch('/api/health', {
+            method: 'GET',
+            headers: {
+                'Accept': 'application/json'
+            }
+        });
+        const jsonObject = await response.json();
+        updateHealth(jsonObject);
+    } catch (error) {
+        console.error('fetch error:', error);
+    }
+};
+
+const onload = () => {
+    fetchHealth();
+
+    setInterval(fetchHealth, 10000);
+};
@@ -8,13 +8,44 @@ const updatePre = (text) => {
     }
 };
 
+const circuitBreakerText = (circuitBreaker) => {
+    let text = `${circuitBreaker.state} (${circuitBreaker.consecutiveFailures} consecutive failures)`;
+    if (circuitBreaker.nextProbeAt) {
+        text += `, next probe at ${circuitBreaker.nextProbeAt}`;
+    }
+    return text;
+};
+
 const handleFetchResponse = (jsonObject) => {
     let preText = `Now: ${jsonObject.now}\n\n`;
     preText += `Proxy Duration: ${jsonObject.proxyDuration}\n\n`;
-    preText += `GET ${jsonObject.proxyInfo.url}\n\n`;
+    preText += `${jsonObject.proxyInfo.method || 'GET'} ${jsonObject.proxyInfo.url}\n\n`;
+    if (jsonObject.attempts > 1) {
+        preText += `Attempts: ${jsonObject.attempts}\n\n`;
+    }
+    if (jsonObject.circuitBreaker) {
+        preText += `Circuit Breaker: ${circuitBreakerText(jsonObject.circuitBreaker)}\n\n`;
+    }
+    if (jsonObject.proxyError) {
+        preText += `Error: ${jsonObject.proxyError}\n`;
+        updatePre(preText);
+        return;
+    }
     preText += `Response Status: ${jsonObject.proxyStatus}\n\n`;
+    if (jsonObject.verdict.passed) {
+        preText += 'Verdict: pass\n\n';
+    } else {
+        preText += `Verdict: FAIL\n  ${jsonObject.verdict.reasons.join('\n  ')}\n\n`;
+    }
     preText += `Response Headers:\n${stringifyPretty(jsonObject.proxyRespHeaders)}\n\n`;
+    preText += `Response Body (${jsonObject.proxyOutputFormat}):\n`;
+    if (jsonObject.proxyOutputError) {
+        preText += `[${jsonObject.proxyOutputError}]\n`;
+    }
     preText += jsonObject.proxyOutput;
+    if (jsonObject.proxyTruncated) {
+        preText += `\n\n[body truncated, ${jsonObject.proxyBodyBytes} bytes]`;
+    }
     updatePre(preText);
 }
 
@@ -44,6 +75,15 @@ const setTimer = (apiPath) => {
 };
 
 const onload = (requestText, apiPath) => {
+    const pageParams = new URLSearchParams(window.location.search);
+    if (pageParams.get('raw') === 'true') {
+        const rawLink = document.getElementById('rawLink');
+        rawLink.href = '?';
+        rawLink.innerText = 'Formatted';
+
+        apiPath += '?raw=true';
+    }
+
     let preText = `Now:\n\n`;
     preText += `Proxy Duration:\n\n`;
     preText += `${requestText}\n\n`;
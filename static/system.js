@@ -0,0 +1,132 @@
+const updatePre = (text) => {
+    const preCollection = document.getElementsByTagName('pre');
+    for (i = 0; i < preCollection.length; ++i) {
+        preCollection[i].innerText = text;
+    }
+};
+
+const byteUnits = ['B', 'KiB', 'MiB', 'GiB', 'TiB'];
+
+const formatBytes = (bytes) => {
+    let unitIndex = 0;
+    while ((bytes >= 1024) && (unitIndex < (byteUnits.length - 1))) {
+        bytes /= 1024;
+        ++unitIndex;
+    }
+    return `${bytes.toFixed(unitIndex === 0 ? 0 : 1)} ${byteUnits[unitIndex]}`;
+};
+
+const formatPercent = (percent) => `${percent.toFixed(1)}%`;
+
+const loadAverageText = (loadAverage) => {
+    let text = `Load Average: ${loadAverage.oneMinute} ${loadAverage.fiveMinutes} ${loadAverage.fifteenMinutes}`;
+    text += ` (${loadAverage.runnableTasks}/${loadAverage.tasks} tasks)\n\n`;
+    return text;
+};
+
+const cpuText = (cpu) => {
+    const interval = (cpu.intervalSeconds > 0) ? `last ${cpu.intervalSeconds.toFixed(1)} sec` : 'since boot';
+    let text = `CPU Usage (${interval}): ${formatPercent(cpu.total.usagePercent)}\n`;
+    for (const cpuUsage of (cpu.cpus || [])) {
+        text += `  ${cpuUsage.name}: ${formatPercent(cpuUsage.usagePercent)}\n`;
+    }
+    return `${text}\n`;
+};
+
+const memoryText = (memory) => {
+    let text = `Memory: ${formatPercent(memory.usedPercent)} used\n`;
+    text += `  Total: ${formatBytes(memory.totalBytes)}\n`;
+    text += `  Available: ${formatBytes(memory.availableBytes)}\n`;
+    text += `  Free: ${formatBytes(memory.freeBytes)}\n`;
+    text += `  Buffers: ${formatBytes(memory.buffersBytes)}\n`;
+    text += `  Cached: ${formatBytes(memory.cachedBytes)}\n`;
+    text += `  Swap: ${formatBytes(memory.swapTotalBytes - memory.swapFreeBytes)} used of ${formatBytes(memory.swapTotalBytes)}\n\n`;
+    return text;
+};
+
+const temperaturesText = (temperatures) => {
+    let text = 'Temperatures:\n';
+    for (const temperature of temperatures) {
+        const type = temperature.type ? ` (${temperature.type})` : '';
+        text += `  ${temperature.zone}${type}: ${temperature.celsius.toFixed(1)}'C\n`;
+    }
+    return `${text}\n`;
+};
+
+const filesystemsText = (filesystems) => {
+    let text = 'Filesystems:\n';
+    for (const filesystem of filesystems) {
+        text += `  ${filesystem.mount}: ${formatPercent(filesystem.usedPercent)} used,`;
+        text += ` ${formatBytes(filesystem.availableBytes)} available of ${formatBytes(filesystem.totalBytes)}\n`;
+    }
+    return `${text}\n`;
+};
+
+const networkInterfacesText = (networkInterfaces) => {
+    let text = 'Network Interfaces:\n';
+    for (const networkInterface of networkInterfaces) {
+        text += `  ${networkInterface.name}:`;
+        text += ` rx ${formatBytes(networkInterface.receiveBytesPerSecond)}/s (${formatBytes(networkInterface.receiveBytes)} total, ${networkInterface.receiveErrors} errors)`;
+        text += ` tx ${formatBytes(networkInterface.transmitBytesPerSecond)}/s (${formatBytes(networkInterface.transmitBytes)} total, ${networkInterface.transmitErrors} errors)\n`;
+    }
+    return `${text}\n`;
+};
+
+const handleFetchResponse = (jsonObject) => {
+    let preText = `Now: ${jsonObject.now}\n\n`;
+    if (jsonObject.loadAverage) {
+        preText += loadAverageText(jsonObject.loadAverage);
+    }
+    if (jsonObject.cpu) {
+        preText += cpuText(jsonObject.cpu);
+    }
+    if (jsonObject.memory) {
+        preText += memoryText(jsonObject.memory);
+    }
+    if (jsonObject.temperatures && (jsonObject.temperatures.length > 0)) {
+        preText += temperaturesText(jsonObject.temperatures);
+    }
+    if (jsonObject.filesystems && (jsonObject.filesystems.length > 0)) {
+        preText += filesystemsText(jsonObject.filesystems);
+    }
+    if (jsonObject.networkInterfaces && (jsonObject.networkInterfaces.length > 0)) {
+        preText += networkInterfacesText(jsonObject.networkInterfaces);
+    }
+    if (jsonObject.errors) {
+        preText += `Errors:\n  ${jsonObject.errors.join('\n  ')}\n`;
+    }
+    updatePre(preText);
+};
+
+const fetchData = async (apiPath) => {
+    try {
+        const response = await fetch(apiPath, {
+            method: 'GET',
+            headers: {
+                'Accept': 'application/json'
+            }
+        });
+        const jsonObject = await response.json();
+        handleFetchResponse(jsonObject);
+    } catch (error) {
+        console.error('fetch error:', error);
+    }
+};
+
+const setTimer = (apiPath) => {
+    const checkbox = document.getElementById('autoRefresh');
+
+    setInterval(() => {
+        if (checkbox.checked) {
+            fetchData(apiPath);
+        }
+    }, 1000);
+};
+
+const onload = (apiPath) => {
+    updatePre('Now:\n\n');
+
+    fetchData(apiPath);
+
+    setTimer(apiPath);
+};
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aaronriekenberg/pi-web/config"
+	"github.com/aaronriekenberg/pi-web/environment"
+	"github.com/aaronriekenberg/pi-web/handlers"
+	"github.com/aaronriekenberg/pi-web/handlers/command"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+type subcommand struct {
+	name     string
+	argsText string
+	minArgs  int
+	maxArgs  int
+	hidden   bool
+	run      func(args []string)
+}
+
+const unlimitedArgs = -1
+
+var subcommands = []subcommand{
+	{
+		name:     "serve",
+		argsText: "<config json file>",
+		minArgs:  1,
+		maxArgs:  1,
+		run: func(args []string) {
+			serve(args[0])
+		},
+	},
+	{
+		name:     "check-config",
+		argsText: "<config json file>",
+		minArgs:  1,
+		maxArgs:  1,
+		run: func(args []string) {
+			if !checkConfig(args[0]) {
+				os.Exit(1)
+			}
+		},
+	},
+	{
+		name:     "version",
+		argsText: "",
+		minArgs:  0,
+		maxArgs:  0,
+		run: func(args []string) {
+			printVersion()
+		},
+	},
+	{
+		name:     "run-command",
+		argsText: "<config json file> <command id> [<parameter name>=<value> ...]",
+		minArgs:  2,
+		maxArgs:  unlimitedArgs,
+		run: func(args []string) {
+			runCommand(args[0], args[1], args[2:])
+		},
+	},
+	{
+		name:     "hash-password",
+		argsText: "",
+		minArgs:  0,
+		maxArgs:  0,
+		run: func(args []string) {
+			hashPassword()
+		},
+	},
+	{
+		// Used internally to run commands with resource limits.
+		name:    command.ResourceLimitsHelperSubcommand,
+		minArgs: 5,
+		maxArgs: unlimitedArgs,
+		hidden:  true,
+		run: func(args []string) {
+			command.RunResourceLimitsHelper(args)
+		},
+	},
+}
+
+func usage(programName string) {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "Usage:")
+	for _, subcommand := range subcommands {
+		if subcommand.hidden {
+			continue
+		}
+		fmt.Fprintf(&buffer, "\n  %v %v", programName, subcommand.name)
+		if len(subcommand.argsText) > 0 {
+			fmt.Fprintf(&buffer, " %v", subcommand.argsText)
+		}
+	}
+	log.Fatal(buffer.String())
+}
+
+func runSubcommand(programName string, args []string) {
+	if len(args) == 0 {
+		usage(programName)
+	}
+
+	for _, subcommand := range subcommands {
+		if subcommand.name == args[0] {
+			numArgs := len(args) - 1
+			if (numArgs < subcommand.minArgs) ||
+				((subcommand.maxArgs != unlimitedArgs) && (numArgs > subcommand.maxArgs)) {
+				usage(programName)
+			}
+			subcommand.run(args[1:])
+			return
+		}
+	}
+
+	// "pi-web <config json file>" is the same as "pi-web serve <config json file>"
+	if len(args) == 1 {
+		serve(args[0])
+		return
+	}
+
+	usage(programName)
+}
+
+// checkConfig reads and validates configFile, then checks what can only be
+// verified on this host: TLS key pairs load, command binaries are found,
+// and handlers can be created.  Each problem is printed to stderr.
+func checkConfig(configFile string) bool {
+	configuration, err := config.ReadConfiguration(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
+	}
+
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	checkTLSInfo := func(path string, tlsInfo *config.TLSInfo) {
+		if _, err := tls.LoadX509KeyPair(tlsInfo.CertFile, tlsInfo.KeyFile); err != nil {
+			addProblem("%v: %v", path, err)
+		}
+	}
+
+	for i, serverInfo := range configuration.ServerInfoList {
+		if serverInfo.HTTP3ServerInfo != nil {
+			checkTLSInfo(fmt.Sprintf("serverInfoList[%v].http3ServerInfo.tlsInfo", i), &serverInfo.HTTP3ServerInfo.TLSInfo)
+		}
+		if (serverInfo.HTTPServerInfo != nil) && (serverInfo.HTTPServerInfo.TLSInfo != nil) {
+			checkTLSInfo(fmt.Sprintf("serverInfoList[%v].httpServerInfo.tlsInfo", i), serverInfo.HTTPServerInfo.TLSInfo)
+		}
+	}
+
+	for i, commandInfo := range configuration.CommandConfiguration.Commands {
+		if _, err := exec.LookPath(commandInfo.Command); err != nil {
+			addProblem("commandConfiguration.commands[%v].command: %v", i, err)
+		}
+	}
+
+	if _, err := handlers.CreateHandlers(configuration); err != nil {
+		addProblem("%v", err)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%v: %v problem(s):\n", configFile, len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  %v\n", problem)
+		}
+		return false
+	}
+
+	fmt.Printf("%v: OK\n", configFile)
+	return true
+}
+
+func printVersion() {
+	environment := environment.GetEnvironment()
+
+	gitCommit := environment.GitCommit
+	if len(gitCommit) == 0 {
+		gitCommit = "unknown"
+	}
+
+	fmt.Printf("gitCommit: %v\n", gitCommit)
+	fmt.Printf("goVersion: %v %v/%v\n", environment.GoVersion, environment.GoOS, environment.GoArch)
+}
+
+// hashPassword reads a password from the terminal without echoing it, or from the
+// first line of stdin if it is not a terminal, and prints its bcrypt hash for use
+// as an authInfo user passwordHash.
+func hashPassword() {
+	var password string
+	if stdinFD := int(os.Stdin.Fd()); term.IsTerminal(stdinFD) {
+		fmt.Fprintf(os.Stderr, "password: ")
+		passwordBytes, err := term.ReadPassword(stdinFD)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalf("error reading password %v", err)
+		}
+		password = string(passwordBytes)
+	} else {
+		var err error
+		password, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if (err != nil) && (err != io.EOF) {
+			log.Fatalf("error reading password %v", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+	}
+	if len(password) == 0 {
+		log.Fatalf("password must not be empty")
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("bcrypt.GenerateFromPassword error %v", err)
+	}
+	fmt.Println(string(passwordHash))
+}
+
+func runCommand(configFile string, commandID string, parameterArgs []string) {
+	configuration, err := config.ReadConfiguration(configFile)
+	if err != nil {
+		log.Fatalf("config.ReadConfiguration error %v", err)
+	}
+
+	values := make(url.Values)
+	for _, parameterArg := range parameterArgs {
+		equalsIndex := strings.Index(parameterArg, "=")
+		if equalsIndex < 0 {
+			log.Fatalf("invalid parameter %q, expected <parameter name>=<value>", parameterArg)
+		}
+		values.Add(parameterArg[:equalsIndex], parameterArg[equalsIndex+1:])
+	}
+
+	jsonText, err := command.RunCommand(configuration, commandID, values)
+	if err != nil {
+		log.Fatalf("command.RunCommand error %v", err)
+	}
+
+	var formattedJSONBuffer bytes.Buffer
+	if err := json.Indent(&formattedJSONBuffer, jsonText, "", "  "); err != nil {
+		log.Fatalf("error indenting command json %v", err)
+	}
+	formattedJSONBuffer.WriteRune('\n')
+
+	formattedJSONBuffer.WriteTo(os.Stdout)
+}
@@ -2,7 +2,9 @@ package templates
 
 import (
 	"html/template"
+	"io"
 	"path/filepath"
+	"sync"
 )
 
 const (
@@ -11,12 +13,54 @@ const (
 	CommandTemplateFile = "command.html"
 	ProxyTemplateFile   = "proxy.html"
 	DebugTemplateFile   = "debug.html"
+	LogsTemplateFile    = "logs.html"
+	SystemTemplateFile  = "system.html"
 )
 
-var Templates = template.Must(
-	template.ParseFiles(
+var funcMap = template.FuncMap{
+	"deref": func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	},
+}
+
+// templateSet parses the template files when Load is called or they are first used,
+// so packages importing templates do not need them to be present, such as in their tests.
+type templateSet struct {
+	once      sync.Once
+	templates *template.Template
+	err       error
+}
+
+func (templateSet *templateSet) parse() {
+	templateSet.templates, templateSet.err = template.New("").Funcs(funcMap).ParseFiles(
 		filepath.Join(templatesDirectory, MainTemplateFile),
 		filepath.Join(templatesDirectory, CommandTemplateFile),
 		filepath.Join(templatesDirectory, ProxyTemplateFile),
 		filepath.Join(templatesDirectory, DebugTemplateFile),
-	))
+		filepath.Join(templatesDirectory, LogsTemplateFile),
+		filepath.Join(templatesDirectory, SystemTemplateFile),
+	)
+}
+
+// Load parses the template files if they have not been parsed yet, and returns the
+// error if they could not be, so a missing or invalid template stops startup rather
+// than failing the first request that uses it.
+func Load() error {
+	Templates.once.Do(Templates.parse)
+	return Templates.err
+}
+
+// ExecuteTemplate applies the template named name to data, writing the output to w.
+// It returns an error if the template files could not be parsed.
+func (templateSet *templateSet) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	templateSet.once.Do(templateSet.parse)
+	if templateSet.err != nil {
+		return templateSet.err
+	}
+	return templateSet.templates.ExecuteTemplate(w, name, data)
+}
+
+var Templates = &templateSet{}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+)
+
+// ParseIPNet parses an IP address or CIDR block.  An IP address is parsed as the
+// block containing only that address.
+func ParseIPNet(s string) (*net.IPNet, error) {
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address or CIDR block", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(len(ip)*8, len(ip)*8),
+	}, nil
+}
+
+// ParseIPNets parses each of list with ParseIPNet.
+func ParseIPNets(list []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		ipNet, err := ParseIPNet(s)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+// IPNetsContain reports whether any of ipNets contains ip.
+func IPNetsContain(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the IP address of addr, a host:port such as http.Request.RemoteAddr.
+func RemoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
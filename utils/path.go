@@ -0,0 +1,24 @@
+package utils
+
+import "strings"
+
+// PathPattern matches a path exactly, or if it was written with a trailing * every
+// path starting with the rest of it.
+type PathPattern struct {
+	Path   string
+	Prefix bool
+}
+
+func NewPathPattern(pattern string) PathPattern {
+	return PathPattern{
+		Path:   strings.TrimSuffix(pattern, "*"),
+		Prefix: strings.HasSuffix(pattern, "*"),
+	}
+}
+
+func (pattern PathPattern) Matches(path string) bool {
+	if pattern.Prefix {
+		return strings.HasPrefix(path, pattern.Path)
+	}
+	return path == pattern.Path
+}
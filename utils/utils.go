@@ -3,11 +3,14 @@ package utils
 import "time"
 
 const (
+	AgeHeaderKey               = "age"
 	CacheControlHeaderKey      = "cache-control"
 	MaxAgeZero                 = "max-age=0"
+	NoCache                    = "no-cache"
 	ContentTypeHeaderKey       = "content-type"
 	ContentTypeTextHTML        = "text/html"
 	ContentTypeTextPlain       = "text/plain"
+	ContentTypeTextEventStream = "text/event-stream"
 	ContentTypeApplicationJSON = "application/json"
 )
 
@@ -16,3 +19,8 @@ const timeFormat = "Mon Jan 2 15:04:05.000000000 -0700 MST 2006"
 func FormatTime(t time.Time) string {
 	return t.Format(timeFormat)
 }
+
+// MethodAllower is implemented by handlers that allow HTTP methods other than GET and HEAD.
+type MethodAllower interface {
+	AllowsMethod(method string) bool
+}